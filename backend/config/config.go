@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -47,13 +48,118 @@ type NATSConfig struct {
 }
 
 type RAGConfig struct {
-	Provider string      `mapstructure:"provider"`
-	CTRAG    CTRAGConfig `mapstructure:"ct_rag"`
+	Provider string         `mapstructure:"provider"`
+	CTRAG    CTRAGConfig    `mapstructure:"ct_rag"`
+	Local    LocalRAGConfig `mapstructure:"local_rag"`
+	// Fallbacks are tried in order when the provider above fails on reads
+	Fallbacks       []RAGConfig   `mapstructure:"fallbacks"`
+	FallbackTimeout time.Duration `mapstructure:"fallback_timeout"`
+	// Deterministic makes retrieval reproducible, for tests only
+	Deterministic bool `mapstructure:"deterministic"`
+	// Migration dual-writes to a target provider while switching providers
+	Migration RAGMigrationConfig `mapstructure:"migration"`
+	// Instances are named providers a knowledge base can be created in
+	Instances map[string]RAGConfig `mapstructure:"instances"`
+	// DefaultSimilarityThreshold is applied when a query leaves its threshold at 0,
+	// fallbacks, instances and the migration target inherit it unless they set their own
+	DefaultSimilarityThreshold float64 `mapstructure:"default_similarity_threshold"`
+	// MaxDocumentBytes is the largest document in bytes a provider takes, larger ones are rejected
+	// with ErrDocumentTooLarge or split. 0 means no limit, fallbacks, instances and the migration
+	// target inherit it unless they set their own
+	MaxDocumentBytes int `mapstructure:"max_document_bytes"`
+	// GroupFilterDatasets refuse queries without group IDs, like KnowledgeBaseOptions.EnforceGroupFilter
+	GroupFilterDatasets []string `mapstructure:"group_filter_datasets"`
+	// QueryCache caches QueryRecords results in front of all providers
+	QueryCache RAGQueryCacheConfig `mapstructure:"query_cache"`
+	// Markdown tunes the conversion of html documents before upload
+	Markdown RAGMarkdownConfig `mapstructure:"markdown"`
+	// Chunking is the default of the chunk fields of an upsert
+	Chunking RAGChunkingConfig `mapstructure:"chunking"`
+}
+
+type RAGChunkingConfig struct {
+	// Size in bytes cuts documents into chunks before upload, 0 leaves chunking to the provider
+	Size int `mapstructure:"size"`
+	// Overlap is how many bytes of a chunk repeat at the start of the next
+	Overlap int `mapstructure:"overlap"`
+	// Strategy is "markdown-heading" (default), "fixed" or "sentence"
+	Strategy string `mapstructure:"strategy"`
+}
+
+type RAGMarkdownConfig struct {
+	// Locale of the documents, "zh" and "ja" join lines broken between CJK characters
+	// instead of converting the break to a space
+	Locale string `mapstructure:"locale"`
+	// DisableEscaping keeps markdown characters such as * and _ in text unescaped
+	DisableEscaping bool `mapstructure:"disable_escaping"`
+	// BaseURL resolves relative links and images, e.g. /static/uploads/a.png, empty keeps them relative
+	BaseURL string `mapstructure:"base_url"`
+	// NodeURL maps internal /node/<id> links to this URL, "{id}" is replaced by the node ID
+	NodeURL string `mapstructure:"node_url"`
+	// KeepBoilerplate converts html as it is, by default script, style and noscript elements
+	// and the elements matching BoilerplateSelectors are removed first
+	KeepBoilerplate bool `mapstructure:"keep_boilerplate"`
+	// BoilerplateSelectors are tag, .class, #id, tag.class or tag#id selectors,
+	// empty means nav, footer, aside and .cookie-banner
+	BoilerplateSelectors []string `mapstructure:"boilerplate_selectors"`
+	// MainContent keeps only the main or article element with the most text, if the page has one
+	MainContent bool `mapstructure:"main_content"`
+	// StripImages drops images, their URLs only cost tokens in retrieved chunks
+	StripImages bool `mapstructure:"strip_images"`
+	// RawHTMLFallback uploads html that fails to convert as an html file for the provider
+	// to parse, instead of failing the upsert
+	RawHTMLFallback bool `mapstructure:"raw_html_fallback"`
+}
+
+type RAGQueryCacheConfig struct {
+	// TTL of cached results, 0 disables the cache
+	TTL time.Duration `mapstructure:"ttl"`
+	// MaxEntries bounds the cached results, 0 means 1000
+	MaxEntries int `mapstructure:"max_entries"`
+}
+
+type RAGMigrationConfig struct {
+	Target  *RAGConfig `mapstructure:"target"`
+	Cutover bool       `mapstructure:"cutover"`
 }
 
 type CTRAGConfig struct {
 	BaseURL string `mapstructure:"base_url"`
 	APIKey  string `mapstructure:"api_key"`
+	// SimilarityThreshold overrides rag.default_similarity_threshold for this provider
+	SimilarityThreshold float64 `mapstructure:"similarity_threshold"`
+	// MaxConcurrency limits in-flight requests to raglite, 0 means unlimited
+	MaxConcurrency int `mapstructure:"max_concurrency"`
+	// InteractiveReserved is the number of slots background jobs can never take
+	InteractiveReserved int `mapstructure:"interactive_reserved"`
+	// Connection pool of the raglite client, zero values use defaults sized by MaxConcurrency
+	MaxIdleConns        int           `mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `mapstructure:"max_idle_conns_per_host"`
+	IdleConnTimeout     time.Duration `mapstructure:"idle_conn_timeout"`
+	// Timeout bounds each raglite request, 0 means no timeout
+	Timeout time.Duration `mapstructure:"timeout"`
+	// MaxContentSize overrides rag.max_document_bytes for this provider
+	MaxContentSize int `mapstructure:"max_content_size"`
+	// DatasetCacheTTL is how long datasets known to exist are cached, 0 means 5 minutes and negative disables it
+	DatasetCacheTTL time.Duration `mapstructure:"dataset_cache_ttl"`
+	// BatchUpsertWorkers is how many documents BatchUpsertRecords uploads at once, 0 means 4
+	BatchUpsertWorkers int `mapstructure:"batch_upsert_workers"`
+	// RateLimit paces requests to raglite in requests per second, 0 disables it.
+	// With it on, 429 responses are retried after their Retry-After.
+	RateLimit float64 `mapstructure:"rate_limit"`
+	// RateLimitBurst is how many requests can go at once after idling, 0 means RateLimit
+	RateLimitBurst int `mapstructure:"rate_limit_burst"`
+	// RerankStrict fails reranked queries when the rerank model is missing or fails,
+	// by default they keep the retrieval order
+	RerankStrict bool `mapstructure:"rerank_strict"`
+}
+
+// LocalRAGConfig is for the on-prem Qdrant and embeddings stack
+type LocalRAGConfig struct {
+	QdrantURL      string `mapstructure:"qdrant_url"`
+	QdrantAPIKey   string `mapstructure:"qdrant_api_key"`
+	EmbeddingURL   string `mapstructure:"embedding_url"`
+	EmbeddingModel string `mapstructure:"embedding_model"`
 }
 
 type RedisConfig struct {
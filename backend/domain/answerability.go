@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// AnswerabilityCalibration maps retrieval features to a confidence with a logistic model.
+type AnswerabilityCalibration struct {
+	Bias           float64 `json:"bias"`
+	TopScoreWeight float64 `json:"top_score_weight"`
+	MarginWeight   float64 `json:"margin_weight"`
+	CoverageWeight float64 `json:"coverage_weight"`
+	// Samples is the number of feedback messages the weights were fit from, 0 for defaults
+	Samples int `json:"samples"`
+}
+
+// DefaultAnswerabilityCalibration is used until a KB has been fit from its feedback.
+var DefaultAnswerabilityCalibration = AnswerabilityCalibration{
+	Bias:           -4,
+	TopScoreWeight: 6,
+	MarginWeight:   4,
+	CoverageWeight: 1,
+}
+
+func (c *AnswerabilityCalibration) Scan(value any) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("invalid answerability calibration value type:", value))
+	}
+	return json.Unmarshal(bytes, c)
+}
+
+func (c *AnswerabilityCalibration) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Answerability estimates whether a KB can answer a question from retrieval alone.
+type Answerability struct {
+	Confidence float64 `json:"confidence"`
+	TopScore   float64 `json:"top_score"`
+	// Margin is the top score minus the similarity threshold
+	Margin        float64 `json:"margin"`
+	ResultCount   int     `json:"result_count"`
+	DocumentCount int     `json:"document_count"`
+	// Coverage is the share of results coming from distinct documents
+	Coverage   float64                   `json:"coverage"`
+	Candidates []*AnswerabilityCandidate `json:"candidates"`
+}
+
+type AnswerabilityCandidate struct {
+	DocID  string  `json:"-"`
+	Score  float64 `json:"score"`
+	NodeID string  `json:"node_id"`
+	Name   string  `json:"name"`
+}
+
+type AnswerabilityReq struct {
+	Message string `json:"message" validate:"required"`
+
+	KBID string `json:"-" validate:"required"`
+
+	AuthUserID uint `json:"-"`
+}
+
+type FitAnswerabilityReq struct {
+	KBID string `json:"kb_id" validate:"required"`
+}
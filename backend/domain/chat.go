@@ -88,6 +88,17 @@ type ChatSearchReq struct {
 	AuthUserID uint   `json:"-"`
 }
 
+type ChatContextReq struct {
+	Message   string `json:"message" validate:"required"`
+	MaxTokens int    `json:"max_tokens"`
+
+	KBID string `json:"-" validate:"required"`
+
+	AuthUserID uint `json:"-"`
+}
+
 type ChatSearchResp struct {
 	NodeResult []NodeContentChunkSSE `json:"node_result"`
+	// RAGDisabled is set when the wiki runs without AI search, the UI hides it
+	RAGDisabled bool `json:"rag_disabled"`
 }
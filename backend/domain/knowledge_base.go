@@ -20,6 +20,10 @@ type KnowledgeBase struct {
 
 	// public info for public access
 	AccessSettings AccessSettings `json:"access_settings" gorm:"type:jsonb"`
+	// AnswerabilityCalibration is fit from feedback, nil means the default
+	AnswerabilityCalibration *AnswerabilityCalibration `json:"answerability_calibration,omitempty" gorm:"type:jsonb"`
+	// Chunking is the chunking of documents uploaded to the dataset, nil means rag.chunking of the config
+	Chunking *KBChunking `json:"chunking,omitempty" gorm:"type:jsonb"`
 
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
@@ -102,6 +106,38 @@ type CreateKnowledgeBaseReq struct {
 	PrivateKey string   `json:"private_key"`
 	Hosts      []string `json:"hosts"`
 	MaxKB      int      `json:"-"`
+	// Chunking fields left at 0 or empty take rag.chunking of the config
+	Chunking *KBChunking `json:"chunking,omitempty"`
+	// RAGProvider names the rag.instances entry to create the dataset in, empty is the default provider
+	RAGProvider string `json:"rag_provider,omitempty"`
+}
+
+// KBChunking tunes how documents of a knowledge base are cut into chunks.
+type KBChunking struct {
+	// Size in bytes, 0 leaves chunking to the provider
+	Size int `json:"size"`
+	// Overlap in bytes, negative for none
+	Overlap int `json:"overlap"`
+	// Strategy is "markdown-heading", "fixed" or "sentence"
+	Strategy string `json:"strategy"`
+}
+
+func (c *KBChunking) Scan(value any) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New(fmt.Sprint("invalid kb chunking value type:", value))
+	}
+	return json.Unmarshal(bytes, c)
+}
+
+func (c *KBChunking) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+type ResyncPermissionsReq struct {
+	KBID string `json:"kb_id" validate:"required"`
+	// Resume continues the last unfinished re-sync instead of starting over
+	Resume bool `json:"resume"`
 }
 
 type UpdateKnowledgeBaseReq struct {
@@ -129,6 +129,53 @@ func FormatNodeChunks(nodeChunks []*RankedNodeChunks, baseURL string) string {
 	return strings.Join(documents, "\n")
 }
 
+// ContextBundle is everything the chat model reads for a question
+type ContextBundle struct {
+	Query          string                  `json:"query"`
+	RewrittenQuery string                  `json:"rewritten_query"`
+	Documents      []ContextBundleDocument `json:"documents"`
+	TotalTokens    int                     `json:"total_tokens"`
+	MaxTokens      int                     `json:"max_tokens"`
+	Truncated      bool                    `json:"truncated"`
+	Markdown       string                  `json:"markdown"`
+
+	// Prompt is the documents section fed to the chat model
+	Prompt      string              `json:"-"`
+	RankedNodes []*RankedNodeChunks `json:"-"`
+}
+
+type ContextBundleDocument struct {
+	NodeID    string               `json:"node_id"`
+	Title     string               `json:"title"`
+	URL       string               `json:"url"`
+	PathNames []string             `json:"path_names"`
+	Chunks    []ContextBundleChunk `json:"chunks"`
+}
+
+type ContextBundleChunk struct {
+	ID       string         `json:"id"`
+	Content  string         `json:"content"`
+	Tokens   int            `json:"tokens"`
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// RenderMarkdown renders the bundle as a single markdown document
+func (b *ContextBundle) RenderMarkdown() string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("# %s\n\n", b.Query))
+	if b.RewrittenQuery != "" && b.RewrittenQuery != b.Query {
+		sb.WriteString(fmt.Sprintf("> %s\n\n", b.RewrittenQuery))
+	}
+	for _, doc := range b.Documents {
+		sb.WriteString(fmt.Sprintf("## [%s](%s)\n\n", doc.Title, doc.URL))
+		for _, chunk := range doc.Chunks {
+			sb.WriteString(chunk.Content)
+			sb.WriteString("\n\n")
+		}
+	}
+	return strings.TrimSpace(sb.String()) + "\n"
+}
+
 var NodeFIMSystemPrompt = `
 角色与目标
 你是一个集成在文本编辑器中的 AI 助手，专为用户提供高质量的“内联文本续写”（Fill-in-the-Middle）。你的核心目标是在用户光标位置，依据上下文，生成流畅、连贯且有价值的续写内容。
@@ -180,13 +180,21 @@ type NodeListItemResp struct {
 }
 
 type NodeContentChunk struct {
-	ID    string `json:"id"`
-	KBID  string `json:"kb_id"`
-	DocID string `json:"doc_id"`
-
-	Seq     uint   `json:"seq"`
-	Name    string `json:"name"`
-	Content string `json:"content"`
+	ID        string `json:"id"`
+	KBID      string `json:"kb_id"`
+	DocID     string `json:"doc_id"`
+	DatasetID string `json:"dataset_id,omitempty"`
+	DocTitle  string `json:"doc_title,omitempty"`
+	DocURL    string `json:"doc_url,omitempty"`
+
+	Seq     uint    `json:"seq"`
+	Name    string  `json:"name"`
+	Content string  `json:"content"`
+	Score   float64 `json:"score"`
+	// Snippet is the part of Content best matching the query, with query terms wrapped in <em>
+	Snippet string `json:"snippet,omitempty"`
+	// Metadata is what the provider returns per chunk, e.g. page number and section heading; never nil
+	Metadata map[string]any `json:"metadata"`
 }
 
 type RankedNodeChunks struct {
@@ -8,6 +8,7 @@ import (
 
 	"github.com/chaitin/panda-wiki/log"
 	"github.com/chaitin/panda-wiki/repo/pg"
+	"github.com/chaitin/panda-wiki/store/rag"
 	"github.com/chaitin/panda-wiki/usecase"
 )
 
@@ -106,7 +107,8 @@ func (h *CronHandler) CleanupOldHourlyStats() {
 
 func (h *CronHandler) SyncRagNodeStatus() {
 	h.logger.Info("sync rag node status")
-	err := h.nodeUseCase.SyncRagNodeStatus(context.Background())
+	ctx := rag.WithPriority(context.Background(), rag.PriorityBackground)
+	err := h.nodeUseCase.SyncRagNodeStatus(ctx)
 	if err != nil {
 		h.logger.Error("sync rag node status failed", log.Error(err))
 		return
@@ -3,6 +3,7 @@ package mq
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/chaitin/panda-wiki/consts"
 	"github.com/chaitin/panda-wiki/domain"
@@ -47,6 +48,8 @@ func (h *RAGMQHandler) HandleNodeContentVectorRequest(ctx context.Context, msg t
 		h.logger.Error("unmarshal node content vector request failed", log.Error(err))
 		return nil
 	}
+	// vector tasks are background work and must not starve chat queries
+	ctx = rag.WithPriority(ctx, rag.PriorityBackground)
 	switch request.Action {
 	case "update_group_ids":
 		h.logger.Info("update node group request", log.Any("request", request), log.Any("group_id", request.GroupIds))
@@ -85,20 +88,34 @@ func (h *RAGMQHandler) HandleNodeContentVectorRequest(ctx context.Context, msg t
 		}
 
 		// upsert node content chunks
-		docID, err := h.rag.UpsertRecords(ctx, &rag.UpsertRecordsRequest{
+		upsertReq := &rag.UpsertRecordsRequest{
 			ID:        nodeRelease.ID,
 			Title:     nodeRelease.Name,
+			URL:       fmt.Sprintf("/node/%s", nodeRelease.NodeID),
+			UpdatedAt: nodeRelease.UpdatedAt,
 			DatasetID: kb.DatasetID,
 			DocID:     nodeRelease.DocID,
 			Content:   nodeRelease.Content,
 			GroupIDs:  groupIds,
-		})
+		}
+		if kb.Chunking != nil {
+			upsertReq.ChunkSize = kb.Chunking.Size
+			upsertReq.ChunkOverlap = kb.Chunking.Overlap
+			upsertReq.ChunkStrategy = rag.ChunkStrategy(kb.Chunking.Strategy)
+		}
+		res, err := h.rag.UpsertRecords(ctx, upsertReq)
 		if err != nil {
 			h.logger.Error("upsert node content vector failed", log.Error(err))
 			return nil
 		}
+		if res.Skipped {
+			h.logger.Info("node content unchanged, skip upsert", log.String("doc_id", res.DocID))
+		}
+		if res.RawHTML {
+			h.logger.Warn("node html failed to convert, uploaded as raw html", log.String("node_id", nodeRelease.NodeID), log.String("doc_id", res.DocID))
+		}
 		// update node doc_id
-		if err := h.nodeRepo.UpdateNodeReleaseDocID(ctx, request.NodeReleaseID, docID); err != nil {
+		if err := h.nodeRepo.UpdateNodeReleaseDocID(ctx, request.NodeReleaseID, res.DocID); err != nil {
 			h.logger.Error("update node doc_id failed", log.String("node_id", request.NodeReleaseID), log.Error(err))
 			return nil
 		}
@@ -12,9 +12,16 @@ import (
 	"github.com/chaitin/panda-wiki/domain"
 	"github.com/chaitin/panda-wiki/handler"
 	"github.com/chaitin/panda-wiki/log"
+	"github.com/chaitin/panda-wiki/pkg/ratelimit"
+	"github.com/chaitin/panda-wiki/store/cache"
 	"github.com/chaitin/panda-wiki/usecase"
 )
 
+const (
+	answerabilityRateLimit  = 30
+	answerabilityRateWindow = time.Minute
+)
+
 type ShareChatHandler struct {
 	*handler.BaseHandler
 	logger              *log.Logger
@@ -23,6 +30,7 @@ type ShareChatHandler struct {
 	authUsecase         *usecase.AuthUsecase
 	conversationUsecase *usecase.ConversationUsecase
 	modelUsecase        *usecase.ModelUsecase
+	rateLimiter         *ratelimit.RateLimiter
 }
 
 func NewShareChatHandler(
@@ -34,15 +42,18 @@ func NewShareChatHandler(
 	authUsecase *usecase.AuthUsecase,
 	conversationUsecase *usecase.ConversationUsecase,
 	modelUsecase *usecase.ModelUsecase,
+	cache *cache.Cache,
 ) *ShareChatHandler {
+	handlerLogger := logger.WithModule("handler.share.chat")
 	h := &ShareChatHandler{
 		BaseHandler:         baseHandler,
-		logger:              logger.WithModule("handler.share.chat"),
+		logger:              handlerLogger,
 		appUsecase:          appUsecase,
 		chatUsecase:         chatUsecase,
 		authUsecase:         authUsecase,
 		conversationUsecase: conversationUsecase,
 		modelUsecase:        modelUsecase,
+		rateLimiter:         ratelimit.NewRateLimiter(handlerLogger, cache),
 	}
 
 	share := e.Group("share/v1/chat",
@@ -59,6 +70,8 @@ func NewShareChatHandler(
 		})
 	share.POST("/message", h.ChatMessage, h.ShareAuthMiddleware.Authorize)
 	share.POST("/search", h.ChatSearch, h.ShareAuthMiddleware.Authorize)
+	share.POST("/context", h.ChatContext, h.ShareAuthMiddleware.Authorize)
+	share.POST("/answerability", h.ChatAnswerability, h.ShareAuthMiddleware.Authorize)
 	share.POST("/completions", h.ChatCompletions)
 	share.POST("/widget", h.ChatWidget)
 	share.POST("/widget/search", h.WidgetSearch)
@@ -509,6 +522,87 @@ func (h *ShareChatHandler) ChatSearch(c echo.Context) error {
 	return h.NewResponseWithData(c, resp)
 }
 
+// ChatContext exports the retrieved context the bot would read for a question
+//
+//	@Summary		ChatContext
+//	@Description	ChatContext
+//	@Tags			share_chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		domain.ChatContextReq	true	"request"
+//	@Success		200		{object}	domain.Response{data=domain.ContextBundle}
+//	@Router			/share/v1/chat/context [post]
+func (h *ShareChatHandler) ChatContext(c echo.Context) error {
+	var req domain.ChatContextReq
+	if err := c.Bind(&req); err != nil {
+		return h.NewResponseWithError(c, "parse request failed", err)
+	}
+	req.KBID = c.Request().Header.Get("X-KB-ID") // get from caddy header
+	if err := c.Validate(&req); err != nil {
+		return h.NewResponseWithError(c, "validate request failed", err)
+	}
+
+	// get user info --> no enterprise is nil
+	userID := c.Get("user_id")
+	if userID != nil {
+		if userIDValue, ok := userID.(uint); ok {
+			req.AuthUserID = userIDValue
+		} else {
+			return h.NewResponseWithError(c, "invalid user id type", nil)
+		}
+	}
+
+	bundle, err := h.chatUsecase.BuildContextBundle(c.Request().Context(), &req)
+	if err != nil {
+		return h.NewResponseWithError(c, "failed to build context bundle", err)
+	}
+	return h.NewResponseWithData(c, bundle)
+}
+
+// ChatAnswerability estimates whether the KB can answer a question without calling the chat model
+//
+//	@Summary		ChatAnswerability
+//	@Description	ChatAnswerability
+//	@Tags			share_chat
+//	@Accept			json
+//	@Produce		json
+//	@Param			request	body		domain.AnswerabilityReq	true	"request"
+//	@Success		200		{object}	domain.Response{data=domain.Answerability}
+//	@Router			/share/v1/chat/answerability [post]
+func (h *ShareChatHandler) ChatAnswerability(c echo.Context) error {
+	var req domain.AnswerabilityReq
+	if err := c.Bind(&req); err != nil {
+		return h.NewResponseWithError(c, "parse request failed", err)
+	}
+	req.KBID = c.Request().Header.Get("X-KB-ID") // get from caddy header
+	if err := c.Validate(&req); err != nil {
+		return h.NewResponseWithError(c, "validate request failed", err)
+	}
+	ctx := c.Request().Context()
+	if !h.rateLimiter.Allow(ctx, fmt.Sprintf("answerability:%s:%s", req.KBID, c.RealIP()), answerabilityRateLimit, answerabilityRateWindow) {
+		return c.JSON(http.StatusTooManyRequests, domain.PWResponse{
+			Success: false,
+			Message: "too many requests",
+		})
+	}
+
+	// get user info --> no enterprise is nil
+	userID := c.Get("user_id")
+	if userID != nil {
+		if userIDValue, ok := userID.(uint); ok {
+			req.AuthUserID = userIDValue
+		} else {
+			return h.NewResponseWithError(c, "invalid user id type", nil)
+		}
+	}
+
+	res, err := h.chatUsecase.CheckAnswerability(ctx, &req)
+	if err != nil {
+		return h.NewResponseWithError(c, "failed to check answerability", err)
+	}
+	return h.NewResponseWithData(c, res)
+}
+
 // WidgetSearch
 //
 //	@Summary		WidgetSearch
@@ -44,6 +44,10 @@ func NewKnowledgeBaseHandler(
 	group.GET("/detail", h.GetKnowledgeBaseDetail, h.auth.ValidateKBUserPerm(consts.UserKBPermissionNotNull))
 	group.PUT("/detail", h.UpdateKnowledgeBase, h.auth.ValidateKBUserPerm(consts.UserKBPermissionFullControl))
 	group.DELETE("/detail", h.DeleteKnowledgeBase, h.auth.ValidateUserRole(consts.UserRoleAdmin))
+	group.GET("/rag/capabilities", h.GetRAGCapabilities, h.auth.ValidateUserRole(consts.UserRoleAdmin))
+	group.POST("/answerability/fit", h.FitAnswerabilityCalibration, h.auth.ValidateKBUserPerm(consts.UserKBPermissionFullControl))
+	group.GET("/permission/resync", h.GetPermissionResync, h.auth.ValidateKBUserPerm(consts.UserKBPermissionFullControl))
+	group.POST("/permission/resync", h.ResyncPermissions, h.auth.ValidateKBUserPerm(consts.UserKBPermissionFullControl))
 
 	// user management
 	userGroup := group.Group("/user", h.auth.ValidateKBUserPerm(consts.UserKBPermissionFullControl))
@@ -229,6 +233,89 @@ func (h *KnowledgeBaseHandler) DeleteKnowledgeBase(c echo.Context) error {
 	return h.NewResponseWithData(c, nil)
 }
 
+// FitAnswerabilityCalibration
+//
+//	@Summary		FitAnswerabilityCalibration
+//	@Description	Fit the answerability calibration of a knowledge base from conversation feedback
+//	@Tags			knowledge_base
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		domain.FitAnswerabilityReq	true	"FitAnswerabilityCalibration Request"
+//	@Success		200		{object}	domain.PWResponse{data=domain.AnswerabilityCalibration}
+//	@Router			/api/v1/knowledge_base/answerability/fit [post]
+func (h *KnowledgeBaseHandler) FitAnswerabilityCalibration(c echo.Context) error {
+	req := &domain.FitAnswerabilityReq{}
+	if err := c.Bind(req); err != nil {
+		return h.NewResponseWithError(c, "request body is invalid", err)
+	}
+	if err := c.Validate(req); err != nil {
+		return h.NewResponseWithError(c, "validate request body failed", err)
+	}
+
+	calibration, err := h.llmUsecase.FitAnswerabilityCalibration(c.Request().Context(), req.KBID)
+	if err != nil {
+		return h.NewResponseWithError(c, "fit answerability calibration failed", err)
+	}
+	return h.NewResponseWithData(c, calibration)
+}
+
+// GetPermissionResync
+//
+//	@Summary		GetPermissionResync
+//	@Description	Get the progress of the last permission re-sync of a knowledge base
+//	@Tags			knowledge_base
+//	@Accept			json
+//	@Produce		json
+//	@Param			kb_id	query		string	true	"Knowledge Base ID"
+//	@Success		200		{object}	domain.Response
+//	@Router			/api/v1/knowledge_base/permission/resync [get]
+func (h *KnowledgeBaseHandler) GetPermissionResync(c echo.Context) error {
+	kbID := c.QueryParam("kb_id")
+	if kbID == "" {
+		return h.NewResponseWithError(c, "kb id is required", nil)
+	}
+	report, err := h.usecase.GetPermissionResync(c.Request().Context(), kbID)
+	if err != nil {
+		return h.NewResponseWithError(c, "get permission resync failed", err)
+	}
+	return h.NewResponseWithData(c, report)
+}
+
+// ResyncPermissions
+//
+//	@Summary		ResyncPermissions
+//	@Description	Resume or restart the permission re-sync of a knowledge base in background
+//	@Tags			knowledge_base
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		domain.ResyncPermissionsReq	true	"ResyncPermissions Request"
+//	@Success		200		{object}	domain.Response
+//	@Router			/api/v1/knowledge_base/permission/resync [post]
+func (h *KnowledgeBaseHandler) ResyncPermissions(c echo.Context) error {
+	req := &domain.ResyncPermissionsReq{}
+	if err := c.Bind(req); err != nil {
+		return h.NewResponseWithError(c, "request body is invalid", err)
+	}
+	if err := c.Validate(req); err != nil {
+		return h.NewResponseWithError(c, "validate request body failed", err)
+	}
+	h.usecase.StartPermissionResync(c.Request().Context(), req.KBID, req.Resume)
+	return h.NewResponseWithData(c, nil)
+}
+
+// GetRAGCapabilities
+//
+//	@Summary		GetRAGCapabilities
+//	@Description	Get the features the RAG provider supports
+//	@Tags			knowledge_base
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	domain.Response
+//	@Router			/api/v1/knowledge_base/rag/capabilities [get]
+func (h *KnowledgeBaseHandler) GetRAGCapabilities(c echo.Context) error {
+	return h.NewResponseWithData(c, h.usecase.GetRAGCapabilities())
+}
+
 // CreateKBRelease
 //
 //	@Summary		CreateKBRelease
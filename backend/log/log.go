@@ -1,6 +1,7 @@
 package log
 
 import (
+	"context"
 	"log/slog"
 	"os"
 
@@ -20,6 +21,28 @@ func (l *Logger) WithModule(module string) *Logger {
 	return &Logger{l.With(slog.String("module", module))}
 }
 
+type requestIDCtxKey struct{}
+
+// WithRequestID carries the ID of the request being served in ctx, loggers built with
+// WithContext(ctx) add it to every line as request_id.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDCtxKey{}, requestID)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, empty if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDCtxKey{}).(string)
+	return requestID
+}
+
+// WithContext returns a logger adding the request ID of ctx, or l itself when ctx has none.
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		return &Logger{l.With(slog.String("request_id", requestID))}
+	}
+	return l
+}
+
 func Any(key string, value any) slog.Attr {
 	return slog.Any(key, value)
 }
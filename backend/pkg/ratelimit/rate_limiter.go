@@ -100,3 +100,20 @@ func (r *RateLimiter) ResetLoginAttempts(ctx context.Context, ip string) error {
 	}
 	return nil
 }
+
+// Allow counts a request for key in a fixed window and reports whether it is within limit
+func (r *RateLimiter) Allow(ctx context.Context, key string, limit int64, window time.Duration) bool {
+	countKey := fmt.Sprintf("rate_limit:%s", key)
+
+	count, err := r.cache.Incr(ctx, countKey).Result()
+	if err != nil {
+		r.logger.Error("failed to increment rate limit counter", "error", err, "key", key)
+		return true
+	}
+	if count == 1 {
+		if err := r.cache.Expire(ctx, countKey, window).Err(); err != nil {
+			r.logger.Error("failed to set expiry on rate limit counter", "error", err, "key", key)
+		}
+	}
+	return count <= limit
+}
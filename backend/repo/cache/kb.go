@@ -4,9 +4,11 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"github.com/chaitin/panda-wiki/domain"
 	"github.com/chaitin/panda-wiki/store/cache"
+	"github.com/chaitin/panda-wiki/store/rag"
 	"github.com/redis/go-redis/v9"
 )
 
@@ -53,3 +55,31 @@ func (r *KBRepo) DeleteKB(ctx context.Context, kbID string) error {
 func (r *KBRepo) ClearSession(ctx context.Context) error {
 	return r.cache.DeleteKeysWithPrefix(ctx, "session_")
 }
+
+func permissionResyncKey(kbID string) string {
+	return fmt.Sprintf("permission_resync:%s", kbID)
+}
+
+// GetPermissionResync returns the last recorded permission re-sync of a KB, nil if none
+func (r *KBRepo) GetPermissionResync(ctx context.Context, kbID string) (*rag.ResyncReport, error) {
+	reportStr, err := r.cache.Get(ctx, permissionResyncKey(kbID)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var report rag.ResyncReport
+	if err := json.Unmarshal([]byte(reportStr), &report); err != nil {
+		return nil, err
+	}
+	return &report, nil
+}
+
+func (r *KBRepo) SetPermissionResync(ctx context.Context, kbID string, report *rag.ResyncReport) error {
+	reportStr, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return r.cache.Set(ctx, permissionResyncKey(kbID), reportStr, 0).Err()
+}
@@ -532,6 +532,13 @@ func (r *KnowledgeBaseRepository) UpdateDatasetID(ctx context.Context, kbID, dat
 		Update("dataset_id", datasetID).Error
 }
 
+func (r *KnowledgeBaseRepository) UpdateAnswerabilityCalibration(ctx context.Context, kbID string, calibration *domain.AnswerabilityCalibration) error {
+	return r.db.WithContext(ctx).
+		Model(&domain.KnowledgeBase{}).
+		Where("id = ?", kbID).
+		Update("answerability_calibration", calibration).Error
+}
+
 func (r *KnowledgeBaseRepository) UpdateKnowledgeBase(ctx context.Context, req *domain.UpdateKnowledgeBaseReq) (bool, error) {
 	var isChanged bool
 	kb, err := r.GetKnowledgeBaseByID(ctx, req.ID)
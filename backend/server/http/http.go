@@ -84,6 +84,13 @@ func NewEcho(
 		e.Use(middlewareOtel.Middleware(config.GetString("apm.service_name")))
 	}
 
+	// request IDs reach the logs of lower layers through the request context
+	e.Use(middleware.RequestIDWithConfig(middleware.RequestIDConfig{
+		RequestIDHandler: func(c echo.Context, requestID string) {
+			c.SetRequest(c.Request().WithContext(log.WithRequestID(c.Request().Context(), requestID)))
+		},
+	}))
+
 	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
 		LogStatus:   true,
 		LogURI:      true,
@@ -101,6 +108,7 @@ func NewEcho(
 			latency := v.Latency.Milliseconds()
 			if v.Error == nil {
 				logger.LogAttrs(context.Background(), slog.LevelInfo, "REQUEST",
+					slog.String("request_id", log.RequestIDFromContext(c.Request().Context())),
 					slog.String("remote_ip", realIP),
 					slog.String("method", method),
 					slog.String("uri", uri),
@@ -109,6 +117,7 @@ func NewEcho(
 				)
 			} else {
 				logger.LogAttrs(context.Background(), slog.LevelError, "REQUEST_ERROR",
+					slog.String("request_id", log.RequestIDFromContext(c.Request().Context())),
 					slog.String("remote_ip", realIP),
 					slog.String("method", method),
 					slog.String("uri", uri),
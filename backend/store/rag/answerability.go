@@ -0,0 +1,164 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+const (
+	answerabilityCandidates = 3
+	// MinAnswerabilitySamples is the least feedback needed to fit a calibration
+	MinAnswerabilitySamples = 20
+
+	answerabilityFitRounds = 500
+	answerabilityFitRate   = 0.5
+	answerabilityFitL2     = 0.01
+)
+
+// CheckAnswerability runs retrieval only and estimates whether the KB can answer the query,
+// the chat model is never invoked.
+func CheckAnswerability(ctx context.Context, service RAGService, req *QueryRecordsRequest, calibration domain.AnswerabilityCalibration) (*domain.Answerability, error) {
+	res, err := service.QueryRecords(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return ScoreAnswerability(res.Chunks, req.SimilarityThreshold, calibration), nil
+}
+
+// ScoreAnswerability computes the answerability features of retrieved chunks.
+// Chunks scoring below threshold are ignored, a chunk exactly at the threshold counts.
+func ScoreAnswerability(chunks []*domain.NodeContentChunk, threshold float64, calibration domain.AnswerabilityCalibration) *domain.Answerability {
+	res := &domain.Answerability{Candidates: []*domain.AnswerabilityCandidate{}}
+	best := make(map[string]float64)
+	for _, chunk := range chunks {
+		if chunk.Score < threshold {
+			continue
+		}
+		res.ResultCount++
+		if score, ok := best[chunk.DocID]; !ok || chunk.Score > score {
+			best[chunk.DocID] = chunk.Score
+		}
+		res.TopScore = math.Max(res.TopScore, chunk.Score)
+	}
+	if res.ResultCount == 0 {
+		return res
+	}
+	res.DocumentCount = len(best)
+	res.Margin = res.TopScore - threshold
+	res.Coverage = float64(res.DocumentCount) / float64(res.ResultCount)
+	res.Confidence = calibrate(calibration, res)
+
+	for docID, score := range best {
+		res.Candidates = append(res.Candidates, &domain.AnswerabilityCandidate{DocID: docID, Score: score})
+	}
+	sort.Slice(res.Candidates, func(i, j int) bool {
+		if res.Candidates[i].Score != res.Candidates[j].Score {
+			return res.Candidates[i].Score > res.Candidates[j].Score
+		}
+		return res.Candidates[i].DocID < res.Candidates[j].DocID
+	})
+	if len(res.Candidates) > answerabilityCandidates {
+		res.Candidates = res.Candidates[:answerabilityCandidates]
+	}
+	return res
+}
+
+func answerabilityFeatures(a *domain.Answerability) [3]float64 {
+	return [3]float64{a.TopScore, a.Margin, a.Coverage}
+}
+
+func calibrate(c domain.AnswerabilityCalibration, a *domain.Answerability) float64 {
+	x := answerabilityFeatures(a)
+	return sigmoid(c.Bias + c.TopScoreWeight*x[0] + c.MarginWeight*x[1] + c.CoverageWeight*x[2])
+}
+
+func sigmoid(z float64) float64 {
+	return 1 / (1 + math.Exp(-z))
+}
+
+// AnswerabilitySample is the retrieval of a past question labeled by user feedback.
+type AnswerabilitySample struct {
+	Answerability *domain.Answerability
+	Answered      bool
+}
+
+// AnswerabilityFeedback is a past question labeled by user feedback, GroupIDs is the
+// permission scope of the user who asked it.
+type AnswerabilityFeedback struct {
+	Question string
+	GroupIDs []int
+	Answered bool
+}
+
+// AnswerabilitySamples re-runs retrieval for feedback questions. Each question is retrieved within
+// the groups of its asker, as CheckAnswerability is at chat time, so restricted documents the
+// asker never saw don't skew the fit.
+func AnswerabilitySamples(ctx context.Context, service RAGService, datasetID string, threshold float64, feedback []AnswerabilityFeedback) ([]AnswerabilitySample, error) {
+	samples := make([]AnswerabilitySample, 0, len(feedback))
+	for _, f := range feedback {
+		res, err := CheckAnswerability(ctx, service, &QueryRecordsRequest{
+			DatasetID:           datasetID,
+			Query:               f.Question,
+			GroupIDs:            f.GroupIDs,
+			SimilarityThreshold: threshold,
+		}, domain.DefaultAnswerabilityCalibration)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, AnswerabilitySample{Answerability: res, Answered: f.Answered})
+	}
+	return samples, nil
+}
+
+// FitAnswerabilityCalibration fits the logistic calibration to labeled samples,
+// starting from the default weights so small sets only nudge them.
+func FitAnswerabilityCalibration(samples []AnswerabilitySample) (domain.AnswerabilityCalibration, error) {
+	var positives int
+	for _, s := range samples {
+		if s.Answered {
+			positives++
+		}
+	}
+	if len(samples) < MinAnswerabilitySamples {
+		return domain.DefaultAnswerabilityCalibration, fmt.Errorf("need at least %d feedback samples, got %d", MinAnswerabilitySamples, len(samples))
+	}
+	if positives == 0 || positives == len(samples) {
+		return domain.DefaultAnswerabilityCalibration, fmt.Errorf("feedback samples must contain both liked and disliked answers")
+	}
+
+	c := domain.DefaultAnswerabilityCalibration
+	w := [3]float64{c.TopScoreWeight, c.MarginWeight, c.CoverageWeight}
+	b := c.Bias
+	n := float64(len(samples))
+	for range answerabilityFitRounds {
+		var gb float64
+		var gw [3]float64
+		for _, s := range samples {
+			x := answerabilityFeatures(s.Answerability)
+			p := sigmoid(b + w[0]*x[0] + w[1]*x[1] + w[2]*x[2])
+			y := 0.0
+			if s.Answered {
+				y = 1
+			}
+			gb += p - y
+			for i := range w {
+				gw[i] += (p - y) * x[i]
+			}
+		}
+		b -= answerabilityFitRate * gb / n
+		for i := range w {
+			w[i] -= answerabilityFitRate * (gw[i]/n + answerabilityFitL2*w[i])
+		}
+	}
+	return domain.AnswerabilityCalibration{
+		Bias:           b,
+		TopScoreWeight: w[0],
+		MarginWeight:   w[1],
+		CoverageWeight: w[2],
+		Samples:        len(samples),
+	}, nil
+}
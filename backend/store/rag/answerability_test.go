@@ -0,0 +1,134 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+type staticRAG struct {
+	*DisabledRAG
+	chunks []*domain.NodeContentChunk
+}
+
+func (s *staticRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	return &QueryResult{Query: req.Query, Chunks: s.chunks, Total: len(s.chunks)}, nil
+}
+
+func chunk(docID string, score float64) *domain.NodeContentChunk {
+	return &domain.NodeContentChunk{ID: fmt.Sprintf("%s-%v", docID, score), DocID: docID, Score: score}
+}
+
+func TestCheckAnswerability_EmptyKB(t *testing.T) {
+	res, err := CheckAnswerability(context.Background(), &DisabledRAG{}, &QueryRecordsRequest{Query: "q", SimilarityThreshold: 0.2}, domain.DefaultAnswerabilityCalibration)
+	require.NoError(t, err)
+	assert.Zero(t, res.Confidence)
+	assert.Zero(t, res.ResultCount)
+	assert.Empty(t, res.Candidates)
+}
+
+func TestCheckAnswerability_SingleDocument(t *testing.T) {
+	service := &staticRAG{chunks: []*domain.NodeContentChunk{chunk("a", 0.9), chunk("a", 0.7), chunk("a", 0.5)}}
+	res, err := CheckAnswerability(context.Background(), service, &QueryRecordsRequest{Query: "q", SimilarityThreshold: 0.2}, domain.DefaultAnswerabilityCalibration)
+	require.NoError(t, err)
+	assert.Equal(t, 3, res.ResultCount)
+	assert.Equal(t, 1, res.DocumentCount)
+	assert.InDelta(t, 1.0/3, res.Coverage, 1e-9)
+	assert.InDelta(t, 0.7, res.Margin, 1e-9)
+	require.Len(t, res.Candidates, 1)
+	assert.Equal(t, "a", res.Candidates[0].DocID)
+	assert.Equal(t, 0.9, res.Candidates[0].Score)
+	assert.Greater(t, res.Confidence, 0.5)
+}
+
+func TestScoreAnswerability_Threshold(t *testing.T) {
+	c := domain.DefaultAnswerabilityCalibration
+
+	res := ScoreAnswerability([]*domain.NodeContentChunk{chunk("a", 0.5)}, 0.5, c)
+	assert.Equal(t, 1, res.ResultCount, "chunk at the threshold counts")
+	assert.Zero(t, res.Margin)
+
+	res = ScoreAnswerability([]*domain.NodeContentChunk{chunk("a", 0.49)}, 0.5, c)
+	assert.Zero(t, res.ResultCount, "chunk below the threshold is ignored")
+	assert.Zero(t, res.Confidence)
+
+	res = ScoreAnswerability([]*domain.NodeContentChunk{chunk("a", 0)}, 0, c)
+	assert.Equal(t, 1, res.ResultCount, "zero threshold keeps everything")
+}
+
+func TestScoreAnswerability_TopCandidates(t *testing.T) {
+	res := ScoreAnswerability([]*domain.NodeContentChunk{
+		chunk("a", 0.4), chunk("b", 0.8), chunk("c", 0.6), chunk("d", 0.6), chunk("b", 0.3),
+	}, 0.2, domain.DefaultAnswerabilityCalibration)
+	require.Len(t, res.Candidates, 3)
+	assert.Equal(t, "b", res.Candidates[0].DocID)
+	assert.Equal(t, "c", res.Candidates[1].DocID)
+	assert.Equal(t, "d", res.Candidates[2].DocID)
+	assert.Equal(t, 4, res.DocumentCount)
+}
+
+func TestFitAnswerabilityCalibration(t *testing.T) {
+	_, err := FitAnswerabilityCalibration(nil)
+	assert.Error(t, err)
+
+	var samples []AnswerabilitySample
+	for i := range MinAnswerabilitySamples {
+		score := 0.3 + 0.6*float64(i%2)
+		a := ScoreAnswerability([]*domain.NodeContentChunk{chunk("a", score)}, 0.2, domain.DefaultAnswerabilityCalibration)
+		samples = append(samples, AnswerabilitySample{Answerability: a, Answered: i%2 == 1})
+	}
+	c, err := FitAnswerabilityCalibration(samples)
+	require.NoError(t, err)
+	assert.Equal(t, MinAnswerabilitySamples, c.Samples)
+
+	low := ScoreAnswerability([]*domain.NodeContentChunk{chunk("a", 0.3)}, 0.2, c)
+	high := ScoreAnswerability([]*domain.NodeContentChunk{chunk("a", 0.9)}, 0.2, c)
+	assert.Less(t, low.Confidence, 0.5)
+	assert.Greater(t, high.Confidence, 0.5)
+
+	_, err = FitAnswerabilityCalibration(samples[:MinAnswerabilitySamples-1])
+	assert.Error(t, err)
+}
+
+// groupScopedRAG only returns the chunks of the groups in the request, like a KB with restricted documents.
+type groupScopedRAG struct {
+	*DisabledRAG
+	chunks   map[int][]*domain.NodeContentChunk
+	requests []*QueryRecordsRequest
+}
+
+func (s *groupScopedRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	s.requests = append(s.requests, req)
+	var chunks []*domain.NodeContentChunk
+	for _, groupID := range req.GroupIDs {
+		chunks = append(chunks, s.chunks[groupID]...)
+	}
+	return &QueryResult{Query: req.Query, Chunks: chunks, Total: len(chunks)}, nil
+}
+
+func TestAnswerabilitySamples_GroupScope(t *testing.T) {
+	service := &groupScopedRAG{chunks: map[int][]*domain.NodeContentChunk{
+		1: {chunk("public", 0.4)},
+		2: {chunk("restricted", 0.9)},
+	}}
+	samples, err := AnswerabilitySamples(context.Background(), service, "ds", 0.2, []AnswerabilityFeedback{
+		{Question: "guest", GroupIDs: []int{1}, Answered: false},
+		{Question: "member", GroupIDs: []int{1, 2}, Answered: true},
+	})
+	require.NoError(t, err)
+	require.Len(t, service.requests, 2)
+	assert.Equal(t, []int{1}, service.requests[0].GroupIDs)
+	assert.Equal(t, []int{1, 2}, service.requests[1].GroupIDs)
+	assert.Equal(t, "ds", service.requests[0].DatasetID)
+
+	require.Len(t, samples, 2)
+	assert.Equal(t, 0.4, samples[0].Answerability.TopScore, "the guest never sees the restricted document")
+	assert.False(t, samples[0].Answered)
+	assert.Equal(t, 0.9, samples[1].Answerability.TopScore)
+	assert.True(t, samples[1].Answered)
+}
@@ -0,0 +1,64 @@
+package rag
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// MaxAttachmentsPerDocument caps how many attachments of one document are indexed.
+const MaxAttachmentsPerDocument = 10
+
+var (
+	attachmentLinkRe = regexp.MustCompile(`\[([^\]]*)\]\((/static-file/[^)\s]+)\)`)
+	headingRe        = regexp.MustCompile(`^#{1,6}\s+(.+)$`)
+)
+
+// AttachmentLink is a link from a document to a file in the KB's object storage.
+type AttachmentLink struct {
+	URL   string
+	Title string
+	// Anchor is the heading of the section containing the link
+	Anchor string
+}
+
+// ExtractAttachmentLinks finds markdown links pointing at the static-file storage,
+// skipping images and duplicates and stopping at limit.
+func ExtractAttachmentLinks(markdown string, limit int) []AttachmentLink {
+	var links []AttachmentLink
+	seen := make(map[string]struct{})
+	anchor := ""
+	for _, line := range strings.Split(markdown, "\n") {
+		if m := headingRe.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			anchor = strings.TrimSpace(m[1])
+			continue
+		}
+		for _, loc := range attachmentLinkRe.FindAllStringSubmatchIndex(line, -1) {
+			if loc[0] > 0 && line[loc[0]-1] == '!' {
+				continue
+			}
+			url := line[loc[4]:loc[5]]
+			if _, ok := seen[url]; ok {
+				continue
+			}
+			seen[url] = struct{}{}
+			title := line[loc[2]:loc[3]]
+			if strings.TrimSpace(title) == "" {
+				title = path.Base(url)
+			}
+			links = append(links, AttachmentLink{URL: url, Title: title, Anchor: anchor})
+			if limit > 0 && len(links) >= limit {
+				return links
+			}
+		}
+	}
+	return links
+}
+
+// AttachmentDocID derives a stable doc ID for an attachment of a parent document,
+// so re-indexing the parent overwrites its children instead of duplicating them.
+func AttachmentDocID(parentDocID, url string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(parentDocID+"|"+url)).String()
+}
@@ -0,0 +1,250 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+const (
+	// batchDeleteWorkers bounds the concurrent dataset deletes of BatchDeleteRecords
+	batchDeleteWorkers = 4
+	// batchQueryWorkers bounds the concurrent queries of BatchQueryRecords
+	batchQueryWorkers = 4
+	// defaultBatchUpsertWorkers bounds the concurrent uploads of BatchUpsertRecords
+	defaultBatchUpsertWorkers = 4
+)
+
+// BatchDeleteError lists the datasets whose deletes failed, the other datasets were deleted.
+type BatchDeleteError struct {
+	Failed map[string]error
+}
+
+func (e *BatchDeleteError) Error() string {
+	datasetIDs := make([]string, 0, len(e.Failed))
+	for datasetID := range e.Failed {
+		datasetIDs = append(datasetIDs, datasetID)
+	}
+	sort.Strings(datasetIDs)
+	msgs := make([]string, len(datasetIDs))
+	for i, datasetID := range datasetIDs {
+		msgs[i] = fmt.Sprintf("%s: %v", datasetID, e.Failed[datasetID])
+	}
+	return fmt.Sprintf("delete records failed in %d datasets: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+func (e *BatchDeleteError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// BatchDeleteRecords deletes documents from several datasets concurrently, docIDs is keyed by dataset ID.
+// When some datasets fail it returns a *BatchDeleteError naming them.
+func BatchDeleteRecords(ctx context.Context, service RAGService, docIDs map[string][]string) error {
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	workers := make(chan struct{}, batchDeleteWorkers)
+	var wg sync.WaitGroup
+	for datasetID, ids := range docIDs {
+		if len(ids) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			if err := service.DeleteRecords(ctx, datasetID, ids); err != nil {
+				mu.Lock()
+				failed[datasetID] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return &BatchDeleteError{Failed: failed}
+	}
+	return nil
+}
+
+// BatchQueryError lists the failed queries by their index in the batch, the other queries have results.
+type BatchQueryError struct {
+	Failed map[int]error
+}
+
+func (e *BatchQueryError) Error() string {
+	indexes := make([]int, 0, len(e.Failed))
+	for i := range e.Failed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	msgs := make([]string, len(indexes))
+	for i, index := range indexes {
+		msgs[i] = fmt.Sprintf("query %d: %v", index, e.Failed[index])
+	}
+	return fmt.Sprintf("%d of the batch queries failed: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+func (e *BatchQueryError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// BatchQueryRecords runs several queries concurrently, results are in the order of reqs.
+// When some queries fail their results are nil and it returns a *BatchQueryError naming them
+// together with the results of the others.
+func BatchQueryRecords(ctx context.Context, service RAGService, reqs []*QueryRecordsRequest) ([]*QueryResult, error) {
+	results := make([]*QueryResult, len(reqs))
+	var mu sync.Mutex
+	failed := make(map[int]error)
+	workers := make(chan struct{}, batchQueryWorkers)
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			res, err := service.QueryRecords(ctx, req)
+			if err != nil {
+				mu.Lock()
+				failed[i] = err
+				mu.Unlock()
+				return
+			}
+			results[i] = res
+		}()
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return results, &BatchQueryError{Failed: failed}
+	}
+	return results, nil
+}
+
+// BatchUpsertError lists the failed upserts by their index in the batch, the other documents were upserted.
+type BatchUpsertError struct {
+	Failed map[int]error
+}
+
+func (e *BatchUpsertError) Error() string {
+	indexes := make([]int, 0, len(e.Failed))
+	for i := range e.Failed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	msgs := make([]string, len(indexes))
+	for i, index := range indexes {
+		msgs[i] = fmt.Sprintf("document %d: %v", index, e.Failed[index])
+	}
+	return fmt.Sprintf("%d of the batch upserts failed: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+func (e *BatchUpsertError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// batchUpsert calls upsert for every request, at most workers at a time, with results in the
+// order of reqs. Requests not started when ctx is done fail with its error.
+// When some requests fail their results are zero and it returns a *BatchUpsertError naming them.
+func batchUpsert(ctx context.Context, reqs []*UpsertRecordsRequest, workers int, upsert func(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error)) ([]UpsertResult, error) {
+	results := make([]UpsertResult, len(reqs))
+	var mu sync.Mutex
+	failed := make(map[int]error)
+	fail := func(i int, err error) {
+		mu.Lock()
+		failed[i] = err
+		mu.Unlock()
+	}
+	slots := make(chan struct{}, max(workers, 1))
+	var wg sync.WaitGroup
+	for i, req := range reqs {
+		if err := ctx.Err(); err != nil {
+			fail(i, err)
+			continue
+		}
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			fail(i, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			res, err := upsert(ctx, req)
+			if err != nil {
+				fail(i, err)
+				return
+			}
+			results[i] = *res
+		}()
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return results, &BatchUpsertError{Failed: failed}
+	}
+	return results, nil
+}
+
+// BatchUpsertModelsError lists the failed models by their index in the batch, the other models were upserted.
+type BatchUpsertModelsError struct {
+	Failed map[int]error
+}
+
+func (e *BatchUpsertModelsError) Error() string {
+	indexes := make([]int, 0, len(e.Failed))
+	for i := range e.Failed {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	msgs := make([]string, len(indexes))
+	for i, index := range indexes {
+		msgs[i] = fmt.Sprintf("model %d: %v", index, e.Failed[index])
+	}
+	return fmt.Sprintf("%d of the batch models failed: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+func (e *BatchUpsertModelsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// BatchUpsertModels upserts the models one after another and goes on past failures, so a
+// provisioning run registers every model it can. Models not started when ctx is done fail
+// with its error. When some fail it returns a *BatchUpsertModelsError naming them.
+func BatchUpsertModels(ctx context.Context, service RAGService, models []*domain.Model) error {
+	failed := make(map[int]error)
+	for i, model := range models {
+		err := ctx.Err()
+		if err == nil {
+			err = service.UpsertModel(ctx, model)
+		}
+		if err != nil {
+			failed[i] = fmt.Errorf("%s model %s: %w", model.Type, model.Model, err)
+		}
+	}
+	if len(failed) > 0 {
+		return &BatchUpsertModelsError{Failed: failed}
+	}
+	return nil
+}
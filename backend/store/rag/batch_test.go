@@ -0,0 +1,187 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+type deleteRAG struct {
+	*DisabledRAG
+	mu      sync.Mutex
+	deleted map[string][]string
+}
+
+func (s *deleteRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	if datasetID == "broken" {
+		return ErrUnavailable
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleted[datasetID] = docIDs
+	return nil
+}
+
+func TestBatchDeleteRecords(t *testing.T) {
+	service := &deleteRAG{deleted: map[string][]string{}}
+	err := BatchDeleteRecords(context.Background(), service, map[string][]string{
+		"a":      {"1", "2"},
+		"b":      {"3"},
+		"empty":  nil,
+		"broken": {"4"},
+	})
+
+	var batchErr *BatchDeleteError
+	require.True(t, errors.As(err, &batchErr))
+	assert.Len(t, batchErr.Failed, 1)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Contains(t, err.Error(), "broken")
+	assert.Equal(t, map[string][]string{"a": {"1", "2"}, "b": {"3"}}, service.deleted)
+
+	assert.NoError(t, BatchDeleteRecords(context.Background(), service, map[string][]string{"a": {"1"}}))
+}
+
+type queryRAG struct {
+	*DisabledRAG
+}
+
+func (s *queryRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	if req.Query == "broken" {
+		return nil, ErrUnavailable
+	}
+	return s.DisabledRAG.QueryRecords(ctx, req)
+}
+
+func TestBatchQueryRecords(t *testing.T) {
+	service := &queryRAG{DisabledRAG: &DisabledRAG{}}
+	results, err := BatchQueryRecords(context.Background(), service, []*QueryRecordsRequest{
+		{Query: "install"},
+		{Query: "broken"},
+		{Query: "upgrade"},
+	})
+
+	var batchErr *BatchQueryError
+	require.True(t, errors.As(err, &batchErr))
+	assert.Contains(t, batchErr.Failed, 1)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	require.Len(t, results, 3)
+	assert.Equal(t, "install", results[0].RewrittenQuery)
+	assert.Nil(t, results[1])
+	assert.Equal(t, "upgrade", results[2].RewrittenQuery)
+}
+
+type migrateRAG struct {
+	*DisabledRAG
+	mu       sync.Mutex
+	upserted []*UpsertRecordsRequest
+}
+
+func (s *migrateRAG) GetDocument(ctx context.Context, datasetID string, docID string) (*Document, error) {
+	if docID == "missing" {
+		return nil, ErrDocumentNotFound
+	}
+	return &Document{ID: docID, Tags: []string{"faq"}, MetaData: DocumentMetadata{GroupIDs: []int{1}, Title: "Install", UpdatedAt: "2024-01-02T03:04:05Z"}}, nil
+}
+
+func (s *migrateRAG) GetDocumentContent(ctx context.Context, datasetID string, docID string) (string, error) {
+	return "# Install", nil
+}
+
+func (s *migrateRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upserted = append(s.upserted, req)
+	return &UpsertResult{DocID: req.DocID}, nil
+}
+
+func TestMigrateDocuments(t *testing.T) {
+	service := &migrateRAG{DisabledRAG: &DisabledRAG{}}
+	migrated, err := MigrateDocuments(context.Background(), service, "src", "dst", []string{"a", "missing"})
+	var migrateErr *MigrateDocumentsError
+	require.True(t, errors.As(err, &migrateErr))
+	assert.True(t, errors.Is(migrateErr.Failed["missing"], ErrDocumentNotFound))
+	assert.Equal(t, map[string]string{"a": "a"}, migrated)
+
+	require.Len(t, service.upserted, 1)
+	req := service.upserted[0]
+	assert.Equal(t, "dst", req.DatasetID)
+	assert.Equal(t, []int{1}, req.GroupIDs)
+	assert.Equal(t, []string{"faq"}, req.Tags)
+	assert.Equal(t, ContentTypeMarkdown, req.ContentType)
+	assert.Equal(t, 2024, req.UpdatedAt.Year())
+
+	_, err = MigrateDocuments(context.Background(), service, "src", "src", []string{"a"})
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+}
+
+func TestBatchUpsert(t *testing.T) {
+	reqs := []*UpsertRecordsRequest{{DocID: "a"}, {DocID: "broken"}, {DocID: "c"}}
+	upsert := func(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+		if req.DocID == "broken" {
+			return nil, ErrInvalidRequest
+		}
+		return &UpsertResult{DocID: req.DocID}, nil
+	}
+	results, err := batchUpsert(context.Background(), reqs, 2, upsert)
+	var batchErr *BatchUpsertError
+	require.True(t, errors.As(err, &batchErr))
+	assert.Len(t, batchErr.Failed, 1)
+	assert.True(t, errors.Is(batchErr.Failed[1], ErrInvalidRequest))
+	assert.Equal(t, []UpsertResult{{DocID: "a"}, {}, {DocID: "c"}}, results)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = batchUpsert(ctx, reqs, 1, upsert)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+type modelRAG struct {
+	*DisabledRAG
+	upserted []string
+}
+
+func (s *modelRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
+	if model.Model == "broken" {
+		return ErrInvalidRequest
+	}
+	s.upserted = append(s.upserted, model.Model)
+	return nil
+}
+
+func TestBatchUpsertModels(t *testing.T) {
+	service := &modelRAG{DisabledRAG: &DisabledRAG{}}
+	err := BatchUpsertModels(context.Background(), service, []*domain.Model{
+		{Model: "gpt-4o", Type: domain.ModelTypeChat},
+		{Model: "broken", Type: domain.ModelTypeEmbedding},
+		{Model: "bge-reranker", Type: domain.ModelTypeRerank},
+	})
+
+	var batchErr *BatchUpsertModelsError
+	require.True(t, errors.As(err, &batchErr))
+	assert.Equal(t, []int{1}, slices.Collect(maps.Keys(batchErr.Failed)))
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	assert.Contains(t, err.Error(), "embedding model broken")
+	assert.Equal(t, []string{"gpt-4o", "bge-reranker"}, service.upserted)
+}
+
+func TestGroupModelsByType(t *testing.T) {
+	chat1 := &domain.Model{ID: "1", Type: domain.ModelTypeChat}
+	embedding := &domain.Model{ID: "2", Type: domain.ModelTypeEmbedding}
+	chat2 := &domain.Model{ID: "3", Type: domain.ModelTypeChat}
+	tts := &domain.Model{ID: "4", Type: "tts"}
+	untyped := &domain.Model{ID: "5"}
+	groups := groupModelsByType([]*domain.Model{chat1, embedding, chat2, tts, untyped})
+	assert.Equal(t, map[domain.ModelType][]*domain.Model{
+		domain.ModelTypeChat:      {chat1, chat2},
+		domain.ModelTypeEmbedding: {embedding},
+		ModelTypeUnknown:          {tts, untyped},
+	}, groups)
+}
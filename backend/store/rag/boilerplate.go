@@ -0,0 +1,149 @@
+package rag
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/JohannesKaufmann/dom"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"golang.org/x/net/html"
+)
+
+// DefaultBoilerplateSelectors are removed when rag.markdown.boilerplate_selectors is empty.
+var DefaultBoilerplateSelectors = []string{"nav", "footer", "aside", ".cookie-banner"}
+
+// boilerplateTags never carry content worth indexing.
+var boilerplateTags = []string{"script", "style", "noscript"}
+
+// selectorRe is the selector syntax supported: a tag, a class or an id, or a tag with a class or id.
+var selectorRe = regexp.MustCompile(`^([a-zA-Z][a-zA-Z0-9-]*)?(?:([.#])([a-zA-Z0-9_-]+))?$`)
+
+// selector matches elements by tag and by one class or id.
+type selector struct {
+	tag   string
+	class string
+	id    string
+}
+
+func parseSelector(s string) (selector, error) {
+	m := selectorRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || (m[1] == "" && m[3] == "") {
+		return selector{}, fmt.Errorf("unsupported selector %q, use tag, .class, #id, tag.class or tag#id", s)
+	}
+	sel := selector{tag: strings.ToLower(m[1])}
+	switch m[2] {
+	case ".":
+		sel.class = m[3]
+	case "#":
+		sel.id = m[3]
+	}
+	return sel, nil
+}
+
+func (sel selector) match(n *html.Node) bool {
+	if n.Type != html.ElementNode || (sel.tag != "" && n.Data != sel.tag) {
+		return false
+	}
+	if sel.class != "" && !dom.HasClass(n, sel.class) {
+		return false
+	}
+	return sel.id == "" || dom.HasID(n, sel.id)
+}
+
+// boilerplatePolicy cleans crawled pages before conversion.
+type boilerplatePolicy struct {
+	selectors   []selector
+	mainContent bool
+}
+
+// clean removes boilerplateTags and the elements matching the selectors, then with mainContent
+// keeps only the main content.
+func (p boilerplatePolicy) clean(ctx converter.Context, doc *html.Node) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			if p.remove(child) {
+				n.RemoveChild(child)
+			} else {
+				walk(child)
+			}
+			child = next
+		}
+	}
+	walk(doc)
+	if p.mainContent {
+		keepMainContent(doc)
+	}
+}
+
+func (p boilerplatePolicy) remove(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, tag := range boilerplateTags {
+		if n.Data == tag {
+			return true
+		}
+	}
+	for _, sel := range p.selectors {
+		if sel.match(n) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepMainContent replaces the children of the body with the main, article or role="main"
+// element holding the most text, in the spirit of readability. Pages without one are kept whole.
+func keepMainContent(doc *html.Node) {
+	var best *html.Node
+	bestLen := 0
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && isMainContent(n) {
+			if l := textLength(n); best == nil || l > bestLen {
+				best, bestLen = n, l
+			}
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(doc)
+	if best == nil {
+		return
+	}
+	body := best.Parent
+	for body.Parent != nil && body.Data != "body" {
+		body = body.Parent
+	}
+	best.Parent.RemoveChild(best)
+	for child := body.FirstChild; child != nil; {
+		next := child.NextSibling
+		body.RemoveChild(child)
+		child = next
+	}
+	body.AppendChild(best)
+}
+
+func isMainContent(n *html.Node) bool {
+	if n.Data == "main" || n.Data == "article" {
+		return true
+	}
+	role, _ := dom.GetAttribute(n, "role")
+	return role == "main"
+}
+
+func textLength(n *html.Node) int {
+	if n.Type == html.TextNode {
+		return len(strings.TrimSpace(n.Data))
+	}
+	total := 0
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		total += textLength(child)
+	}
+	return total
+}
@@ -0,0 +1,137 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const defaultBrowseLimit = 10
+
+// BrowseRecordsRequest lists the most recently updated documents of a dataset without a query,
+// filtered the way QueryRecords filters chunks.
+type BrowseRecordsRequest struct {
+	DatasetID string
+	// GroupIDs keeps documents visible to one of the groups, documents without group IDs always match
+	GroupIDs     []int
+	Tags         []string
+	TagMatchMode TagMatchMode
+	// Limit 0 returns 10, at most MaxRetrieveResults
+	Limit int
+}
+
+// BrowseEntry is a document of BrowseRecords, split documents are listed once under their base ID.
+type BrowseEntry struct {
+	DocID string
+	Title string
+	URL   string
+	// UpdatedAt is RFC 3339, empty for documents upserted before it was recorded
+	UpdatedAt string
+	Tags      []string
+}
+
+func validateBrowseLimit(limit int) error {
+	if limit < 0 || limit > MaxRetrieveResults {
+		return fmt.Errorf("%w: browse limit must be in [0, %d]", ErrInvalidRequest, MaxRetrieveResults)
+	}
+	return nil
+}
+
+// BrowseRecords lists the documents with their upsert metadata and filters them here,
+// raglite has no listing by metadata. Archived documents and attachments are left out.
+func (s *CTRAG) BrowseRecords(ctx context.Context, req *BrowseRecordsRequest) (entries []BrowseEntry, err error) {
+	ctx, span := s.startSpan(ctx, "BrowseRecords", datasetAttr(req.DatasetID), attribute.Int("rag.limit", req.Limit))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(resultCountAttr(len(entries)))
+		}
+		endSpan(span, err)
+	}()
+	if err := s.groupFilter.check(&QueryRecordsRequest{DatasetID: req.DatasetID, GroupIDs: req.GroupIDs}); err != nil {
+		return nil, err
+	}
+	if err := validateBrowseLimit(req.Limit); err != nil {
+		return nil, err
+	}
+	tagMatchMode, err := req.TagMatchMode.resolve()
+	if err != nil {
+		return nil, err
+	}
+	docs, err := s.ListDocuments(ctx, req.DatasetID, nil)
+	if err != nil {
+		return nil, err
+	}
+	return browseDocuments(docs, req, tagMatchMode), nil
+}
+
+// browseDocuments filters docs by req and returns the most recently updated first.
+func browseDocuments(docs []Document, req *BrowseRecordsRequest, tagMatchMode TagMatchMode) []BrowseEntry {
+	type candidate struct {
+		entry     BrowseEntry
+		updatedAt time.Time
+	}
+	var candidates []candidate
+	seen := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		meta := doc.MetaData
+		if meta.Archived || meta.ParentDocID != "" {
+			continue
+		}
+		if meta.GroupIDs != nil && len(req.GroupIDs) > 0 && !slices.ContainsFunc(meta.GroupIDs, func(id int) bool { return slices.Contains(req.GroupIDs, id) }) {
+			continue
+		}
+		if !matchTags(doc.Tags, req.Tags, tagMatchMode) {
+			continue
+		}
+		docID := doc.ID
+		if meta.BaseDocID != "" {
+			docID = meta.BaseDocID
+		}
+		if seen[docID] {
+			continue
+		}
+		seen[docID] = true
+		// unparsable times sort last
+		updatedAt, _ := time.Parse(time.RFC3339, meta.UpdatedAt)
+		candidates = append(candidates, candidate{
+			entry: BrowseEntry{
+				DocID:     docID,
+				Title:     meta.Title,
+				URL:       meta.URL,
+				UpdatedAt: meta.UpdatedAt,
+				Tags:      doc.Tags,
+			},
+			updatedAt: updatedAt,
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].updatedAt.Equal(candidates[j].updatedAt) {
+			return candidates[i].updatedAt.After(candidates[j].updatedAt)
+		}
+		return candidates[i].entry.DocID < candidates[j].entry.DocID
+	})
+	limit := req.Limit
+	if limit == 0 {
+		limit = defaultBrowseLimit
+	}
+	entries := make([]BrowseEntry, 0, min(limit, len(candidates)))
+	for _, c := range candidates[:min(limit, len(candidates))] {
+		entries = append(entries, c.entry)
+	}
+	return entries
+}
+
+// matchTags reports whether docTags carry any, or with TagMatchAll every, of tags.
+func matchTags(docTags, tags []string, mode TagMatchMode) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	if mode == TagMatchAll {
+		return !slices.ContainsFunc(tags, func(tag string) bool { return !slices.Contains(docTags, tag) })
+	}
+	return slices.ContainsFunc(tags, func(tag string) bool { return slices.Contains(docTags, tag) })
+}
@@ -0,0 +1,244 @@
+package rag
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+const defaultQueryCacheMaxEntries = 1000
+
+type queryCacheEntry struct {
+	res        *QueryResult
+	datasetIDs []string
+	expires    time.Time
+}
+
+// QueryCacheStats counts QueryRecords lookups of a CachedRAG.
+type QueryCacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// CachedRAG caches QueryRecords results for a TTL. Writes through it drop the cached
+// results of the datasets they touch, writes to the provider from elsewhere are only
+// seen once the entries expire.
+type CachedRAG struct {
+	RAGService
+
+	ttl        time.Duration
+	maxEntries int
+	logger     *log.Logger
+
+	mu      sync.Mutex
+	entries map[string]queryCacheEntry
+	// writes counts invalidations, results of queries that raced with one are not cached
+	writes uint64
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// NewCachedRAG caches results of inner for ttl, maxEntries 0 means 1000.
+func NewCachedRAG(inner RAGService, ttl time.Duration, maxEntries int, logger *log.Logger) *CachedRAG {
+	if maxEntries <= 0 {
+		maxEntries = defaultQueryCacheMaxEntries
+	}
+	return &CachedRAG{
+		RAGService: inner,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		logger:     logger.WithModule("store.vector.cached"),
+		entries:    make(map[string]queryCacheEntry),
+	}
+}
+
+// Stats returns the hit and miss counts since the cache was created.
+func (s *CachedRAG) Stats() QueryCacheStats {
+	return QueryCacheStats{Hits: s.hits.Load(), Misses: s.misses.Load()}
+}
+
+// queryCacheKey identifies a query by everything that changes its result,
+// the query text is compared case and whitespace insensitively.
+func queryCacheKey(req *QueryRecordsRequest) (string, error) {
+	normalized := *req
+	normalized.Query = strings.Join(strings.Fields(strings.ToLower(req.Query)), " ")
+	normalized.DatasetID = ""
+	normalized.DatasetIDs = slices.Sorted(slices.Values(req.datasetIDs()))
+	key, err := json.Marshal(normalized)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+func (s *CachedRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	key, err := queryCacheKey(req)
+	if err != nil {
+		s.logger.Warn("build query cache key failed, skip the cache", log.Error(err))
+		return s.RAGService.QueryRecords(ctx, req)
+	}
+	s.mu.Lock()
+	entry, ok := s.entries[key]
+	writes := s.writes
+	s.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		hits := s.hits.Add(1)
+		s.logger.Debug("query cache hit", log.String("query", req.Query), log.Int64("hits", int64(hits)), log.Int64("misses", int64(s.misses.Load())))
+		return cloneQueryResult(entry.res), nil
+	}
+	s.misses.Add(1)
+	res, err := s.RAGService.QueryRecords(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	s.put(key, writes, queryCacheEntry{
+		res:        cloneQueryResult(res),
+		datasetIDs: req.datasetIDs(),
+		expires:    time.Now().Add(s.ttl),
+	})
+	return res, nil
+}
+
+func (s *CachedRAG) QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error) {
+	multiReq, err := multiQueryRequest(datasetIDs, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryRecords(ctx, multiReq)
+}
+
+func (s *CachedRAG) QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error) {
+	return streamQueryResult(ctx, func(ctx context.Context) (*QueryResult, error) {
+		return s.QueryRecords(ctx, req)
+	})
+}
+
+// put stores entry unless a write happened since writes was read.
+func (s *CachedRAG) put(key string, writes uint64, entry queryCacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writes != writes {
+		return
+	}
+	if len(s.entries) >= s.maxEntries {
+		now := time.Now()
+		for k, e := range s.entries {
+			if !now.Before(e.expires) {
+				delete(s.entries, k)
+			}
+		}
+		if len(s.entries) >= s.maxEntries {
+			s.entries = make(map[string]queryCacheEntry)
+		}
+	}
+	s.entries[key] = entry
+}
+
+// invalidate drops the cached results that include datasetID.
+func (s *CachedRAG) invalidate(datasetID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writes++
+	for key, entry := range s.entries {
+		if slices.Contains(entry.datasetIDs, datasetID) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (s *CachedRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	defer s.invalidate(req.DatasetID)
+	return s.RAGService.UpsertRecords(ctx, req)
+}
+
+func (s *CachedRAG) BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) ([]UpsertResult, error) {
+	defer func() {
+		for _, req := range reqs {
+			s.invalidate(req.DatasetID)
+		}
+	}()
+	return s.RAGService.BatchUpsertRecords(ctx, reqs)
+}
+
+func (s *CachedRAG) UpsertFile(ctx context.Context, req *UpsertFileRequest) (string, error) {
+	defer s.invalidate(req.DatasetID)
+	return s.RAGService.UpsertFile(ctx, req)
+}
+
+func (s *CachedRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.DeleteRecords(ctx, datasetID, docIDs)
+}
+
+func (s *CachedRAG) DeleteAllDocuments(ctx context.Context, datasetID string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.DeleteAllDocuments(ctx, datasetID)
+}
+
+func (s *CachedRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.DeleteKnowledgeBase(ctx, datasetID)
+}
+
+func (s *CachedRAG) SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.SetKnowledgeBaseOptions(ctx, datasetID, opts)
+}
+
+func (s *CachedRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.UpdateDocumentGroupIDs(ctx, datasetID, docID, groupIds)
+}
+
+func (s *CachedRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.UpdateDocumentPermissions(ctx, datasetID, docID, groupIds, visibility)
+}
+
+func (s *CachedRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.UpdateDocumentTags(ctx, datasetID, docID, tags)
+}
+
+func (s *CachedRAG) UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.UpdateDocumentMetadata(ctx, datasetID, docID, groupIds, tags)
+}
+
+func (s *CachedRAG) UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.UpdateDocument(ctx, datasetID, docID, patch)
+}
+
+func (s *CachedRAG) ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.ArchiveDocuments(ctx, datasetID, docIDs)
+}
+
+func (s *CachedRAG) RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	defer s.invalidate(datasetID)
+	return s.RAGService.RestoreDocuments(ctx, datasetID, docIDs)
+}
+
+// cloneQueryResult copies res and its chunks, so callers can modify what they get.
+func cloneQueryResult(res *QueryResult) *QueryResult {
+	clone := *res
+	clone.Chunks = make([]*domain.NodeContentChunk, len(res.Chunks))
+	for i, chunk := range res.Chunks {
+		c := *chunk
+		clone.Chunks[i] = &c
+	}
+	if res.Stats != nil {
+		stats := *res.Stats
+		clone.Stats = &stats
+	}
+	return &clone
+}
@@ -0,0 +1,68 @@
+package rag
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+type countingRAG struct {
+	*DisabledRAG
+	queries atomic.Int64
+}
+
+func (s *countingRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	s.queries.Add(1)
+	res := newQueryResult(req.Query, req.Query)
+	res.Chunks = []*domain.NodeContentChunk{{ID: "c1", DatasetID: req.DatasetID}}
+	return res, nil
+}
+
+func TestCachedRAG(t *testing.T) {
+	inner := &countingRAG{DisabledRAG: &DisabledRAG{}}
+	service := NewCachedRAG(inner, time.Minute, 0, log.NewLogger(&config.Config{}))
+	ctx := context.Background()
+
+	res, err := service.QueryRecords(ctx, &QueryRecordsRequest{DatasetID: "a", Query: "How  to install"})
+	require.NoError(t, err)
+	res.Chunks[0].Content = "modified by caller"
+	res, err = service.QueryRecords(ctx, &QueryRecordsRequest{DatasetID: "a", Query: "how to install"})
+	require.NoError(t, err)
+	assert.Empty(t, res.Chunks[0].Content)
+	_, err = service.QueryRecords(ctx, &QueryRecordsRequest{DatasetID: "b", Query: "how to install"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), inner.queries.Load())
+	assert.Equal(t, QueryCacheStats{Hits: 1, Misses: 2}, service.Stats())
+
+	require.NoError(t, service.DeleteRecords(ctx, "a", []string{"doc"}))
+	_, err = service.QueryRecords(ctx, &QueryRecordsRequest{DatasetID: "a", Query: "how to install"})
+	require.NoError(t, err)
+	_, err = service.QueryRecords(ctx, &QueryRecordsRequest{DatasetID: "b", Query: "how to install"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), inner.queries.Load())
+}
+
+func TestCachedRAGQueryRecordsMulti(t *testing.T) {
+	inner := &countingRAG{DisabledRAG: &DisabledRAG{}}
+	service := NewCachedRAG(inner, time.Minute, 0, log.NewLogger(&config.Config{}))
+	ctx := context.Background()
+
+	// the wrapper's QueryRecords serves it, so the second query is a cache hit
+	req := &QueryRecordsRequest{DatasetID: "a", Query: "how to install"}
+	for range 2 {
+		_, err := service.QueryRecordsMulti(ctx, []string{"a", "b"}, req)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), inner.queries.Load())
+	assert.Equal(t, "a", req.DatasetID)
+	_, err := service.QueryRecordsMulti(ctx, nil, req)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
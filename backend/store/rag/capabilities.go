@@ -0,0 +1,34 @@
+package rag
+
+// Capabilities tells callers which request features a provider honors, so they can adapt
+// instead of assuming the semantics of CTRAG.
+type Capabilities struct {
+	// SupportsTags filters retrieval by the tags of documents
+	SupportsTags bool `json:"supports_tags"`
+	// SupportsChatHistoryRewrite rewrites queries from QueryRecordsRequest.HistoryMsgs
+	SupportsChatHistoryRewrite bool `json:"supports_chat_history_rewrite"`
+	// SupportsAsyncProcessing means uploads are parsed after they return, ListDocuments
+	// reports the progress in Document.Status
+	SupportsAsyncProcessing bool `json:"supports_async_processing"`
+	// SupportsGroupFilter restricts retrieval to documents of QueryRecordsRequest.GroupIDs
+	SupportsGroupFilter bool `json:"supports_group_filter"`
+	// SupportsDocumentChunks lists the chunks of documents with GetDocumentChunks. CTRAG can't,
+	// raglite has no chunk listing.
+	SupportsDocumentChunks bool `json:"supports_document_chunks"`
+	// Disabled means there is no AI search at all, see DisabledRAG
+	Disabled bool `json:"disabled"`
+}
+
+// intersect keeps the capabilities both c and other have, for wrappers that may serve a call
+// from either. Processing is async if either is, callers then have to poll. A wrapper is only
+// disabled when everything behind it is.
+func (c Capabilities) intersect(other Capabilities) Capabilities {
+	return Capabilities{
+		SupportsTags:               c.SupportsTags && other.SupportsTags,
+		SupportsChatHistoryRewrite: c.SupportsChatHistoryRewrite && other.SupportsChatHistoryRewrite,
+		SupportsAsyncProcessing:    c.SupportsAsyncProcessing || other.SupportsAsyncProcessing,
+		SupportsGroupFilter:        c.SupportsGroupFilter && other.SupportsGroupFilter,
+		SupportsDocumentChunks:     c.SupportsDocumentChunks && other.SupportsDocumentChunks,
+		Disabled:                   c.Disabled && other.Disabled,
+	}
+}
@@ -0,0 +1,28 @@
+package rag
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+func TestCapabilitiesIntersect(t *testing.T) {
+	ct := (&CTRAG{}).Capabilities()
+	assert.Equal(t, Capabilities{SupportsAsyncProcessing: true}, ct.intersect(Capabilities{}))
+	assert.Equal(t, ct, ct.intersect(ct))
+	// a wrapper only lists chunks when every provider behind it can
+	assert.False(t, ct.SupportsDocumentChunks)
+	chunked := Capabilities{SupportsDocumentChunks: true}
+	assert.True(t, chunked.intersect(chunked).SupportsDocumentChunks)
+	assert.False(t, chunked.intersect((&LocalRAG{}).Capabilities()).SupportsDocumentChunks)
+}
+
+func TestIsDisabled(t *testing.T) {
+	logger := log.NewLogger(&config.Config{})
+	assert.True(t, IsDisabled(NewCachedRAG(&DisabledRAG{}, time.Minute, 10, logger)))
+	assert.False(t, IsDisabled(NewMigratingRAG(&DisabledRAG{}, &LocalRAG{}, false, logger)))
+}
@@ -0,0 +1,213 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/chaitin/panda-wiki/config"
+)
+
+// ChunkStrategy picks where UpsertRecords cuts a document when a chunk size is set.
+type ChunkStrategy string
+
+const (
+	// ChunkStrategyMarkdownHeading starts a chunk at every heading and cuts longer sections
+	// between blocks, so tables and code blocks stay whole where they fit
+	ChunkStrategyMarkdownHeading ChunkStrategy = "markdown-heading"
+	// ChunkStrategyFixed cuts every ChunkSize bytes
+	ChunkStrategyFixed ChunkStrategy = "fixed"
+	// ChunkStrategySentence packs whole sentences
+	ChunkStrategySentence ChunkStrategy = "sentence"
+)
+
+// chunking is the resolved chunking of an upload, size 0 leaves it to the provider.
+type chunking struct {
+	size     int
+	overlap  int
+	strategy ChunkStrategy
+}
+
+// resolveChunking fills the chunking fields req leaves at 0 or empty from defaults.
+func resolveChunking(req *UpsertRecordsRequest, defaults config.RAGChunkingConfig) (chunking, error) {
+	c := chunking{
+		size:     req.ChunkSize,
+		overlap:  req.ChunkOverlap,
+		strategy: req.ChunkStrategy,
+	}
+	if c.size == 0 {
+		c.size = defaults.Size
+	}
+	switch {
+	case c.overlap == 0:
+		c.overlap = defaults.Overlap
+	case c.overlap < 0:
+		c.overlap = 0
+	}
+	if c.strategy == "" {
+		c.strategy = ChunkStrategy(defaults.Strategy)
+	}
+	if c.size < 0 {
+		return chunking{}, fmt.Errorf("%w: chunk size must not be negative", ErrInvalidRequest)
+	}
+	if c.size == 0 {
+		return chunking{}, nil
+	}
+	if c.overlap < 0 || c.overlap >= c.size {
+		return chunking{}, fmt.Errorf("%w: chunk overlap must be in [0, %d)", ErrInvalidRequest, c.size)
+	}
+	switch c.strategy {
+	case "":
+		c.strategy = ChunkStrategyMarkdownHeading
+	case ChunkStrategyMarkdownHeading, ChunkStrategyFixed, ChunkStrategySentence:
+	default:
+		return chunking{}, fmt.Errorf("%w: unsupported chunk strategy: %s", ErrInvalidRequest, c.strategy)
+	}
+	return c, nil
+}
+
+// ValidateChunking checks chunking options before they are stored, for example with a knowledge
+// base. Fields left at 0 or empty take defaults, as in an upsert.
+func ValidateChunking(size, overlap int, strategy ChunkStrategy, defaults config.RAGChunkingConfig) error {
+	_, err := resolveChunking(&UpsertRecordsRequest{ChunkSize: size, ChunkOverlap: overlap, ChunkStrategy: strategy}, defaults)
+	return err
+}
+
+// String is stored with the document, so changing the chunking uploads it again.
+func (c chunking) String() string {
+	return fmt.Sprintf("%s/%d/%d", c.strategy, c.size, c.overlap)
+}
+
+// chunkMarkdown cuts markdown into chunks of at most c.size bytes, each starting with the
+// last c.overlap bytes of the one before.
+func chunkMarkdown(markdown string, c chunking) []string {
+	size := c.size - c.overlap
+	var chunks []string
+	switch c.strategy {
+	case ChunkStrategyFixed:
+		chunks = cutFixed(markdown, size)
+	case ChunkStrategySentence:
+		chunks = packSentences(markdown, size)
+	default:
+		for _, section := range headingSections(markdown) {
+			chunks = append(chunks, splitMarkdown(section, size)...)
+		}
+	}
+	if c.overlap == 0 {
+		return chunks
+	}
+	overlapped := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		if i > 0 {
+			chunk = runeTail(chunks[i-1], c.overlap) + chunk
+		}
+		overlapped[i] = chunk
+	}
+	return overlapped
+}
+
+// headingSections splits markdown before every heading outside fenced code.
+func headingSections(markdown string) []string {
+	var sections []string
+	var current strings.Builder
+	for _, block := range markdownBlocks(markdown) {
+		if strings.HasPrefix(strings.TrimSpace(block), "#") && strings.TrimSpace(current.String()) != "" {
+			sections = append(sections, current.String())
+			current.Reset()
+		}
+		current.WriteString(block)
+	}
+	if strings.TrimSpace(current.String()) != "" {
+		sections = append(sections, current.String())
+	}
+	return sections
+}
+
+// cutFixed cuts s every size bytes, moved back to a rune boundary.
+func cutFixed(s string, size int) []string {
+	var chunks []string
+	for len(s) > size {
+		cut := size
+		for cut > 0 && !isRuneStart(s[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			cut = size
+		}
+		chunks = append(chunks, s[:cut])
+		s = s[cut:]
+	}
+	if s != "" {
+		chunks = append(chunks, s)
+	}
+	return chunks
+}
+
+// packSentences fills chunks with whole sentences, a sentence longer than size is cut fixed.
+func packSentences(s string, size int) []string {
+	var chunks []string
+	var current strings.Builder
+	flush := func() {
+		if strings.TrimSpace(current.String()) != "" {
+			chunks = append(chunks, current.String())
+		}
+		current.Reset()
+	}
+	for _, sentence := range sentences(s) {
+		if current.Len()+len(sentence) > size {
+			flush()
+		}
+		if len(sentence) <= size {
+			current.WriteString(sentence)
+			continue
+		}
+		chunks = append(chunks, cutFixed(sentence, size)...)
+	}
+	flush()
+	return chunks
+}
+
+// sentences splits s after sentence ends: . ! ? followed by a space, their full-width
+// forms, and line breaks. Separators stay with the sentence before them.
+func sentences(s string) []string {
+	var out []string
+	runes := []rune(s)
+	start := 0
+	for i, r := range runes {
+		end := false
+		switch r {
+		case '\n', '。', '！', '？':
+			end = true
+		case '.', '!', '?':
+			end = i+1 < len(runes) && unicode.IsSpace(runes[i+1])
+		}
+		if !end {
+			continue
+		}
+		// keep trailing spaces with this sentence
+		j := i + 1
+		for j < len(runes) && runes[j] == ' ' {
+			j++
+		}
+		if j > start {
+			out = append(out, string(runes[start:j]))
+			start = j
+		}
+	}
+	if start < len(runes) {
+		out = append(out, string(runes[start:]))
+	}
+	return out
+}
+
+// runeTail returns at most the last n bytes of s, starting at a rune boundary.
+func runeTail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	start := len(s) - n
+	for start < len(s) && !isRuneStart(s[start]) {
+		start++
+	}
+	return s[start:]
+}
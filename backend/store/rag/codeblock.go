@@ -0,0 +1,82 @@
+package rag
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/JohannesKaufmann/dom"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"golang.org/x/net/html"
+)
+
+// codeLanguageRe matches what may go in a fence info string.
+var codeLanguageRe = regexp.MustCompile(`^[A-Za-z0-9_+#.-]+$`)
+
+// codeLanguageClassPrefixes mark the language in a class, as highlight.js and prism do.
+var codeLanguageClassPrefixes = []string{"language-", "lang-"}
+
+// renderCodeBlock writes a pre element as a fenced code block with the language of the editor.
+// The text is written byte for byte, highlighting spans are dropped and <br> becomes a line break.
+func renderCodeBlock(ctx converter.Context, w converter.Writer, n *html.Node) converter.RenderStatus {
+	code := n
+	if children := elementChildren(n, "code"); len(children) == 1 {
+		code = children[0]
+	}
+	text := strings.TrimSuffix(codeText(code), "\n")
+	fence := codeFence(text)
+	w.WriteString("\n\n")
+	w.WriteString(fence + codeLanguage(code, n) + "\n")
+	w.WriteString(text)
+	w.WriteString("\n" + fence + "\n\n")
+	return converter.RenderSuccess
+}
+
+// codeLanguage reads the language from data-language or a language class, of the code
+// element first and then of the pre.
+func codeLanguage(nodes ...*html.Node) string {
+	for _, n := range nodes {
+		if lang, ok := dom.GetAttribute(n, "data-language"); ok && codeLanguageRe.MatchString(lang) {
+			return lang
+		}
+		for _, class := range dom.GetClasses(n) {
+			for _, prefix := range codeLanguageClassPrefixes {
+				if lang, ok := strings.CutPrefix(class, prefix); ok && codeLanguageRe.MatchString(lang) {
+					return lang
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func codeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch {
+		case n.Type == html.TextNode:
+			sb.WriteString(n.Data)
+		case n.Type == html.ElementNode && n.Data == "br":
+			sb.WriteString("\n")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return sb.String()
+}
+
+// codeFence returns a backtick fence longer than any backtick run in text.
+func codeFence(text string) string {
+	longest, run := 0, 0
+	for _, r := range text {
+		if r != '`' {
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
@@ -1,14 +1,28 @@
 package rag
 
 import (
+	"cmp"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
 	raglite "github.com/chaitin/raglite-go-sdk"
 	"github.com/cloudwego/eino/schema"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/chaitin/panda-wiki/config"
 	"github.com/chaitin/panda-wiki/domain"
@@ -20,36 +34,289 @@ type CTRAG struct {
 	client *raglite.Client
 	logger *log.Logger
 	mdConv *converter.Converter
+
+	similarityThreshold float64
+	limiter             *priorityLimiter
+	determinism         determinism
+	metrics             MetricsRecorder
+	httpClient          *http.Client
+	docCache            *docCache
+	datasetCache        *datasetCache
+	groupFilter         *groupFilterPolicy
+	maxContentSize      int
+	chunking            config.RAGChunkingConfig
+	rawHTMLFallback     bool
+	tracer              trace.Tracer
+	batchUpsertWorkers  int
+	rerankStrict        bool
+}
+
+// CTRAGOption customizes a CTRAG built by NewCTRAG.
+type CTRAGOption func(*CTRAG)
+
+// WithHTTPClient makes the raglite client use c instead of one built from the ct_rag pool settings.
+func WithHTTPClient(c *http.Client) CTRAGOption {
+	return func(s *CTRAG) {
+		s.httpClient = c
+	}
+}
+
+const (
+	defaultRagliteMaxIdleConns    = 100
+	defaultRagliteIdleConnTimeout = 90 * time.Second
+)
+
+// newRagliteHTTPClient builds a client whose connection pool is sized from config,
+// by default every concurrent request may keep an idle connection to raglite.
+func newRagliteHTTPClient(cfg config.CTRAGConfig) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = cfg.MaxIdleConns
+	if transport.MaxIdleConns == 0 {
+		transport.MaxIdleConns = defaultRagliteMaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	if transport.MaxIdleConnsPerHost == 0 {
+		transport.MaxIdleConnsPerHost = max(cfg.MaxConcurrency, http.DefaultMaxIdleConnsPerHost)
+	}
+	transport.IdleConnTimeout = cfg.IdleConnTimeout
+	if transport.IdleConnTimeout == 0 {
+		transport.IdleConnTimeout = defaultRagliteIdleConnTimeout
+	}
+	return &http.Client{Transport: transport, Timeout: cfg.Timeout}
 }
 
-func NewCTRAG(config *config.Config, logger *log.Logger) (*CTRAG, error) {
+func NewCTRAG(config *config.Config, logger *log.Logger, opts ...CTRAGOption) (*CTRAG, error) {
+	similarityThreshold := cmp.Or(config.RAG.CTRAG.SimilarityThreshold, config.RAG.DefaultSimilarityThreshold)
+	if err := validateSimilarityThreshold(similarityThreshold); err != nil {
+		return nil, fmt.Errorf("invalid ct_rag config: %w", err)
+	}
+	mdOpts, err := HTML2MDOptionsFromConfig(config.RAG.Markdown)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rag config: %w", err)
+	}
+	s := &CTRAG{
+		logger:              logger.WithModule("store.vector.ct"),
+		mdConv:              NewHTML2MDConverter(mdOpts...),
+		similarityThreshold: similarityThreshold,
+		limiter:             newPriorityLimiter(config.RAG.CTRAG.MaxConcurrency, config.RAG.CTRAG.InteractiveReserved),
+		determinism:         determinism{enabled: config.RAG.Deterministic},
+		metrics:             noopMetrics{},
+		docCache:            newDocCache(),
+		datasetCache:        newDatasetCache(config.RAG.CTRAG.DatasetCacheTTL),
+		groupFilter:         newGroupFilterPolicy(config.RAG.GroupFilterDatasets),
+		maxContentSize:      cmp.Or(config.RAG.CTRAG.MaxContentSize, config.RAG.MaxDocumentBytes),
+		chunking:            config.RAG.Chunking,
+		rawHTMLFallback:     config.RAG.Markdown.RawHTMLFallback,
+		tracer:              noopTracer(),
+		batchUpsertWorkers:  cmp.Or(config.RAG.CTRAG.BatchUpsertWorkers, defaultBatchUpsertWorkers),
+		rerankStrict:        config.RAG.CTRAG.RerankStrict,
+	}
+	if _, err := resolveChunking(&UpsertRecordsRequest{}, s.chunking); err != nil {
+		return nil, fmt.Errorf("invalid rag chunking config: %w", err)
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.httpClient == nil {
+		s.httpClient = newRagliteHTTPClient(config.RAG.CTRAG)
+	}
+	if limiter := newRateLimiter(config.RAG.CTRAG.RateLimit, config.RAG.CTRAG.RateLimitBurst); limiter != nil {
+		client := *s.httpClient
+		client.Transport = newRateLimitedTransport(client.Transport, limiter)
+		s.httpClient = &client
+	}
 	client, err := raglite.NewClient(
 		config.RAG.CTRAG.BaseURL,
 		raglite.WithAPIKey(config.RAG.CTRAG.APIKey),
+		raglite.WithHTTPClient(s.httpClient),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create raglite client: %w", err)
 	}
-	return &CTRAG{
-		client: client,
-		logger: logger.WithModule("store.vector.ct"),
-		mdConv: NewHTML2MDConverter(),
-	}, nil
+	s.client = client
+	return s, nil
+}
+
+// PriorityStats reports limiter usage per priority class.
+func (s *CTRAG) PriorityStats() map[Priority]PriorityStats {
+	return s.limiter.stats()
+}
+
+func (s *CTRAG) Capabilities() Capabilities {
+	return Capabilities{
+		SupportsTags:               true,
+		SupportsChatHistoryRewrite: true,
+		SupportsAsyncProcessing:    true,
+		SupportsGroupFilter:        true,
+	}
 }
 
-func (s *CTRAG) CreateKnowledgeBase(ctx context.Context) (string, error) {
+// CreateKnowledgeBase only knows the default provider, named instances need rag.instances.
+func (s *CTRAG) CreateKnowledgeBase(ctx context.Context, name string, provider string) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "CreateKnowledgeBase")
+	defer func() { endSpan(span, err) }()
+	if provider != "" {
+		return "", fmt.Errorf("%w: unknown rag provider instance: %s", ErrInvalidRequest, provider)
+	}
+	if name == "" {
+		name = uuid.New().String()
+	}
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	start := time.Now()
 	dataset, err := s.client.Datasets.Create(ctx, &raglite.CreateDatasetRequest{
-		Name: uuid.New().String(),
+		Name: name,
 	})
+	s.observe("create_dataset", start, err)
 	if err != nil {
-		return "", err
+		return "", translateError("create dataset", err, ErrDatasetNotFound)
 	}
+	s.datasetCache.add(dataset.ID, start)
 	return dataset.ID, nil
 }
 
-func (s *CTRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (string, []*domain.NodeContentChunk, error) {
+// listKnowledgeBasesWorkers bounds the concurrent document counts of ListKnowledgeBases
+const listKnowledgeBasesWorkers = 4
+
+// ListKnowledgeBases lists the raglite datasets with their document counts. Each count takes a
+// request of its own, up to listKnowledgeBasesWorkers of them run at a time.
+func (s *CTRAG) ListKnowledgeBases(ctx context.Context) (kbs []KnowledgeBase, err error) {
+	ctx, span := s.startSpan(ctx, "ListKnowledgeBases")
+	defer func() {
+		if err == nil {
+			span.SetAttributes(resultCountAttr(len(kbs)))
+		}
+		endSpan(span, err)
+	}()
+	datasets, err := s.listDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kbs = make([]KnowledgeBase, len(datasets))
+	errs := make([]error, len(datasets))
+	workers := make(chan struct{}, listKnowledgeBasesWorkers)
+	var wg sync.WaitGroup
+	for i, dataset := range datasets {
+		kbs[i] = KnowledgeBase{ID: dataset.ID, Name: dataset.Name}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			count, err := s.CountDocuments(ctx, dataset.ID)
+			if err != nil {
+				errs[i] = fmt.Errorf("count documents of %s: %w", dataset.ID, err)
+				return
+			}
+			kbs[i].DocumentCount = count
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return kbs, nil
+}
+
+func (s *CTRAG) listDatasets(ctx context.Context) ([]raglite.Dataset, error) {
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
+	res, err := s.client.Datasets.List(ctx, &raglite.ListDatasetsRequest{})
+	s.observe("list_datasets", start, err)
+	if err != nil {
+		return nil, translateError("list datasets", err, ErrNotImplemented)
+	}
+	return res.Datasets, nil
+}
+
+func (s *CTRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (res *QueryResult, err error) {
+	ctx, span := s.startSpan(ctx, "QueryRecords", datasetAttr(strings.Join(req.datasetIDs(), ",")), attribute.Int("rag.query_length", utf8.RuneCountInString(req.Query)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(resultCountAttr(len(res.Chunks)))
+		}
+		endSpan(span, err)
+	}()
+	res, err = s.queryPage(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	finish := s.chunkFinisher(req, res)
+	page := make([]*domain.NodeContentChunk, 0, len(res.Chunks))
+	for _, chunk := range res.Chunks {
+		if chunk = finish(ctx, chunk); chunk != nil {
+			page = append(page, chunk)
+		}
+	}
+	res.Chunks = page
+	return res, nil
+}
+
+func (s *CTRAG) QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error) {
+	multiReq, err := multiQueryRequest(datasetIDs, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryRecords(ctx, multiReq)
+}
+
+// queryPage runs a query up to the requested page, the per chunk stages of chunkFinisher are left out.
+func (s *CTRAG) queryPage(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	if err := s.groupFilter.check(req); err != nil {
+		return nil, err
+	}
+	similarityThreshold := req.SimilarityThreshold
+	switch similarityThreshold {
+	case 0:
+		similarityThreshold = s.similarityThreshold
+		if similarityThreshold > 0 {
+			s.logger.WithContext(ctx).Info("query without similarity threshold, using the default", log.String("dataset_id", req.DatasetID), log.Any("similarity_threshold", similarityThreshold))
+		}
+	case NoSimilarityThreshold:
+		similarityThreshold = 0
+	}
+	if err := validateSimilarityThreshold(similarityThreshold); err != nil {
+		return nil, err
+	}
+	if err := validateMetadataFilters(req.MetadataFilters); err != nil {
+		return nil, err
+	}
+	if err := validateMMRLambda(req.MMRLambda); err != nil {
+		return nil, err
+	}
+	if err := validateHybridAlpha(req.HybridAlpha); err != nil {
+		return nil, err
+	}
+	if err := validateRecencyBoost(req.RecencyBoost); err != nil {
+		return nil, err
+	}
+	if err := validateDateRange(req); err != nil {
+		return nil, err
+	}
+	if err := validateExpandNeighbors(req.ExpandNeighbors); err != nil {
+		return nil, err
+	}
+	if err := validateLanguage(req.Language); err != nil {
+		return nil, err
+	}
+	if req.EmbeddingModel != "" {
+		if err := s.checkEmbeddingModel(ctx, req.EmbeddingModel); err != nil {
+			return nil, err
+		}
+	}
+	topK, err := retrieveTopK(req)
+	if err != nil {
+		return nil, err
+	}
 	var chatMsgs []raglite.ChatMessage
-	for _, msg := range req.HistoryMsgs {
+	for _, msg := range historyWindow(req) {
 		switch msg.Role {
 		case schema.User:
 			chatMsgs = append(chatMsgs, raglite.ChatMessage{
@@ -65,43 +332,534 @@ func (s *CTRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (str
 			continue
 		}
 	}
-	s.logger.Debug("retrieving by history msgs", log.Any("history_msgs", req.HistoryMsgs), log.Any("chat_msgs", chatMsgs))
+	s.logger.WithContext(ctx).Debug("retrieving by history msgs", log.Any("history_msgs", req.HistoryMsgs), log.Any("chat_msgs", chatMsgs))
+	searchMode, err := req.SearchMode.resolve()
+	if err != nil {
+		return nil, err
+	}
+	tagMatchMode, err := req.TagMatchMode.resolve()
+	if err != nil {
+		return nil, err
+	}
+	retrieveK := topK + excludeOverfetch(req, topK)
+	if req.Rerank {
+		retrieveK *= rerankOverfetch
+	}
+	if req.MMRLambda > 0 {
+		retrieveK *= diversityOverfetch
+	}
+	retrieveK = min(retrieveK, MaxRetrieveResults)
+	metadata := make(map[string]interface{}, len(req.MetadataFilters)+1)
+	for key, value := range req.MetadataFilters {
+		metadata[key] = value
+	}
+	metadata["group_ids"] = req.GroupIDs
 	data := &raglite.RetrieveRequest{
-		DatasetID: req.DatasetID,
-		Query:     req.Query,
-		TopK:      10,
-		Metadata: map[string]interface{}{
-			"group_ids": req.GroupIDs,
-		},
+		Query:               req.Query,
+		TopK:                retrieveK,
+		Metadata:            metadata,
 		Tags:                req.Tags,
-		SimilarityThreshold: req.SimilarityThreshold,
+		SimilarityThreshold: similarityThreshold,
 		ChatHistory:         chatMsgs,
 		MaxChunksPerDoc:     req.MaxChunksPerDoc,
 	}
-	res, err := s.client.Search.Retrieve(ctx, data)
+	opts := retrieveOptions{
+		searchMode:   searchMode,
+		hybridAlpha:  req.HybridAlpha,
+		excludeTags:  req.ExcludeTags,
+		recencyBoost: req.RecencyBoost,
+		counter:      &retrievalCounter{},
+	}
+	if req.hasDateRange() {
+		opts.dateFrom, opts.dateTo, opts.dateField = req.DateFrom, req.DateTo, req.DateField
+		if opts.dateField == "" {
+			opts.dateField = DefaultDateField
+		}
+	}
+	// the threshold is applied here rather than by raglite to count the chunks it cuts,
+	// raglite ranks by the same score so the top K above it are the same chunks. Keyword
+	// matches are kept whatever their similarity.
+	if searchMode != SearchModeKeyword {
+		opts.similarityThreshold = similarityThreshold
+	}
+	data.SimilarityThreshold = 0
+	// raglite matches any of the tags, documents missing some are dropped after retrieval
+	if tagMatchMode == TagMatchAll && len(req.Tags) > 1 {
+		opts.requireTags = req.Tags
+	}
+	if req.DryRun {
+		res := newQueryResult(req.Query, req.Query)
+		res.DryRunPayload = retrieveRequests(req.datasetIDs(), data)
+		return res, nil
+	}
+	retrieveStart := time.Now()
+	queries, nodeChunks, err := s.retrieveDatasets(ctx, req.datasetIDs(), data, opts)
+	if err != nil {
+		return nil, err
+	}
+	stats := opts.counter.stats("ct", time.Since(retrieveStart))
+	s.logger.WithContext(ctx).Debug("retrieval stats", log.String("query", req.Query), log.Any("stats", stats))
+	query := queries[0]
+	nodeChunks = excludeDocs(nodeChunks, req.ExcludeDocIDs)
+	if req.Dedup {
+		nodeChunks = dedupChunks(nodeChunks)
+	}
+	// the per-doc cap is pushed down, but hybrid fusion and multi-dataset merges can exceed it
+	nodeChunks = capChunksPerDoc(nodeChunks, req.MaxChunksPerDoc)
+	nodeChunks = preferLanguage(nodeChunks, req.Language, topK)
+	if req.Rerank {
+		rerankN := topK
+		if req.MMRLambda > 0 {
+			rerankN = len(nodeChunks)
+		}
+		nodeChunks, err = s.rerank(ctx, query, nodeChunks, rerankN, req.RerankModel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if req.MMRLambda > 0 {
+		nodeChunks = selectMMR(nodeChunks, topK, req.MMRLambda)
+	}
+	nodeChunks = nodeChunks[:min(topK, len(nodeChunks))]
+	if req.DisableQueryRewrite {
+		query = req.Query
+	}
+	s.logger.WithContext(ctx).Info("retrieve chunks result", log.Int("chunks count", len(nodeChunks)), log.String("original_query", req.Query), log.String("query", query), log.String("search_mode", string(searchMode)))
+	res := newQueryResult(req.Query, query)
+	if !req.DisableQueryRewrite {
+		res.SubQueries = queries
+	}
+	res.Chunks = pageChunks(nodeChunks, req)
+	res.Total = len(nodeChunks)
+	res.Stats = stats
+	return res, nil
+}
+
+// chunkFinisher returns the per chunk stages of a query: neighbor expansion and highlighting.
+// The returned func gives nil for chunks merged into another chunk of their document.
+func (s *CTRAG) chunkFinisher(req *QueryRecordsRequest, res *QueryResult) func(ctx context.Context, chunk *domain.NodeContentChunk) *domain.NodeContentChunk {
+	var expander *neighborExpander
+	if req.ExpandNeighbors > 0 {
+		expander = s.newNeighborExpander(res.Chunks, req.ExpandNeighbors)
+	}
+	return func(ctx context.Context, chunk *domain.NodeContentChunk) *domain.NodeContentChunk {
+		if expander != nil {
+			if chunk = expander.expand(ctx, chunk); chunk == nil {
+				return nil
+			}
+		}
+		if req.Highlight {
+			chunk.Snippet = highlightSnippet(chunk.Content, res.RewrittenQuery)
+		}
+		return chunk
+	}
+}
+
+// retrieveOptions are the parts of a query applied around the raglite retrieve call.
+type retrieveOptions struct {
+	searchMode   SearchMode
+	hybridAlpha  float64
+	excludeTags  []string
+	requireTags  []string
+	recencyBoost float64
+	// documents dated outside [dateFrom, dateTo] in dateField are dropped, when a bound is set
+	dateFrom  time.Time
+	dateTo    time.Time
+	dateField string
+	// similarityThreshold is applied to vector results after retrieval
+	similarityThreshold float64
+	counter             *retrievalCounter
+}
+
+// retrieveDatasets retrieves from every dataset concurrently and merges the chunks by score.
+// Failed datasets are logged and skipped as long as one of them succeeds.
+// It returns the distinct queries raglite used in dataset order, the first one is the main query.
+func (s *CTRAG) retrieveDatasets(ctx context.Context, datasetIDs []string, data *raglite.RetrieveRequest, opts retrieveOptions) ([]string, []*domain.NodeContentChunk, error) {
+	type result struct {
+		query  string
+		chunks []*domain.NodeContentChunk
+		err    error
+	}
+	results := make([]result, len(datasetIDs))
+	workers := make(chan struct{}, queryDatasetWorkers)
+	var wg sync.WaitGroup
+	for i, datasetID := range datasetIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			datasetReq := *data
+			datasetReq.DatasetID = datasetID
+			query, chunks, err := s.retrieveDataset(ctx, &datasetReq, opts)
+			results[i] = result{query: query, chunks: chunks, err: err}
+		}()
+	}
+	wg.Wait()
+
+	var queries []string
+	var merged []*domain.NodeContentChunk
+	var lastErr error
+	succeeded := 0
+	for i, res := range results {
+		if res.err != nil {
+			if len(datasetIDs) > 1 {
+				s.logger.WithContext(ctx).Warn("retrieve from dataset failed", log.String("dataset_id", datasetIDs[i]), log.Error(res.err))
+			}
+			lastErr = res.err
+			continue
+		}
+		if !slices.Contains(queries, res.query) {
+			queries = append(queries, res.query)
+		}
+		succeeded++
+		merged = append(merged, res.chunks...)
+	}
+	if succeeded == 0 {
+		return nil, nil, lastErr
+	}
+	if len(datasetIDs) > 1 {
+		sortChunksByScore(merged, s.determinism.on(ctx))
+	}
+	return queries, merged, nil
+}
+
+// retrieveDataset retrieves ranked chunks from the single dataset of data.
+func (s *CTRAG) retrieveDataset(ctx context.Context, data *raglite.RetrieveRequest, opts retrieveOptions) (string, []*domain.NodeContentChunk, error) {
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+	var query string
+	var chunks []*domain.NodeContentChunk
+	if opts.searchMode == SearchModeHybrid {
+		query, chunks, err = s.retrieveHybrid(ctx, data, opts)
+	} else {
+		query, chunks, err = s.retrieve(ctx, data)
+		chunks = opts.counter.filterThreshold(chunks, opts.similarityThreshold)
+		sortChunksByScore(chunks, s.determinism.on(ctx))
+		if opts.searchMode == SearchModeKeyword {
+			chunks = rankByKeywords(chunks, query)
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if len(chunks) == 0 {
+		return query, chunks, nil
+	}
+	docs, err := s.lookupDocuments(ctx, data.DatasetID, chunks)
 	if err != nil {
 		return "", nil, err
 	}
-	s.logger.Info("retrieve chunks result", log.Int("chunks count", len(res.Results)), log.String("query", res.Query))
+	candidates := len(chunks)
+	chunks = excludeArchived(chunks, docs)
+	if len(opts.excludeTags) > 0 {
+		chunks = excludeTagged(chunks, docs, opts.excludeTags)
+	}
+	if len(opts.requireTags) > 0 {
+		chunks = requireTags(chunks, docs, opts.requireTags)
+	}
+	if opts.dateField != "" {
+		chunks = filterDateRange(chunks, docs, opts.dateField, opts.dateFrom, opts.dateTo)
+	}
+	opts.counter.countMetadataFiltered(candidates, len(chunks))
+	for _, chunk := range chunks {
+		chunk.DatasetID = data.DatasetID
+		if doc, ok := docs[chunk.DocID]; ok {
+			chunk.DocTitle = doc.MetaData.Title
+			chunk.DocURL = doc.MetaData.URL
+			if doc.MetaData.Lang != "" {
+				chunk.Metadata["lang"] = doc.MetaData.Lang
+			}
+			if opts.recencyBoost > 0 && opts.searchMode != SearchModeHybrid {
+				chunk.Score *= recencyFactor(doc.MetaData.UpdatedAt, s.determinism.now(ctx), opts.recencyBoost)
+			}
+			// chunks of a split document belong to its base document
+			if doc.MetaData.BaseDocID != "" {
+				chunk.DocID = doc.MetaData.BaseDocID
+			}
+		}
+	}
+	if opts.recencyBoost > 0 && opts.searchMode != SearchModeHybrid {
+		sortChunksByScore(chunks, s.determinism.on(ctx))
+	}
+	return query, chunks, nil
+}
+
+// lookupDocuments returns the documents of the chunks keyed by doc ID, from the cache where possible.
+// Retrieve results carry neither tags nor titles, so they are looked up here.
+func (s *CTRAG) lookupDocuments(ctx context.Context, datasetID string, chunks []*domain.NodeContentChunk) (map[string]Document, error) {
+	docIDs := make([]string, 0, len(chunks))
+	for _, chunk := range chunks {
+		if !slices.Contains(docIDs, chunk.DocID) {
+			docIDs = append(docIDs, chunk.DocID)
+		}
+	}
+	docs, missing := s.docCache.get(datasetID, docIDs)
+	if len(missing) == 0 {
+		return docs, nil
+	}
+	start := time.Now()
+	res, err := s.client.Documents.List(ctx, &raglite.ListDocumentsRequest{
+		DatasetID:   datasetID,
+		DocumentIDs: missing,
+	})
+	s.observe("list_documents", start, err)
+	if err != nil {
+		return nil, translateError("list documents of chunks", err, ErrDatasetNotFound)
+	}
+	listed := make([]Document, len(res.Documents))
+	for i, document := range res.Documents {
+		listed[i] = s.toDocument(ctx, document)
+		docs[listed[i].ID] = listed[i]
+	}
+	s.docCache.put(datasetID, listed)
+	return docs, nil
+}
+
+// excludeArchived drops chunks of archived documents. raglite metadata filters match by equality
+// and documents uploaded before archiving existed carry no archived flag, so this is not a retrieve filter.
+func excludeArchived(chunks []*domain.NodeContentChunk, docs map[string]Document) []*domain.NodeContentChunk {
+	kept := make([]*domain.NodeContentChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if doc, ok := docs[chunk.DocID]; ok && doc.MetaData.Archived {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+	return kept
+}
+
+// excludeTagged drops chunks of documents carrying any of tags.
+// Documents that cannot be found are dropped too.
+func excludeTagged(chunks []*domain.NodeContentChunk, docs map[string]Document, tags []string) []*domain.NodeContentChunk {
+	kept := make([]*domain.NodeContentChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		doc, ok := docs[chunk.DocID]
+		if !ok || slices.ContainsFunc(doc.Tags, func(tag string) bool { return slices.Contains(tags, tag) }) {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+	return kept
+}
+
+// rerank reorders chunks with the named rerank model, or the first one registered in raglite, and keeps the top n.
+// Without a rerank model, or when the model fails, the retrieval order is kept unless rerank_strict is set.
+// A named model that is not registered is a caller error.
+func (s *CTRAG) rerank(ctx context.Context, query string, chunks []*domain.NodeContentChunk, n int, modelName string) ([]*domain.NodeContentChunk, error) {
+	keep := func() []*domain.NodeContentChunk {
+		return chunks[:min(n, len(chunks))]
+	}
+	// the slot only covers the raglite lookup, the rerank call goes to the model endpoint
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	model, err := s.rerankModel(ctx, modelName)
+	release()
+	if err != nil {
+		if ctx.Err() != nil || errors.Is(err, ErrModelNotFound) || s.rerankStrict {
+			return nil, err
+		}
+		s.logger.WithContext(ctx).Warn("get rerank model failed, skip rerank", log.Error(err))
+		return keep(), nil
+	}
+	if model == nil {
+		if s.rerankStrict {
+			return nil, fmt.Errorf("%w: no rerank model registered", ErrModelNotFound)
+		}
+		s.logger.WithContext(ctx).Debug("no rerank model configured, skip rerank")
+		return keep(), nil
+	}
+	return s.rerankWith(ctx, model, query, chunks, n)
+}
+
+// rerankWith reranks chunks with model and keeps the first n, in retrieval order when the rerank
+// call fails and rerank_strict is not set.
+func (s *CTRAG) rerankWith(ctx context.Context, model *domain.Model, query string, chunks []*domain.NodeContentChunk, n int) ([]*domain.NodeContentChunk, error) {
+	start := time.Now()
+	reranked, err := rerankChunks(ctx, model, query, chunks, n)
+	s.observe("rerank", start, err)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if s.rerankStrict {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		s.logger.WithContext(ctx).Warn("rerank chunks failed, keep retrieval order", log.String("model", model.Model), log.Error(err))
+		return chunks[:min(n, len(chunks))], nil
+	}
+	sortChunksByScore(reranked, s.determinism.on(ctx))
+	return reranked[:min(n, len(reranked))], nil
+}
+
+// rerankModel returns the rerank model registered in raglite under name, or the first one if name is empty.
+// It returns nil if no rerank model is registered, and ErrModelNotFound if a named one is missing.
+// Callers hold a limiter slot.
+func (s *CTRAG) rerankModel(ctx context.Context, name string) (*domain.Model, error) {
+	models, err := s.listModels(ctx, domain.ModelTypeRerank)
+	if err != nil {
+		return nil, err
+	}
+	for _, model := range models {
+		if name != "" && model.Name != name && model.ID != name {
+			continue
+		}
+		return toModel(model), nil
+	}
+	if name != "" {
+		return nil, fmt.Errorf("%w: rerank model %s", ErrModelNotFound, name)
+	}
+	return nil, nil
+}
+
+// listModels returns the models of modelType registered in raglite. Callers hold a limiter slot.
+func (s *CTRAG) listModels(ctx context.Context, modelType domain.ModelType) ([]raglite.AIModel, error) {
+	start := time.Now()
+	res, err := s.client.Models.List(ctx, &raglite.ListModelsRequest{})
+	s.observe("list_models", start, err)
+	if err != nil {
+		return nil, translateError("list models", err, ErrUnavailable)
+	}
+	var models []raglite.AIModel
+	for _, model := range res.Models {
+		if domain.ModelType(model.ModelType) == modelType {
+			models = append(models, model)
+		}
+	}
+	return models, nil
+}
+
+// checkEmbeddingModel validates the embedding model a query asks for. raglite retrieves with
+// its embedding model and takes none per request, so only the model it must be using, the
+// single registered one, is accepted. Others fail with ErrNotImplemented rather than be ignored.
+func (s *CTRAG) checkEmbeddingModel(ctx context.Context, name string) error {
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	models, err := s.listModels(ctx, domain.ModelTypeEmbedding)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(models, func(model raglite.AIModel) bool { return model.Name == name || model.ID == name }) {
+		return fmt.Errorf("%w: embedding model %s", ErrModelNotFound, name)
+	}
+	if len(models) > 1 {
+		return fmt.Errorf("%w: raglite cannot pick the embedding model %s per query", ErrNotImplemented, name)
+	}
+	return nil
+}
+
+func (s *CTRAG) retrieve(ctx context.Context, data *raglite.RetrieveRequest) (string, []*domain.NodeContentChunk, error) {
+	start := time.Now()
+	res, err := s.client.Search.Retrieve(ctx, data)
+	s.observe("retrieve", start, err)
+	if err != nil {
+		return "", nil, translateError("retrieve", err, ErrDatasetNotFound)
+	}
 	nodeChunks := make([]*domain.NodeContentChunk, len(res.Results))
 	for i, chunk := range res.Results {
+		metadata := chunk.Metadata
+		if metadata == nil {
+			metadata = map[string]any{}
+		}
 		nodeChunks[i] = &domain.NodeContentChunk{
-			ID:      chunk.ChunkID,
-			Content: chunk.Content,
-			DocID:   chunk.DocumentID,
+			ID:       chunk.ChunkID,
+			Content:  chunk.Content,
+			DocID:    chunk.DocumentID,
+			Score:    chunk.Score,
+			Metadata: metadata,
 		}
 	}
 	return res.Query, nodeChunks, nil
 }
 
-func (s *CTRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (string, error) {
+// retrieveHybrid fuses the vector ranking of the retrieved chunks with their keyword ranking,
+// alpha weighs the vector ranking against the keyword ranking. Keyword scores are not
+// similarities, so the threshold only applies to the vector ranking.
+func (s *CTRAG) retrieveHybrid(ctx context.Context, data *raglite.RetrieveRequest, opts retrieveOptions) (string, []*domain.NodeContentChunk, error) {
+	query, chunks, err := s.retrieve(ctx, data)
+	if err != nil {
+		return "", nil, err
+	}
+	sortChunksByScore(chunks, s.determinism.on(ctx))
+	// ranked before filtering, the threshold drops chunks of the vector ranking in place
+	keywordChunks := rankByKeywords(chunks, query)
+	vectorChunks := opts.counter.filterThreshold(chunks, opts.similarityThreshold)
+	return query, fuseWeightedRRF(hybridWeights(opts.hybridAlpha), vectorChunks, keywordChunks), nil
+}
+
+// retrieveRequests returns the raglite requests a query sends, one per dataset.
+func retrieveRequests(datasetIDs []string, data *raglite.RetrieveRequest) []raglite.RetrieveRequest {
+	reqs := make([]raglite.RetrieveRequest, len(datasetIDs))
+	for i, datasetID := range datasetIDs {
+		reqs[i] = *data
+		reqs[i].DatasetID = datasetID
+	}
+	return reqs
+}
+
+// BatchUpsertRecords runs UpsertRecords for the documents on a pool of ct_rag.batch_upsert_workers.
+func (s *CTRAG) BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) (_ []UpsertResult, err error) {
+	ctx, span := s.startSpan(ctx, "BatchUpsertRecords", attribute.Int("rag.document_count", len(reqs)))
+	defer func() { endSpan(span, err) }()
+	return batchUpsert(ctx, reqs, s.batchUpsertWorkers, s.UpsertRecords)
+}
+
+func (s *CTRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (_ *UpsertResult, err error) {
+	ctx, span := s.startSpan(ctx, "UpsertRecords", datasetAttr(req.DatasetID), attribute.String("rag.document_id", req.DocID), attribute.Int("rag.content_length", len(req.Content)))
+	defer func() { endSpan(span, err) }()
+	var isHTML bool
+	switch req.ContentType {
+	case "", ContentTypeAuto:
+		isHTML = utils.IsLikelyHTML(req.Content)
+	case ContentTypeHTML:
+		isHTML = true
+	case ContentTypeMarkdown:
+		isHTML = false
+	default:
+		return nil, fmt.Errorf("%w: unsupported content type: %s", ErrInvalidRequest, req.ContentType)
+	}
+	filename, err := uploadFilename(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateCustomMetadata(req.Metadata); err != nil {
+		return nil, err
+	}
+	// normalized apart from req, the caller may reuse it
+	groupIDs, err := normalizeGroupIDs(req.GroupIDs)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := resolveChunking(req, s.chunking)
+	if err != nil {
+		return nil, err
+	}
+	if s.maxContentSize > 0 && chunks.size > s.maxContentSize {
+		return nil, fmt.Errorf("%w: chunk size %d exceeds the document size limit of %d", ErrInvalidRequest, chunks.size, s.maxContentSize)
+	}
 	markdown := req.Content
+	rawHTML := false
 	// if the content is html, convert it to markdown first
-	if utils.IsLikelyHTML(req.Content) {
-		var err error
+	if isHTML {
+		start := time.Now()
 		markdown, err = s.mdConv.ConvertString(req.Content)
+		s.observe("convert_html", start, err)
 		if err != nil {
-			return "", fmt.Errorf("convert html to markdown failed: %w", err)
+			s.logger.WithContext(ctx).Error("convert html to markdown failed", log.String("doc_id", req.DocID), log.String("id", req.ID),
+				log.String("content", truncateRunes(req.Content, conversionLogSnippet)), log.Error(err))
+			if !s.rawHTMLFallback {
+				return nil, fmt.Errorf("convert html to markdown failed: %w", err)
+			}
+			markdown, rawHTML = req.Content, true
+			filename = strings.TrimSuffix(filename, path.Ext(filename)) + ".html"
 		}
 	}
 	data := &raglite.UploadDocumentRequest{
@@ -109,44 +867,400 @@ func (s *CTRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (s
 		DocumentID: req.DocID,
 		Title:      req.Title,
 		File:       strings.NewReader(markdown),
-		Filename:   fmt.Sprintf("%s.md", req.ID),
-		Metadata:   make(map[string]interface{}),
+		Filename:   filename,
+		Metadata:   maps.Clone(req.Metadata),
 	}
-	if req.GroupIDs != nil {
-		data.Metadata["group_ids"] = req.GroupIDs
+	if data.Metadata == nil {
+		data.Metadata = make(map[string]interface{})
+	}
+	if groupIDs != nil {
+		data.Metadata["group_ids"] = groupIDs
 	}
 	if req.Tags != nil {
 		data.Tags = req.Tags
 	}
+	if req.ParentDocID != "" {
+		data.Metadata["parent_doc_id"] = req.ParentDocID
+		data.Metadata["anchor"] = req.Anchor
+	}
+	if req.Title != "" {
+		data.Metadata["title"] = req.Title
+	}
+	if req.URL != "" {
+		data.Metadata["url"] = req.URL
+	}
+	if chunks.size > 0 {
+		data.Metadata["chunking"] = chunks.String()
+	}
+	if rawHTML {
+		data.Metadata["raw_html"] = true
+	}
+	contentHash := uploadHash(markdown, data)
+	hashed := *data
+	hashed.Metadata = maps.Clone(data.Metadata)
+	data.Metadata["content_hash"] = contentHash
+	// derived from the markdown, so left out of the hash
+	if lang := detectLanguage(markdown); lang != "" {
+		data.Metadata["lang"] = lang
+	}
+	updatedAt := req.UpdatedAt
+	if updatedAt.IsZero() {
+		updatedAt = s.determinism.now(ctx)
+	}
+	data.Metadata["updated_at"] = updatedAt.UTC().Format(time.RFC3339)
+	if s.maxContentSize > 0 && len(markdown) > s.maxContentSize && !req.SplitOversized && chunks.size == 0 {
+		return nil, fmt.Errorf("%w: %d bytes of markdown exceed the limit of %d", ErrDocumentTooLarge, len(markdown), s.maxContentSize)
+	}
+
+	var existing *Document
+	if req.DocID != "" && !req.New && (!req.Force || s.maxContentSize > 0) {
+		if docs, err := s.ListDocuments(ctx, req.DatasetID, []string{req.DocID}); err == nil && len(docs) > 0 {
+			existing = &docs[0]
+		}
+	}
+	reusable := reusableUpload(existing, req.Force)
+	if reusable && existing.MetaData.ContentHash == contentHash {
+		s.logger.WithContext(ctx).Debug("document unchanged, skip upload", log.String("doc_id", req.DocID))
+		return &UpsertResult{DocID: req.DocID, Skipped: true}, nil
+	}
+	if reusable && permissionsOnlyChange(markdown, &hashed, existing) {
+		// empty tags clear them, nil would keep the old ones
+		tags := req.Tags
+		if tags == nil {
+			tags = []string{}
+		}
+		metadata := map[string]interface{}{"content_hash": contentHash}
+		if groupIDs != nil {
+			metadata["group_ids"] = groupIDs
+		}
+		err := s.patchDocument(ctx, &raglite.UpdateDocumentRequest{
+			DatasetID:  req.DatasetID,
+			DocumentID: req.DocID,
+			Metadata:   metadata,
+			Tags:       tags,
+		})
+		if err == nil {
+			s.logger.WithContext(ctx).Debug("only group IDs or tags changed, patch instead of upload", log.String("doc_id", req.DocID))
+			return &UpsertResult{DocID: req.DocID, Patched: true}, nil
+		}
+		s.logger.WithContext(ctx).Warn("patch document metadata failed, upload instead", log.String("doc_id", req.DocID), log.Error(err))
+	}
+
+	pieces := []string{markdown}
+	switch {
+	case chunks.size > 0:
+		pieces = chunkMarkdown(markdown, chunks)
+	case s.maxContentSize > 0 && len(markdown) > s.maxContentSize:
+		pieces = splitMarkdown(markdown, s.maxContentSize)
+	}
+	parts := len(pieces)
+	var docID string
+	var skippedParts int
+	if parts > 1 {
+		docID, skippedParts, err = s.upsertParts(ctx, req, data, pieces)
+	} else {
+		docID, err = s.upload(ctx, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a document split before may need fewer parts now
+	if existing != nil && existing.MetaData.Parts > parts {
+		stale := make([]string, 0, existing.MetaData.Parts-parts)
+		for i := parts; i < existing.MetaData.Parts; i++ {
+			stale = append(stale, PartDocID(docID, i))
+		}
+		if err := s.DeleteRecords(ctx, req.DatasetID, stale); err != nil {
+			s.logger.WithContext(ctx).Warn("delete stale document parts failed", log.String("doc_id", docID), log.Error(err))
+		}
+	}
+	return &UpsertResult{DocID: docID, RawHTML: rawHTML, SkippedParts: skippedParts}, nil
+}
+
+// permissionsOnlyChange reports whether the upload hashed as data differs from existing only
+// in group IDs and tags, which an update can change without parsing the document again.
+// Split documents and group IDs to be removed need the upload.
+func permissionsOnlyChange(markdown string, data *raglite.UploadDocumentRequest, existing *Document) bool {
+	if existing.MetaData.Parts > 0 || existing.MetaData.ContentHash == "" {
+		return false
+	}
+	if _, ok := data.Metadata["group_ids"]; !ok && existing.MetaData.GroupIDs != nil {
+		return false
+	}
+	previous := *data
+	previous.Tags = existing.Tags
+	previous.Metadata = maps.Clone(data.Metadata)
+	if existing.MetaData.GroupIDs != nil {
+		previous.Metadata["group_ids"] = existing.MetaData.GroupIDs
+	} else {
+		delete(previous.Metadata, "group_ids")
+	}
+	return uploadHash(markdown, &previous) == existing.MetaData.ContentHash
+}
+
+// uploadHash fingerprints everything an upload changes except its timestamp,
+// so permission or title changes are uploaded even when the markdown is the same.
+func uploadHash(markdown string, data *raglite.UploadDocumentRequest) string {
+	h := sha256.New()
+	h.Write([]byte(markdown))
+	// json sorts map keys, so the encoding is stable
+	_ = json.NewEncoder(h).Encode(struct {
+		Title    string
+		Tags     []string
+		Metadata map[string]interface{}
+	}{data.Title, data.Tags, data.Metadata})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (s *CTRAG) upload(ctx context.Context, data *raglite.UploadDocumentRequest) (string, error) {
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	start := time.Now()
 	res, err := s.client.Documents.Upload(ctx, data)
+	s.observe("upload_document", start, err)
 	if err != nil {
-		return "", fmt.Errorf("upload document text failed: %w", err)
+		return "", translateError("upload document text", err, ErrDatasetNotFound)
 	}
+	s.docCache.invalidate(data.DatasetID, res.DocumentID)
 	return res.DocumentID, nil
 }
 
-func (s *CTRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
-	if err := s.client.Documents.BatchDelete(ctx, &raglite.BatchDeleteDocumentsRequest{
+// reusableUpload reports whether an upload may skip or patch the stored document. An archived
+// document is uploaded again to restore it, one whose last parse did not succeed to parse it again.
+func reusableUpload(existing *Document, force bool) bool {
+	return existing != nil && !force && !existing.MetaData.Archived && existing.Status == DocumentStatusSucceeded
+}
+
+// upsertParts uploads the parts of a split document and returns how many were skipped.
+// Every part records the base doc ID, and the base part records the number of parts, so
+// deletes and re-uploads find the others. raglite cannot update a chunk in place, so only
+// the parts whose part hash changed are uploaded again, unless req.Force is set.
+func (s *CTRAG) upsertParts(ctx context.Context, req *UpsertRecordsRequest, data *raglite.UploadDocumentRequest, parts []string) (string, int, error) {
+	baseDocID := req.DocID
+	if baseDocID == "" {
+		baseDocID = uuid.New().String()
+	}
+	partIDs := make([]string, len(parts))
+	for i := range parts {
+		partIDs[i] = PartDocID(baseDocID, i)
+	}
+	existing := make(map[string]DocumentMetadata, len(parts))
+	if req.DocID != "" && !req.Force {
+		docs, err := s.ListDocuments(ctx, req.DatasetID, partIDs)
+		if err != nil {
+			s.logger.WithContext(ctx).Warn("list document parts failed, upload all parts", log.String("doc_id", baseDocID), log.Error(err))
+		}
+		for _, doc := range docs {
+			existing[doc.ID] = doc.MetaData
+		}
+	}
+	skipped, baseSkipped := 0, false
+	// the base part goes last, its part count must not point at parts not uploaded yet
+	for i := len(parts) - 1; i >= 0; i-- {
+		partData := *data
+		partData.DocumentID = partIDs[i]
+		partData.File = strings.NewReader(parts[i])
+		partData.Filename = partFilename(data.Filename, i)
+		partData.Metadata = maps.Clone(data.Metadata)
+		partData.Metadata["base_doc_id"] = baseDocID
+		if i == 0 {
+			partData.Metadata["parts"] = len(parts)
+		}
+		hash := partHash(parts[i], &partData)
+		if meta, ok := existing[partIDs[i]]; ok && !meta.Archived && meta.PartHash == hash {
+			skipped++
+			baseSkipped = i == 0
+			continue
+		}
+		partData.Metadata["part_hash"] = hash
+		if _, err := s.upload(ctx, &partData); err != nil {
+			return "", 0, fmt.Errorf("upload part %d of %d failed: %w", i+1, len(parts), err)
+		}
+	}
+	// the base part carries the hash of the whole document, which skips the next upsert
+	if contentHash, _ := data.Metadata["content_hash"].(string); baseSkipped && existing[baseDocID].ContentHash != contentHash {
+		err := s.patchDocument(ctx, &raglite.UpdateDocumentRequest{
+			DatasetID:  req.DatasetID,
+			DocumentID: baseDocID,
+			Metadata:   map[string]interface{}{"content_hash": contentHash},
+		})
+		if err != nil {
+			s.logger.WithContext(ctx).Warn("update content hash of base part failed", log.String("doc_id", baseDocID), log.Error(err))
+		}
+	}
+	s.logger.WithContext(ctx).Info("split document", log.String("doc_id", baseDocID), log.Int("parts", len(parts)), log.Int("skipped_parts", skipped))
+	return baseDocID, skipped, nil
+}
+
+// partHash fingerprints a part like uploadHash, leaving out the metadata every upsert changes.
+func partHash(part string, data *raglite.UploadDocumentRequest) string {
+	hashed := *data
+	hashed.Metadata = maps.Clone(data.Metadata)
+	delete(hashed.Metadata, "content_hash")
+	delete(hashed.Metadata, "updated_at")
+	delete(hashed.Metadata, "part_hash")
+	return uploadHash(part, &hashed)
+}
+
+// expandParts adds the other parts of split documents to docIDs.
+func (s *CTRAG) expandParts(ctx context.Context, datasetID string, docIDs []string) []string {
+	if len(docIDs) == 0 {
+		return docIDs
+	}
+	docs, err := s.ListDocuments(ctx, datasetID, docIDs)
+	if err != nil {
+		s.logger.WithContext(ctx).Warn("list documents for parts failed, use base documents only", log.String("dataset_id", datasetID), log.Error(err))
+		return docIDs
+	}
+	expanded := docIDs
+	for _, doc := range docs {
+		for i := 1; i < doc.MetaData.Parts; i++ {
+			expanded = append(expanded, PartDocID(doc.ID, i))
+		}
+	}
+	return expanded
+}
+
+func (s *CTRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) (err error) {
+	ctx, span := s.startSpan(ctx, "DeleteRecords", datasetAttr(datasetID), attribute.Int("rag.document_count", len(docIDs)))
+	defer func() { endSpan(span, err) }()
+	return s.deleteDocuments(ctx, datasetID, s.expandParts(ctx, datasetID, docIDs))
+}
+
+// deleteAllBatchSize is how many documents DeleteAllDocuments deletes per raglite call.
+const deleteAllBatchSize = 100
+
+// DeleteAllDocuments deletes the documents of a dataset deleteAllBatchSize at a time. raglite has
+// no truncate, so the first page is listed and deleted until a page holds the whole listed total.
+func (s *CTRAG) DeleteAllDocuments(ctx context.Context, datasetID string) (err error) {
+	ctx, span := s.startSpan(ctx, "DeleteAllDocuments", datasetAttr(datasetID))
+	defer func() { endSpan(span, err) }()
+	var previous []string
+	for {
+		docIDs, total, err := s.listDocumentIDs(ctx, datasetID, deleteAllBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(docIDs) == 0 {
+			return nil
+		}
+		// documents listed again were not deleted, stop rather than loop
+		if slices.Equal(docIDs, previous) {
+			return fmt.Errorf("delete all documents of %s made no progress", datasetID)
+		}
+		if err := s.deleteDocuments(ctx, datasetID, docIDs); err != nil {
+			return err
+		}
+		if total <= int64(len(docIDs)) {
+			return nil
+		}
+		previous = docIDs
+	}
+}
+
+// listDocumentIDs returns the IDs of the first page of pageSize documents of a dataset and the
+// document total of the dataset.
+func (s *CTRAG) listDocumentIDs(ctx context.Context, datasetID string, pageSize int) ([]string, int64, error) {
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer release()
+	start := time.Now()
+	res, err := s.client.Documents.List(ctx, &raglite.ListDocumentsRequest{
+		DatasetID: datasetID,
+		Page:      1,
+		PageSize:  pageSize,
+	})
+	s.observe("list_documents", start, err)
+	if err != nil {
+		return nil, 0, translateError("list documents", err, ErrDatasetNotFound)
+	}
+	docIDs := make([]string, len(res.Documents))
+	for i, document := range res.Documents {
+		docIDs[i] = document.ID
+	}
+	return docIDs, res.Total, nil
+}
+
+func (s *CTRAG) deleteDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	start := time.Now()
+	err = s.client.Documents.BatchDelete(ctx, &raglite.BatchDeleteDocumentsRequest{
 		DatasetID:   datasetID,
 		DocumentIDs: docIDs,
-	}); err != nil {
-		return err
+	})
+	s.observe("delete_documents", start, err)
+	s.docCache.invalidate(datasetID, docIDs...)
+	if err != nil {
+		return translateError("delete documents", err, ErrDatasetNotFound)
 	}
 	return nil
 }
 
-func (s *CTRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
-	if err := s.client.Datasets.Delete(ctx, datasetID); err != nil {
+func (s *CTRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) (err error) {
+	ctx, span := s.startSpan(ctx, "DeleteKnowledgeBase", datasetAttr(datasetID))
+	defer func() { endSpan(span, err) }()
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
 		return err
 	}
+	defer release()
+	s.datasetCache.remove(datasetID)
+	start := time.Now()
+	err = s.client.Datasets.Delete(ctx, datasetID)
+	s.observe("delete_dataset", start, err)
+	s.docCache.invalidate(datasetID)
+	// again after the delete, checks that raced with it must not cache the dataset
+	s.datasetCache.remove(datasetID)
+	s.groupFilter.forget(datasetID)
+	if err != nil {
+		return translateError("delete dataset", err, ErrDatasetNotFound)
+	}
+	return nil
+}
+
+func (s *CTRAG) SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) (err error) {
+	ctx, span := s.startSpan(ctx, "SetKnowledgeBaseOptions", datasetAttr(datasetID))
+	defer func() { endSpan(span, err) }()
+	s.groupFilter.set(datasetID, opts)
 	return nil
 }
 
-func (s *CTRAG) AddModel(ctx context.Context, model *domain.Model) (string, error) {
-	maxTokens := model.Parameters.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 8192
+const defaultModelMaxTokens = 8192
+
+// modelMaxTokens returns the max tokens configured on model, or the default when unset.
+func modelMaxTokens(model *domain.Model) (int, error) {
+	switch {
+	case model.Parameters.MaxTokens == 0:
+		return defaultModelMaxTokens, nil
+	case model.Parameters.MaxTokens < 0:
+		return 0, fmt.Errorf("%w: max tokens of model %s must be positive, got %d", ErrInvalidRequest, model.Model, model.Parameters.MaxTokens)
+	default:
+		return model.Parameters.MaxTokens, nil
 	}
+}
+
+func (s *CTRAG) AddModel(ctx context.Context, model *domain.Model) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "AddModel", attribute.String("rag.model", model.Model))
+	defer func() { endSpan(span, err) }()
+	maxTokens, err := modelMaxTokens(model)
+	if err != nil {
+		return "", err
+	}
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	start := time.Now()
 	modelConfig, err := s.client.Models.Create(ctx, &raglite.CreateModelRequest{
 		Name:      model.Model,
 		Provider:  string(model.Provider),
@@ -162,17 +1276,25 @@ func (s *CTRAG) AddModel(ctx context.Context, model *domain.Model) (string, erro
 		},
 		IsDefault: true,
 	})
+	s.observe("create_model", start, err)
 	if err != nil {
-		return "", err
+		return "", translateError("create model", err, ErrModelNotFound)
 	}
 	return modelConfig.ID, nil
 }
 
-func (s *CTRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
-	maxTokens := model.Parameters.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 8192
+func (s *CTRAG) UpsertModel(ctx context.Context, model *domain.Model) (err error) {
+	ctx, span := s.startSpan(ctx, "UpsertModel", attribute.String("rag.model", model.Model))
+	defer func() { endSpan(span, err) }()
+	maxTokens, err := modelMaxTokens(model)
+	if err != nil {
+		return err
 	}
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
 	data := raglite.UpsertModelRequest{
 		Name:      model.Model,
 		Provider:  string(model.Provider),
@@ -189,18 +1311,27 @@ func (s *CTRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
 		IsDefault: true,
 		IsActive:  model.IsActive,
 	}
-	_, err := s.client.Models.Upsert(ctx, &data)
+	start := time.Now()
+	_, err = s.client.Models.Upsert(ctx, &data)
+	s.observe("upsert_model", start, err)
 	if err != nil {
-		return err
+		return translateError("upsert model", err, ErrModelNotFound)
 	}
 	return nil
 }
 
-func (s *CTRAG) UpdateModel(ctx context.Context, model *domain.Model) error {
-	maxTokens := model.Parameters.MaxTokens
-	if maxTokens == 0 {
-		maxTokens = 8192
+func (s *CTRAG) UpdateModel(ctx context.Context, model *domain.Model) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateModel", attribute.String("rag.model", model.Model))
+	defer func() { endSpan(span, err) }()
+	maxTokens, err := modelMaxTokens(model)
+	if err != nil {
+		return err
 	}
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
 	data := raglite.UpdateModelRequest{
 		Name:      raglite.Ptr(model.Model),
 		Provider:  raglite.Ptr(string(model.Provider)),
@@ -216,74 +1347,453 @@ func (s *CTRAG) UpdateModel(ctx context.Context, model *domain.Model) error {
 		IsDefault: raglite.Ptr(true),
 		IsActive:  raglite.Ptr(model.IsActive),
 	}
-	_, err := s.client.Models.Update(ctx, model.ID, &data)
+	start := time.Now()
+	_, err = s.client.Models.Update(ctx, model.ID, &data)
+	s.observe("update_model", start, err)
 	if err != nil {
-		return err
+		return translateError("update model", err, ErrModelNotFound)
 	}
 	return nil
 }
 
-func (s *CTRAG) DeleteModel(ctx context.Context, model *domain.Model) error {
-	err := s.client.Models.Delete(ctx, model.ID)
+func (s *CTRAG) DeleteModel(ctx context.Context, model *domain.Model) (err error) {
+	ctx, span := s.startSpan(ctx, "DeleteModel", attribute.String("rag.model", model.Model))
+	defer func() { endSpan(span, err) }()
+	release, err := s.limiter.acquire(ctx)
 	if err != nil {
 		return err
 	}
+	defer release()
+	start := time.Now()
+	err = s.client.Models.Delete(ctx, model.ID)
+	s.observe("delete_model", start, err)
+	if err != nil {
+		return translateError("delete model", err, ErrModelNotFound)
+	}
 	return nil
 }
 
-func (s *CTRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
-	res, err := s.client.Models.List(ctx, &raglite.ListModelsRequest{})
+// Ping lists models as the cheapest raglite call. It skips the concurrency limiter,
+// a busy limiter does not mean raglite is unreachable.
+func (s *CTRAG) Ping(ctx context.Context) (err error) {
+	ctx, span := s.startSpan(ctx, "Ping")
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	_, err = s.client.Models.List(ctx, &raglite.ListModelsRequest{})
+	s.observe("list_models", start, err)
+	if err != nil {
+		return translateError("ping raglite", err, ErrUnavailable)
+	}
+	return nil
+}
+
+func (s *CTRAG) GetModelList(ctx context.Context) (models []*domain.Model, err error) {
+	ctx, span := s.startSpan(ctx, "GetModelList")
+	defer func() {
+		if err == nil {
+			span.SetAttributes(resultCountAttr(len(models)))
+		}
+		endSpan(span, err)
+	}()
+	release, err := s.limiter.acquire(ctx)
 	if err != nil {
 		return nil, err
 	}
-	models := make([]*domain.Model, len(res.Models))
+	defer release()
+	start := time.Now()
+	res, err := s.client.Models.List(ctx, &raglite.ListModelsRequest{})
+	s.observe("list_models", start, err)
+	if err != nil {
+		return nil, translateError("list models", err, ErrModelNotFound)
+	}
+	models = make([]*domain.Model, len(res.Models))
 	for i, model := range res.Models {
-		models[i] = &domain.Model{
-			ID:      model.ID,
-			Model:   model.Name,
-			BaseURL: model.Config.APIBase,
-			APIKey:  model.Config.APIKey,
-			Type:    domain.ModelType(model.ModelType),
-		}
+		models[i] = toModel(model)
 	}
 	return models, nil
 }
 
-func (s *CTRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error {
+func (s *CTRAG) GetModelsByType(ctx context.Context) (map[domain.ModelType][]*domain.Model, error) {
+	models, err := s.GetModelList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return groupModelsByType(models), nil
+}
+
+// GetModel filters the model list, raglite has no lookup of a single model.
+func (s *CTRAG) GetModel(ctx context.Context, id string) (_ *domain.Model, err error) {
+	ctx, span := s.startSpan(ctx, "GetModel", attribute.String("rag.model_id", id))
+	defer func() { endSpan(span, err) }()
+	models, err := s.GetModelList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, model := range models {
+		if model.ID == id {
+			return model, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrModelNotFound, id)
+}
+
+// UpdateDocumentGroupIDs always sends the group IDs, nil group IDs are sent as an explicit clear
+// that opens the document to everyone.
+func (s *CTRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateDocumentGroupIDs", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	return s.UpdateDocument(ctx, datasetID, docID, DocumentPatch{GroupIDs: &groupIds})
+}
+
+func (s *CTRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateDocumentPermissions", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	if groupIds, err = normalizeGroupIDs(groupIds); err != nil {
+		return err
+	}
+	var errs []error
+	for _, partID := range s.expandParts(ctx, datasetID, []string{docID}) {
+		err := s.updateMetadata(ctx, datasetID, partID, map[string]interface{}{
+			"group_ids":  groupIds,
+			"visibility": visibility,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", partID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *CTRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateDocumentTags", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	return s.UpdateDocumentMetadata(ctx, datasetID, docID, nil, tags)
+}
+
+// UpdateDocumentMetadata sends group IDs and tags in one raglite update, so readers never see
+// one changed without the other.
+func (s *CTRAG) UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateDocumentMetadata", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	var patch DocumentPatch
+	if groupIds != nil {
+		patch.GroupIDs = &groupIds
+	}
+	if tags != nil {
+		patch.Tags = &tags
+	}
+	return s.UpdateDocument(ctx, datasetID, docID, patch)
+}
+
+// UpdateDocument sends the patch in one raglite update per part. Every part of a split document
+// is updated, retrieval filters read the tags and group IDs of the part a chunk comes from.
+func (s *CTRAG) UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) (err error) {
+	ctx, span := s.startSpan(ctx, "UpdateDocument", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	if patch.empty() {
+		return nil
+	}
+	// validate before listing the parts
+	if _, err := documentPatchRequest(datasetID, docID, patch); err != nil {
+		return err
+	}
+	var errs []error
+	for _, partID := range s.expandParts(ctx, datasetID, []string{docID}) {
+		req, _ := documentPatchRequest(datasetID, partID, patch)
+		if err := s.patchDocument(ctx, req); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", partID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *CTRAG) ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) (err error) {
+	ctx, span := s.startSpan(ctx, "ArchiveDocuments", datasetAttr(datasetID), attribute.Int("rag.document_count", len(docIDs)))
+	defer func() { endSpan(span, err) }()
+	return s.setArchived(ctx, datasetID, docIDs, true)
+}
+
+func (s *CTRAG) RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) (err error) {
+	ctx, span := s.startSpan(ctx, "RestoreDocuments", datasetAttr(datasetID), attribute.Int("rag.document_count", len(docIDs)))
+	defer func() { endSpan(span, err) }()
+	return s.setArchived(ctx, datasetID, docIDs, false)
+}
+
+// setArchived flags every document and part, the documents that failed are reported together.
+func (s *CTRAG) setArchived(ctx context.Context, datasetID string, docIDs []string, archived bool) error {
+	var errs []error
+	for _, docID := range s.expandParts(ctx, datasetID, docIDs) {
+		if err := s.updateMetadata(ctx, datasetID, docID, map[string]interface{}{"archived": archived}); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", docID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *CTRAG) updateMetadata(ctx context.Context, datasetID string, docID string, metadata map[string]interface{}) error {
+	return s.patchDocument(ctx, &raglite.UpdateDocumentRequest{
+		DatasetID:  datasetID,
+		DocumentID: docID,
+		Metadata:   metadata,
+	})
+}
+
+// documentPatchRequest builds the raglite update of patch, metadata keys it leaves out are kept by raglite.
+func documentPatchRequest(datasetID, docID string, patch DocumentPatch) (*raglite.UpdateDocumentRequest, error) {
+	if err := validateCustomMetadata(patch.Metadata); err != nil {
+		return nil, err
+	}
 	req := &raglite.UpdateDocumentRequest{
 		DatasetID:  datasetID,
 		DocumentID: docID,
-		Metadata:   map[string]interface{}{},
+		Metadata:   make(map[string]interface{}, len(patch.Metadata)+2),
 	}
-	if groupIds != nil {
-		req.Metadata["group_ids"] = groupIds
+	for key, value := range patch.Metadata {
+		req.Metadata[key] = value
+	}
+	if patch.GroupIDs != nil {
+		groupIDs, err := normalizeGroupIDs(*patch.GroupIDs)
+		if err != nil {
+			return nil, err
+		}
+		req.Metadata["group_ids"] = groupIDs
+	}
+	if patch.Tags != nil {
+		req.Tags = *patch.Tags
+	}
+	if patch.Title != nil {
+		req.Metadata["title"] = *patch.Title
 	}
-	_, err := s.client.Documents.Update(ctx, req)
+	return req, nil
+}
+
+// patchDocument sends a raglite document update, metadata keys not in req are kept.
+func (s *CTRAG) patchDocument(ctx context.Context, req *raglite.UpdateDocumentRequest) error {
+	release, err := s.limiter.acquire(ctx)
 	if err != nil {
-		return fmt.Errorf("update document group IDs failed: %w", err)
+		return err
+	}
+	defer release()
+	start := time.Now()
+	_, err = s.client.Documents.Update(ctx, req)
+	s.observe("update_document", start, err)
+	s.docCache.invalidate(req.DatasetID, req.DocumentID)
+	if err != nil {
+		return translateError("update document metadata", err, ErrDocumentNotFound)
 	}
 	return nil
 }
 
-func (s *CTRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error) {
+func (s *CTRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) (docs []Document, err error) {
+	ctx, span := s.startSpan(ctx, "ListDocuments", datasetAttr(datasetID), attribute.Int("rag.document_count", len(documentIDs)))
+	defer func() {
+		if err == nil {
+			span.SetAttributes(resultCountAttr(len(docs)))
+		}
+		endSpan(span, err)
+	}()
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	start := time.Now()
 	res, err := s.client.Documents.List(ctx, &raglite.ListDocumentsRequest{
 		DocumentIDs: documentIDs,
 		DatasetID:   datasetID,
 	})
+	s.observe("list_documents", start, err)
 	if err != nil {
-		return nil, err
+		return nil, translateError("list documents", err, ErrDatasetNotFound)
 	}
+	s.datasetCache.add(datasetID, start)
 	documents := make([]Document, len(res.Documents))
 	for i, document := range res.Documents {
-		documents[i] = Document{
-			ID:          document.ID,
-			Name:        document.Filename,
-			DatasetID:   document.DatasetID,
-			Status:      document.Status,
-			ProgressMsg: document.ProgressMsg,
-			Tags:        document.Tags,
-			MetaData:    raglite.Decode[DocumentMetadata](document.Metadata),
-		}
+		documents[i] = s.toDocument(ctx, document)
 	}
 	return documents, nil
 }
+
+// CountDocuments asks for a single document and reads the total from the list response.
+func (s *CTRAG) CountDocuments(ctx context.Context, datasetID string) (_ int, err error) {
+	ctx, span := s.startSpan(ctx, "CountDocuments", datasetAttr(datasetID))
+	defer func() { endSpan(span, err) }()
+	release, err := s.limiter.acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	start := time.Now()
+	res, err := s.client.Documents.List(ctx, &raglite.ListDocumentsRequest{
+		DatasetID: datasetID,
+		Page:      1,
+		PageSize:  1,
+	})
+	s.observe("list_documents", start, err)
+	if err != nil {
+		return 0, translateError("count documents", err, ErrDatasetNotFound)
+	}
+	s.datasetCache.add(datasetID, start)
+	return int(res.Total), nil
+}
+
+// DatasetExists answers from the dataset cache, or counts the documents of the dataset.
+func (s *CTRAG) DatasetExists(ctx context.Context, datasetID string) (_ bool, err error) {
+	ctx, span := s.startSpan(ctx, "DatasetExists", datasetAttr(datasetID))
+	defer func() { endSpan(span, err) }()
+	if s.datasetCache.known(datasetID) {
+		return true, nil
+	}
+	_, err = s.CountDocuments(ctx, datasetID)
+	if errors.Is(err, ErrDatasetNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *CTRAG) GetDocument(ctx context.Context, datasetID string, docID string) (_ *Document, err error) {
+	ctx, span := s.startSpan(ctx, "GetDocument", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	documents, err := s.ListDocuments(ctx, datasetID, []string{docID})
+	if err != nil {
+		return nil, err
+	}
+	for _, document := range documents {
+		if document.ID == docID {
+			return &document, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+}
+
+// GetDocumentContent checks the document exists, raglite-go-sdk has no endpoint returning the
+// stored file of a document so its content can't be read back.
+func (s *CTRAG) GetDocumentContent(ctx context.Context, datasetID string, docID string) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "GetDocumentContent", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	if _, err := s.GetDocument(ctx, datasetID, docID); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("get document content: raglite has no document download: %w", ErrNotImplemented)
+}
+
+// GetDocumentChunks checks the document exists, raglite has no chunk listing and chunks of
+// documents upserted with chunking can't be rebuilt without reading the parts back.
+func (s *CTRAG) GetDocumentChunks(ctx context.Context, datasetID string, docID string) (_ []*domain.NodeContentChunk, err error) {
+	ctx, span := s.startSpan(ctx, "GetDocumentChunks", datasetAttr(datasetID), attribute.String("rag.document_id", docID))
+	defer func() { endSpan(span, err) }()
+	if _, err := s.GetDocument(ctx, datasetID, docID); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("get document chunks: raglite has no chunk listing or document download: %w", ErrNotImplemented)
+}
+
+// GetKeywords extracts keywords locally, raglite has no keyword endpoint.
+// Terms are split the same way as for highlighting, datasetID is not used.
+func (s *CTRAG) GetKeywords(ctx context.Context, datasetID string, text string) (_ []string, err error) {
+	ctx, span := s.startSpan(ctx, "GetKeywords", datasetAttr(datasetID), attribute.Int("rag.query_length", utf8.RuneCountInString(text)))
+	defer func() { endSpan(span, err) }()
+	return extractKeywords(text), nil
+}
+
+// modelStatusActive is the raglite status of models in use, raglite reports no active flag
+const modelStatusActive = "active"
+
+// toModel maps a raglite model back to domain.Model. The API key is not masked,
+// this is a store level call and masking is up to the API layer that displays it.
+func toModel(model raglite.AIModel) *domain.Model {
+	name := model.ModelName
+	if name == "" {
+		name = model.Name
+	}
+	params := raglite.Decode[domain.ModelParam](model.Config.ExtraParameters)
+	if model.Config.MaxTokens != nil {
+		params.MaxTokens = *model.Config.MaxTokens
+	}
+	return &domain.Model{
+		ID:         model.ID,
+		Provider:   domain.ModelProvider(model.Provider),
+		Model:      name,
+		APIKey:     model.Config.APIKey,
+		APIHeader:  model.Config.APIHeader,
+		BaseURL:    model.Config.APIBase,
+		APIVersion: model.Config.APIVersion,
+		Type:       domain.ModelType(model.ModelType),
+		IsActive:   model.Status == modelStatusActive,
+		Parameters: params,
+	}
+}
+
+// toDocument keeps documents whose metadata does not fit DocumentMetadata, with the fields that
+// did decode, and reports the mismatch in MetadataDecodeError.
+func (s *CTRAG) toDocument(ctx context.Context, document raglite.Document) Document {
+	doc := Document{
+		ID:          document.ID,
+		Name:        document.Filename,
+		DatasetID:   document.DatasetID,
+		Status:      document.Status,
+		ProgressMsg: document.ProgressMsg,
+		Tags:        document.Tags,
+		CreatedAt:   document.CreatedAt,
+		UpdatedAt:   document.UpdatedAt,
+	}
+	meta, err := decodeDocumentMetadata(document.Metadata)
+	if err != nil {
+		s.logger.WithContext(ctx).Warn("decode document metadata failed", log.String("dataset_id", document.DatasetID), log.String("doc_id", document.ID), log.Error(err))
+		doc.MetadataDecodeError = err.Error()
+	}
+	doc.MetaData = meta
+	if doc.UpdatedAt.IsZero() && meta.UpdatedAt != "" {
+		if updatedAt, err := parseTimestamp(meta.UpdatedAt); err == nil {
+			doc.UpdatedAt = updatedAt
+		}
+	}
+	// the file name carries an ID suffix, the title is what people named the document
+	if meta.Title != "" {
+		doc.Name = meta.Title
+	}
+	doc.MetaData.Custom = customMetadata(raglite.Decode[map[string]interface{}](document.Metadata))
+	return doc
+}
+
+// timestampLayouts are the timestamp formats parseTimestamp accepts, zoneless ones are UTC.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02 15:04:05"}
+
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unsupported timestamp %q", s)
+}
+
+// decodeDocumentMetadata decodes as much of raw as fits, unlike raglite.Decode it returns the mismatches.
+func decodeDocumentMetadata(raw raglite.JSON) (DocumentMetadata, error) {
+	var meta DocumentMetadata
+	if raw.Data == nil {
+		return meta, nil
+	}
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return meta, err
+	}
+	err = json.Unmarshal(encoded, &meta)
+	return meta, err
+}
+
+// translateError classifies a raglite error by its HTTP status, other errors such as
+// network failures count as the backend being unavailable. Context errors pass through.
+func translateError(op string, err error, notFound error) error {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("%s failed: %w", op, err)
+	}
+	var apiErr *raglite.APIError
+	if errors.As(err, &apiErr) {
+		return &Error{Op: op, StatusCode: apiErr.StatusCode, Kind: errorKind(apiErr.StatusCode, notFound), Err: err}
+	}
+	return &Error{Op: op, Kind: ErrUnavailable, Err: err}
+}
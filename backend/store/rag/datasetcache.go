@@ -0,0 +1,78 @@
+package rag
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultDatasetCacheTTL = 5 * time.Minute
+
+// datasetCache remembers datasets known to exist, so existence checks skip raglite.
+// A removed dataset is not re-added by a check that started before the removal.
+type datasetCache struct {
+	ttl     time.Duration
+	mu      sync.RWMutex
+	expires map[string]time.Time
+	removed map[string]time.Time
+}
+
+// newDatasetCache returns a cache keeping entries for ttl, 0 uses the default and a negative ttl disables it.
+func newDatasetCache(ttl time.Duration) *datasetCache {
+	if ttl == 0 {
+		ttl = defaultDatasetCacheTTL
+	}
+	return &datasetCache{
+		ttl:     ttl,
+		expires: make(map[string]time.Time),
+		removed: make(map[string]time.Time),
+	}
+}
+
+func (c *datasetCache) known(datasetID string) bool {
+	if c.ttl < 0 {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	expires, ok := c.expires[datasetID]
+	return ok && time.Now().Before(expires)
+}
+
+// add records datasetID as existing, checkedAt is when raglite was asked.
+func (c *datasetCache) add(datasetID string, checkedAt time.Time) {
+	if c.ttl < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if removedAt, ok := c.removed[datasetID]; ok {
+		if !checkedAt.After(removedAt) {
+			return
+		}
+		delete(c.removed, datasetID)
+	}
+	for id, expires := range c.expires {
+		if !now.Before(expires) {
+			delete(c.expires, id)
+		}
+	}
+	c.expires[datasetID] = now.Add(c.ttl)
+}
+
+func (c *datasetCache) remove(datasetID string) {
+	if c.ttl < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	delete(c.expires, datasetID)
+	for id, removedAt := range c.removed {
+		// checks outlive the request timeout at most, a ttl is plenty
+		if now.Sub(removedAt) > c.ttl {
+			delete(c.removed, id)
+		}
+	}
+	c.removed[datasetID] = now
+}
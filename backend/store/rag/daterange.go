@@ -0,0 +1,55 @@
+package rag
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+// DefaultDateField is the metadata field DateFrom and DateTo filter on when DateField is empty.
+const DefaultDateField = "published_at"
+
+func validateDateRange(req *QueryRecordsRequest) error {
+	if !req.DateFrom.IsZero() && !req.DateTo.IsZero() && req.DateTo.Before(req.DateFrom) {
+		return fmt.Errorf("%w: date range ends before it starts", ErrInvalidRequest)
+	}
+	return nil
+}
+
+func (req *QueryRecordsRequest) hasDateRange() bool {
+	return !req.DateFrom.IsZero() || !req.DateTo.IsZero()
+}
+
+// filterDateRange keeps the chunks of documents dated within [from, to], a zero bound is open.
+// raglite metadata filters only match equal values, so the range is applied after retrieval.
+// Documents without a parsable date in field are dropped.
+func filterDateRange(chunks []*domain.NodeContentChunk, docs map[string]Document, field string, from, to time.Time) []*domain.NodeContentChunk {
+	kept := make([]*domain.NodeContentChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		doc, ok := docs[chunk.DocID]
+		if !ok {
+			continue
+		}
+		date, ok := documentDate(doc, field)
+		if !ok || (!from.IsZero() && date.Before(from)) || (!to.IsZero() && date.After(to)) {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+	return kept
+}
+
+// documentDate reads field from the custom metadata of doc, updated_at is the update time.
+func documentDate(doc Document, field string) (time.Time, bool) {
+	var value any = doc.MetaData.Custom[field]
+	if field == "updated_at" {
+		value = doc.MetaData.UpdatedAt
+	}
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, false
+	}
+	date, err := parseTimestamp(s)
+	return date, err == nil
+}
@@ -0,0 +1,58 @@
+package rag
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// Determinism mode makes retrieval reproducible, mainly for integration tests.
+// It is enabled for a whole process by config.RAG.Deterministic or per call by WithDeterministic.
+//
+// Guarantees per provider:
+//   - ct: any client-side sampling uses a fixed seed and time-dependent scoring uses
+//     a frozen clock, and chunks with equal scores are ordered by doc ID then chunk ID.
+//     ANN search itself runs inside raglite and cannot be pinned from here.
+//   - disabled: always deterministic, queries return no chunks.
+//   - local: not implemented yet.
+
+const deterministicSeed = 42
+
+// deterministicEpoch is the frozen clock used by time-dependent scoring in determinism mode.
+var deterministicEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+type deterministicCtxKey struct{}
+
+// WithDeterministic enables determinism mode for all RAG calls made with ctx.
+func WithDeterministic(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deterministicCtxKey{}, true)
+}
+
+// IsDeterministic reports whether ctx carries the determinism flag.
+func IsDeterministic(ctx context.Context) bool {
+	v, _ := ctx.Value(deterministicCtxKey{}).(bool)
+	return v
+}
+
+// determinism resolves the mode for one call from the process default and ctx.
+type determinism struct {
+	enabled bool
+}
+
+func (d determinism) on(ctx context.Context) bool {
+	return d.enabled || IsDeterministic(ctx)
+}
+
+func (d determinism) now(ctx context.Context) time.Time {
+	if d.on(ctx) {
+		return deterministicEpoch
+	}
+	return time.Now()
+}
+
+func (d determinism) rand(ctx context.Context) *rand.Rand {
+	if d.on(ctx) {
+		return rand.New(rand.NewSource(deterministicSeed))
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
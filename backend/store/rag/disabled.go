@@ -0,0 +1,190 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+// DisabledRAG is used when the wiki runs without AI search.
+// Writes succeed without doing anything and queries return no chunks.
+type DisabledRAG struct {
+	logger *log.Logger
+}
+
+func NewDisabledRAG(config *config.Config, logger *log.Logger) (*DisabledRAG, error) {
+	return &DisabledRAG{
+		logger: logger.WithModule("store.vector.disabled"),
+	}, nil
+}
+
+// IsDisabled reports whether the given service is the disabled provider, also behind wrappers,
+// so callers can hide AI features instead of failing.
+func IsDisabled(s RAGService) bool {
+	return s.Capabilities().Disabled
+}
+
+func (s *DisabledRAG) Capabilities() Capabilities {
+	return Capabilities{Disabled: true}
+}
+
+func (s *DisabledRAG) CreateKnowledgeBase(ctx context.Context, name string, provider string) (string, error) {
+	return uuid.New().String(), nil
+}
+
+func (s *DisabledRAG) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	return nil, nil
+}
+
+func (s *DisabledRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	if req.DocID != "" {
+		return &UpsertResult{DocID: req.DocID}, nil
+	}
+	return &UpsertResult{DocID: uuid.New().String()}, nil
+}
+
+func (s *DisabledRAG) BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) ([]UpsertResult, error) {
+	return batchUpsert(ctx, reqs, defaultBatchUpsertWorkers, s.UpsertRecords)
+}
+
+func (s *DisabledRAG) UpsertFile(ctx context.Context, req *UpsertFileRequest) (string, error) {
+	if req.DocID != "" {
+		return req.DocID, nil
+	}
+	return uuid.New().String(), nil
+}
+
+func (s *DisabledRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	return newQueryResult(req.Query, req.Query), nil
+}
+
+func (s *DisabledRAG) QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error) {
+	multiReq, err := multiQueryRequest(datasetIDs, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryRecords(ctx, multiReq)
+}
+
+func (s *DisabledRAG) QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error) {
+	return streamQueryResult(ctx, func(ctx context.Context) (*QueryResult, error) {
+		return s.QueryRecords(ctx, req)
+	})
+}
+
+func (s *DisabledRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	return nil
+}
+
+func (s *DisabledRAG) DeleteAllDocuments(ctx context.Context, datasetID string) error {
+	return nil
+}
+
+func (s *DisabledRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
+	return nil
+}
+
+func (s *DisabledRAG) SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) error {
+	return nil
+}
+
+func (s *DisabledRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error {
+	return nil
+}
+
+func (s *DisabledRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error {
+	return nil
+}
+
+func (s *DisabledRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error {
+	return nil
+}
+
+func (s *DisabledRAG) UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) error {
+	return nil
+}
+
+func (s *DisabledRAG) UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) error {
+	return nil
+}
+
+func (s *DisabledRAG) ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	return nil
+}
+
+func (s *DisabledRAG) RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	return nil
+}
+
+func (s *DisabledRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error) {
+	return []Document{}, nil
+}
+
+func (s *DisabledRAG) CountDocuments(ctx context.Context, datasetID string) (int, error) {
+	return 0, nil
+}
+
+func (s *DisabledRAG) CountDocumentsByGroup(ctx context.Context, datasetID string) (map[int]int, error) {
+	return map[int]int{}, nil
+}
+
+func (s *DisabledRAG) BrowseRecords(ctx context.Context, req *BrowseRecordsRequest) ([]BrowseEntry, error) {
+	return []BrowseEntry{}, nil
+}
+
+func (s *DisabledRAG) DatasetExists(ctx context.Context, datasetID string) (bool, error) {
+	return true, nil
+}
+
+func (s *DisabledRAG) GetDocument(ctx context.Context, datasetID string, docID string) (*Document, error) {
+	return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+}
+
+func (s *DisabledRAG) GetDocumentContent(ctx context.Context, datasetID string, docID string) (string, error) {
+	return "", fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+}
+
+func (s *DisabledRAG) GetDocumentChunks(ctx context.Context, datasetID string, docID string) ([]*domain.NodeContentChunk, error) {
+	return nil, fmt.Errorf("%w: %s", ErrDocumentNotFound, docID)
+}
+
+func (s *DisabledRAG) GetKeywords(ctx context.Context, datasetID string, text string) ([]string, error) {
+	return extractKeywords(text), nil
+}
+
+func (s *DisabledRAG) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *DisabledRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
+	return []*domain.Model{}, nil
+}
+
+func (s *DisabledRAG) GetModelsByType(ctx context.Context) (map[domain.ModelType][]*domain.Model, error) {
+	return map[domain.ModelType][]*domain.Model{}, nil
+}
+
+func (s *DisabledRAG) GetModel(ctx context.Context, id string) (*domain.Model, error) {
+	return nil, fmt.Errorf("%w: %s", ErrModelNotFound, id)
+}
+
+func (s *DisabledRAG) AddModel(ctx context.Context, model *domain.Model) (string, error) {
+	return model.ID, nil
+}
+
+func (s *DisabledRAG) UpdateModel(ctx context.Context, model *domain.Model) error {
+	return nil
+}
+
+func (s *DisabledRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
+	return nil
+}
+
+func (s *DisabledRAG) DeleteModel(ctx context.Context, model *domain.Model) error {
+	return nil
+}
@@ -0,0 +1,126 @@
+package rag
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+// diversityOverfetch is how many candidates per requested chunk are retrieved for MMR selection.
+const diversityOverfetch = 3
+
+func validateMMRLambda(lambda float64) error {
+	if math.IsNaN(lambda) || lambda < 0 || lambda > 1 {
+		return fmt.Errorf("%w: mmr lambda must be between 0 and 1, got %v", ErrInvalidRequest, lambda)
+	}
+	return nil
+}
+
+// selectMMR picks n chunks by maximal marginal relevance: each pick maximizes
+// lambda*score - (1-lambda)*similarity to the chunks already picked.
+// Chunks have no embeddings here, so similarity is the overlap of character bigrams,
+// which works for both space separated and CJK text.
+func selectMMR(chunks []*domain.NodeContentChunk, n int, lambda float64) []*domain.NodeContentChunk {
+	if n >= len(chunks) {
+		n = len(chunks)
+	}
+	words := make([]map[string]struct{}, len(chunks))
+	for i, chunk := range chunks {
+		words[i] = bigrams(chunk.Content)
+	}
+	picked := make([]int, 0, n)
+	used := make([]bool, len(chunks))
+	// maxSim[i] is the highest similarity of candidate i to any picked chunk
+	maxSim := make([]float64, len(chunks))
+	for len(picked) < n {
+		best, bestValue := -1, math.Inf(-1)
+		for i, chunk := range chunks {
+			if used[i] {
+				continue
+			}
+			value := lambda*chunk.Score - (1-lambda)*maxSim[i]
+			if value > bestValue {
+				best, bestValue = i, value
+			}
+		}
+		used[best] = true
+		picked = append(picked, best)
+		for i := range chunks {
+			if !used[i] {
+				maxSim[i] = max(maxSim[i], jaccard(words[i], words[best]))
+			}
+		}
+	}
+	selected := make([]*domain.NodeContentChunk, len(picked))
+	for i, idx := range picked {
+		selected[i] = chunks[idx]
+	}
+	return selected
+}
+
+// dedupSimilarity is the bigram overlap above which two chunks count as duplicates.
+const dedupSimilarity = 0.9
+
+// dedupChunks drops chunks more than dedupSimilarity similar to a chunk ranked above them,
+// chunks must be sorted by score so each duplicate cluster keeps its best chunk.
+func dedupChunks(chunks []*domain.NodeContentChunk) []*domain.NodeContentChunk {
+	kept := make([]*domain.NodeContentChunk, 0, len(chunks))
+	keptWords := make([]map[string]struct{}, 0, len(chunks))
+	for _, chunk := range chunks {
+		words := bigrams(chunk.Content)
+		if slices.ContainsFunc(keptWords, func(other map[string]struct{}) bool {
+			return jaccard(words, other) > dedupSimilarity
+		}) {
+			continue
+		}
+		kept = append(kept, chunk)
+		keptWords = append(keptWords, words)
+	}
+	return kept
+}
+
+// capChunksPerDoc keeps at most limit chunks of each document, limit 0 keeps all.
+func capChunksPerDoc(chunks []*domain.NodeContentChunk, limit int) []*domain.NodeContentChunk {
+	if limit <= 0 {
+		return chunks
+	}
+	counts := make(map[string]int)
+	kept := make([]*domain.NodeContentChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if counts[chunk.DocID] < limit {
+			counts[chunk.DocID]++
+			kept = append(kept, chunk)
+		}
+	}
+	return kept
+}
+
+func bigrams(content string) map[string]struct{} {
+	set := make(map[string]struct{})
+	for _, field := range strings.Fields(strings.ToLower(content)) {
+		runes := []rune(field)
+		if len(runes) == 1 {
+			set[field] = struct{}{}
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			set[string(runes[i:i+2])] = struct{}{}
+		}
+	}
+	return set
+}
+
+func jaccard(a, b map[string]struct{}) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 0
+	}
+	shared := 0
+	for word := range a {
+		if _, ok := b[word]; ok {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(a)+len(b)-shared)
+}
@@ -0,0 +1,86 @@
+package rag
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	docCacheTTL        = 5 * time.Minute
+	docCacheMaxEntries = 10000
+)
+
+type docCacheEntry struct {
+	doc     Document
+	expires time.Time
+}
+
+// docCache keeps documents looked up for retrieval results, so repeated queries
+// hitting the same documents do not list them again. Writes through CTRAG invalidate it.
+type docCache struct {
+	mu      sync.Mutex
+	entries map[string]docCacheEntry
+}
+
+func newDocCache() *docCache {
+	return &docCache{entries: make(map[string]docCacheEntry)}
+}
+
+func docCacheKey(datasetID, docID string) string {
+	return datasetID + "/" + docID
+}
+
+// get returns the cached documents of docIDs and the IDs that need a lookup.
+func (c *docCache) get(datasetID string, docIDs []string) (map[string]Document, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	found := make(map[string]Document, len(docIDs))
+	var missing []string
+	for _, docID := range docIDs {
+		entry, ok := c.entries[docCacheKey(datasetID, docID)]
+		if ok && now.Before(entry.expires) {
+			found[docID] = entry.doc
+		} else {
+			missing = append(missing, docID)
+		}
+	}
+	return found, missing
+}
+
+func (c *docCache) put(datasetID string, docs []Document) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if len(c.entries)+len(docs) > docCacheMaxEntries {
+		for key, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries)+len(docs) > docCacheMaxEntries {
+			c.entries = make(map[string]docCacheEntry)
+		}
+	}
+	for _, doc := range docs {
+		c.entries[docCacheKey(datasetID, doc.ID)] = docCacheEntry{doc: doc, expires: now.Add(docCacheTTL)}
+	}
+}
+
+// invalidate drops docIDs of a dataset, no docIDs drops the whole dataset.
+func (c *docCache) invalidate(datasetID string, docIDs ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(docIDs) == 0 {
+		prefix := docCacheKey(datasetID, "")
+		for key := range c.entries {
+			if len(key) > len(prefix) && key[:len(prefix)] == prefix {
+				delete(c.entries, key)
+			}
+		}
+		return
+	}
+	for _, docID := range docIDs {
+		delete(c.entries, docCacheKey(datasetID, docID))
+	}
+}
@@ -0,0 +1,57 @@
+package rag
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+var (
+	ErrDocumentNotFound = errors.New("document not found")
+	ErrDatasetNotFound  = errors.New("dataset not found")
+	ErrModelNotFound    = errors.New("model not found")
+	ErrInvalidRequest   = errors.New("invalid request")
+	ErrUnauthorized     = errors.New("unauthorized")
+	ErrRateLimited      = errors.New("rate limited")
+	ErrUnavailable      = errors.New("rag backend unavailable")
+	ErrNotImplemented   = errors.New("not implemented by rag provider")
+	// ErrDocumentTooLarge is returned when content exceeds the provider limit and splitting was not requested
+	ErrDocumentTooLarge = errors.New("document too large")
+)
+
+// ErrGroupFilterRequired is returned for queries without GroupIDs on a dataset that enforces the group filter.
+// It is an ErrInvalidRequest, so fallbacks are never asked instead.
+var ErrGroupFilterRequired = fmt.Errorf("%w: group filter required", ErrInvalidRequest)
+
+// Error is a provider failure classified into one of the sentinel errors above,
+// so both errors.Is(err, ErrRateLimited) and errors.As(err, &*Error) work.
+type Error struct {
+	Op         string
+	StatusCode int
+	Kind       error
+	Err        error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s failed: %s: %v", e.Op, e.Kind, e.Err)
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// errorKind maps a backend HTTP status code to a sentinel error, notFound is the kind used for 404.
+func errorKind(statusCode int, notFound error) error {
+	switch {
+	case statusCode == http.StatusNotFound:
+		return notFound
+	case statusCode == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return ErrUnauthorized
+	case statusCode >= 400 && statusCode < 500:
+		return ErrInvalidRequest
+	default:
+		return ErrUnavailable
+	}
+}
@@ -0,0 +1,39 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	raglite "github.com/chaitin/raglite-go-sdk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateError(t *testing.T) {
+	for status, kind := range map[int]error{
+		http.StatusNotFound:            ErrDatasetNotFound,
+		http.StatusTooManyRequests:     ErrRateLimited,
+		http.StatusBadRequest:          ErrInvalidRequest,
+		http.StatusUnprocessableEntity: ErrInvalidRequest,
+		http.StatusForbidden:           ErrUnauthorized,
+		http.StatusBadGateway:          ErrUnavailable,
+	} {
+		err := translateError("retrieve", fmt.Errorf("wrapped: %w", &raglite.APIError{StatusCode: status}), ErrDatasetNotFound)
+		assert.ErrorIs(t, err, kind, status)
+
+		var ragErr *Error
+		require.ErrorAs(t, err, &ragErr)
+		assert.Equal(t, status, ragErr.StatusCode)
+		var apiErr *raglite.APIError
+		assert.ErrorAs(t, err, &apiErr)
+	}
+
+	assert.ErrorIs(t, translateError("retrieve", errors.New("connection refused"), ErrDatasetNotFound), ErrUnavailable)
+
+	err := translateError("retrieve", context.DeadlineExceeded, ErrDatasetNotFound)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.False(t, errors.Is(err, ErrUnavailable))
+}
@@ -0,0 +1,374 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+const fallbackQueueSize = 1024
+
+var _ RAGService = (*FallbackRAG)(nil)
+
+type namedRAG struct {
+	name    string
+	service RAGService
+}
+
+// FallbackRAG serves reads from the first healthy provider in order.
+// Writes go to the primary, and are queued for the secondaries once the primary accepts them.
+// Secondaries are expected to address datasets and documents by the same IDs as the primary.
+// Datasets and models are managed on the primary only, they are set up on each provider by operators.
+type FallbackRAG struct {
+	primary   RAGService
+	providers []namedRAG
+	timeout   time.Duration
+	queue     chan func()
+	logger    *log.Logger
+}
+
+func NewFallbackRAG(providers []namedRAG, timeout time.Duration, logger *log.Logger) *FallbackRAG {
+	s := &FallbackRAG{
+		primary:   providers[0].service,
+		providers: providers,
+		timeout:   timeout,
+		queue:     make(chan func(), fallbackQueueSize),
+		logger:    logger.WithModule("store.vector.fallback"),
+	}
+	go func() {
+		for replay := range s.queue {
+			replay()
+		}
+	}()
+	return s
+}
+
+func fallbackRead[T any](s *FallbackRAG, ctx context.Context, op string, fn func(ctx context.Context, service RAGService) (T, error)) (T, error) {
+	var zero T
+	var lastErr error
+	for i, p := range s.providers {
+		callCtx, cancel := ctx, context.CancelFunc(func() {})
+		if s.timeout > 0 {
+			callCtx, cancel = context.WithTimeout(ctx, s.timeout)
+		}
+		res, err := fn(callCtx, p.service)
+		cancel()
+		if err == nil {
+			if i > 0 {
+				s.logger.Warn("rag served by fallback provider", log.String("op", op), log.String("provider", p.name))
+			} else {
+				s.logger.Debug("rag served by primary provider", log.String("op", op), log.String("provider", p.name))
+			}
+			return res, nil
+		}
+		// caller cancellation, not-found and invalid requests are answers, not provider failures
+		if ctx.Err() != nil || errors.Is(err, ErrDocumentNotFound) || errors.Is(err, ErrDatasetNotFound) || errors.Is(err, ErrInvalidRequest) {
+			return zero, err
+		}
+		s.logger.Error("rag provider failed", log.String("op", op), log.String("provider", p.name), log.Error(err))
+		lastErr = err
+	}
+	return zero, fmt.Errorf("all rag providers failed: %w", lastErr)
+}
+
+// replay queues a write already applied on the primary for every secondary provider.
+func (s *FallbackRAG) replay(op string, fn func(ctx context.Context, service RAGService) error) {
+	for _, p := range s.providers[1:] {
+		select {
+		case s.queue <- func() {
+			if err := fn(context.Background(), p.service); err != nil {
+				s.logger.Error("replay write to fallback provider failed", log.String("op", op), log.String("provider", p.name), log.Error(err))
+			}
+		}:
+		default:
+			s.logger.Warn("fallback write queue is full, drop write", log.String("op", op), log.String("provider", p.name))
+		}
+	}
+}
+
+// Capabilities are those of every provider, any of them may serve a read.
+func (s *FallbackRAG) Capabilities() Capabilities {
+	capabilities := s.providers[0].service.Capabilities()
+	for _, p := range s.providers[1:] {
+		capabilities = capabilities.intersect(p.service.Capabilities())
+	}
+	return capabilities
+}
+
+func (s *FallbackRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	return fallbackRead(s, ctx, "query_records", func(ctx context.Context, service RAGService) (*QueryResult, error) {
+		return service.QueryRecords(ctx, req)
+	})
+}
+
+func (s *FallbackRAG) QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error) {
+	multiReq, err := multiQueryRequest(datasetIDs, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryRecords(ctx, multiReq)
+}
+
+func (s *FallbackRAG) QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error) {
+	return streamQueryResult(ctx, func(ctx context.Context) (*QueryResult, error) {
+		return s.QueryRecords(ctx, req)
+	})
+}
+
+func (s *FallbackRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error) {
+	return fallbackRead(s, ctx, "list_documents", func(ctx context.Context, service RAGService) ([]Document, error) {
+		return service.ListDocuments(ctx, datasetID, documentIDs)
+	})
+}
+
+func (s *FallbackRAG) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	return fallbackRead(s, ctx, "list_knowledge_bases", func(ctx context.Context, service RAGService) ([]KnowledgeBase, error) {
+		return service.ListKnowledgeBases(ctx)
+	})
+}
+
+func (s *FallbackRAG) CountDocuments(ctx context.Context, datasetID string) (int, error) {
+	return fallbackRead(s, ctx, "count_documents", func(ctx context.Context, service RAGService) (int, error) {
+		return service.CountDocuments(ctx, datasetID)
+	})
+}
+
+func (s *FallbackRAG) CountDocumentsByGroup(ctx context.Context, datasetID string) (map[int]int, error) {
+	return fallbackRead(s, ctx, "count_documents_by_group", func(ctx context.Context, service RAGService) (map[int]int, error) {
+		return service.CountDocumentsByGroup(ctx, datasetID)
+	})
+}
+
+func (s *FallbackRAG) BrowseRecords(ctx context.Context, req *BrowseRecordsRequest) ([]BrowseEntry, error) {
+	return fallbackRead(s, ctx, "browse_records", func(ctx context.Context, service RAGService) ([]BrowseEntry, error) {
+		return service.BrowseRecords(ctx, req)
+	})
+}
+
+func (s *FallbackRAG) DatasetExists(ctx context.Context, datasetID string) (bool, error) {
+	return fallbackRead(s, ctx, "dataset_exists", func(ctx context.Context, service RAGService) (bool, error) {
+		return service.DatasetExists(ctx, datasetID)
+	})
+}
+
+func (s *FallbackRAG) GetDocument(ctx context.Context, datasetID string, docID string) (*Document, error) {
+	return fallbackRead(s, ctx, "get_document", func(ctx context.Context, service RAGService) (*Document, error) {
+		return service.GetDocument(ctx, datasetID, docID)
+	})
+}
+
+func (s *FallbackRAG) GetDocumentContent(ctx context.Context, datasetID string, docID string) (string, error) {
+	return fallbackRead(s, ctx, "get_document_content", func(ctx context.Context, service RAGService) (string, error) {
+		return service.GetDocumentContent(ctx, datasetID, docID)
+	})
+}
+
+func (s *FallbackRAG) GetDocumentChunks(ctx context.Context, datasetID string, docID string) ([]*domain.NodeContentChunk, error) {
+	return fallbackRead(s, ctx, "get_document_chunks", func(ctx context.Context, service RAGService) ([]*domain.NodeContentChunk, error) {
+		return service.GetDocumentChunks(ctx, datasetID, docID)
+	})
+}
+
+func (s *FallbackRAG) GetKeywords(ctx context.Context, datasetID string, text string) ([]string, error) {
+	return fallbackRead(s, ctx, "get_keywords", func(ctx context.Context, service RAGService) ([]string, error) {
+		return service.GetKeywords(ctx, datasetID, text)
+	})
+}
+
+// Ping succeeds while any provider can serve reads.
+func (s *FallbackRAG) Ping(ctx context.Context) error {
+	_, err := fallbackRead(s, ctx, "ping", func(ctx context.Context, service RAGService) (struct{}, error) {
+		return struct{}{}, service.Ping(ctx)
+	})
+	return err
+}
+
+// UpsertRecords replays skipped uploads too, a secondary may still lack the document.
+func (s *FallbackRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	res, err := s.primary.UpsertRecords(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	replayReq := *req
+	replayReq.DocID = res.DocID
+	s.replay("upsert_records", func(ctx context.Context, service RAGService) error {
+		_, err := service.UpsertRecords(ctx, &replayReq)
+		return err
+	})
+	return res, nil
+}
+
+// BatchUpsertRecords upserts one by one through UpsertRecords, so each document is replayed.
+func (s *FallbackRAG) BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) ([]UpsertResult, error) {
+	return batchUpsert(ctx, reqs, defaultBatchUpsertWorkers, s.UpsertRecords)
+}
+
+// UpsertFile buffers the file to replay it.
+func (s *FallbackRAG) UpsertFile(ctx context.Context, req *UpsertFileRequest) (string, error) {
+	content, err := io.ReadAll(req.File)
+	if err != nil {
+		return "", fmt.Errorf("read file failed: %w", err)
+	}
+	primaryReq := *req
+	primaryReq.File = bytes.NewReader(content)
+	docID, err := s.primary.UpsertFile(ctx, &primaryReq)
+	if err != nil {
+		return "", err
+	}
+	s.replay("upsert_file", func(ctx context.Context, service RAGService) error {
+		replayReq := *req
+		replayReq.DocID = docID
+		replayReq.File = bytes.NewReader(content)
+		_, err := service.UpsertFile(ctx, &replayReq)
+		return err
+	})
+	return docID, nil
+}
+
+func (s *FallbackRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	if err := s.primary.DeleteRecords(ctx, datasetID, docIDs); err != nil {
+		return err
+	}
+	s.replay("delete_records", func(ctx context.Context, service RAGService) error {
+		return service.DeleteRecords(ctx, datasetID, docIDs)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) DeleteAllDocuments(ctx context.Context, datasetID string) error {
+	if err := s.primary.DeleteAllDocuments(ctx, datasetID); err != nil {
+		return err
+	}
+	s.replay("delete_all_documents", func(ctx context.Context, service RAGService) error {
+		return service.DeleteAllDocuments(ctx, datasetID)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
+	if err := s.primary.DeleteKnowledgeBase(ctx, datasetID); err != nil {
+		return err
+	}
+	s.replay("delete_knowledge_base", func(ctx context.Context, service RAGService) error {
+		return service.DeleteKnowledgeBase(ctx, datasetID)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) error {
+	if err := s.primary.SetKnowledgeBaseOptions(ctx, datasetID, opts); err != nil {
+		return err
+	}
+	s.replay("set_knowledge_base_options", func(ctx context.Context, service RAGService) error {
+		return service.SetKnowledgeBaseOptions(ctx, datasetID, opts)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error {
+	if err := s.primary.UpdateDocumentGroupIDs(ctx, datasetID, docID, groupIds); err != nil {
+		return err
+	}
+	s.replay("update_document_group_ids", func(ctx context.Context, service RAGService) error {
+		return service.UpdateDocumentGroupIDs(ctx, datasetID, docID, groupIds)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error {
+	if err := s.primary.UpdateDocumentPermissions(ctx, datasetID, docID, groupIds, visibility); err != nil {
+		return err
+	}
+	s.replay("update_document_permissions", func(ctx context.Context, service RAGService) error {
+		return service.UpdateDocumentPermissions(ctx, datasetID, docID, groupIds, visibility)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error {
+	if err := s.primary.UpdateDocumentTags(ctx, datasetID, docID, tags); err != nil {
+		return err
+	}
+	s.replay("update_document_tags", func(ctx context.Context, service RAGService) error {
+		return service.UpdateDocumentTags(ctx, datasetID, docID, tags)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) error {
+	if err := s.primary.UpdateDocumentMetadata(ctx, datasetID, docID, groupIds, tags); err != nil {
+		return err
+	}
+	s.replay("update_document_metadata", func(ctx context.Context, service RAGService) error {
+		return service.UpdateDocumentMetadata(ctx, datasetID, docID, groupIds, tags)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) error {
+	if err := s.primary.UpdateDocument(ctx, datasetID, docID, patch); err != nil {
+		return err
+	}
+	s.replay("update_document", func(ctx context.Context, service RAGService) error {
+		return service.UpdateDocument(ctx, datasetID, docID, patch)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	if err := s.primary.ArchiveDocuments(ctx, datasetID, docIDs); err != nil {
+		return err
+	}
+	s.replay("archive_documents", func(ctx context.Context, service RAGService) error {
+		return service.ArchiveDocuments(ctx, datasetID, docIDs)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	if err := s.primary.RestoreDocuments(ctx, datasetID, docIDs); err != nil {
+		return err
+	}
+	s.replay("restore_documents", func(ctx context.Context, service RAGService) error {
+		return service.RestoreDocuments(ctx, datasetID, docIDs)
+	})
+	return nil
+}
+
+func (s *FallbackRAG) CreateKnowledgeBase(ctx context.Context, name string, provider string) (string, error) {
+	return s.primary.CreateKnowledgeBase(ctx, name, provider)
+}
+
+func (s *FallbackRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
+	return s.primary.GetModelList(ctx)
+}
+
+func (s *FallbackRAG) GetModelsByType(ctx context.Context) (map[domain.ModelType][]*domain.Model, error) {
+	return s.primary.GetModelsByType(ctx)
+}
+
+func (s *FallbackRAG) GetModel(ctx context.Context, id string) (*domain.Model, error) {
+	return s.primary.GetModel(ctx, id)
+}
+
+func (s *FallbackRAG) AddModel(ctx context.Context, model *domain.Model) (string, error) {
+	return s.primary.AddModel(ctx, model)
+}
+
+func (s *FallbackRAG) UpdateModel(ctx context.Context, model *domain.Model) error {
+	return s.primary.UpdateModel(ctx, model)
+}
+
+func (s *FallbackRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
+	return s.primary.UpsertModel(ctx, model)
+}
+
+func (s *FallbackRAG) DeleteModel(ctx context.Context, model *domain.Model) error {
+	return s.primary.DeleteModel(ctx, model)
+}
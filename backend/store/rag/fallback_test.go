@@ -0,0 +1,165 @@
+package rag
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+// recordingRAG records the calls it gets and fails them with err, it serves one chunk per dataset.
+type recordingRAG struct {
+	*DisabledRAG
+	err error
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (s *recordingRAG) call(op string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, op)
+	return s.err
+}
+
+func (s *recordingRAG) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.calls...)
+}
+
+func (s *recordingRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	if err := s.call("query_records"); err != nil {
+		return nil, err
+	}
+	res := newQueryResult(req.Query, req.Query)
+	for _, id := range req.datasetIDs() {
+		res.Chunks = append(res.Chunks, &domain.NodeContentChunk{ID: id + "-1", DatasetID: id})
+	}
+	res.Total = len(res.Chunks)
+	return res, nil
+}
+
+func (s *recordingRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	if err := s.call("upsert_records"); err != nil {
+		return nil, err
+	}
+	return s.DisabledRAG.UpsertRecords(ctx, req)
+}
+
+func (s *recordingRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	return s.call("delete_records")
+}
+
+func (s *recordingRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
+	return s.call("delete_knowledge_base")
+}
+
+func (s *recordingRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error {
+	return s.call("update_document_tags")
+}
+
+func (s *recordingRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
+	if err := s.call("get_model_list"); err != nil {
+		return nil, err
+	}
+	return []*domain.Model{{ID: "m1", Model: "bge-m3", Type: domain.ModelTypeEmbedding}}, nil
+}
+
+func (s *recordingRAG) GetModel(ctx context.Context, id string) (*domain.Model, error) {
+	if err := s.call("get_model"); err != nil {
+		return nil, err
+	}
+	return &domain.Model{ID: id}, nil
+}
+
+func TestFallbackRAGQueryRecords(t *testing.T) {
+	tests := []struct {
+		name          string
+		primaryErr    error
+		secondaryErr  error
+		wantErr       error
+		wantSecondary bool
+	}{
+		{name: "primary serves"},
+		{name: "unavailable primary fails over", primaryErr: ErrUnavailable, wantSecondary: true},
+		{name: "rate limited primary fails over", primaryErr: ErrRateLimited, wantSecondary: true},
+		{name: "all providers fail", primaryErr: ErrUnavailable, secondaryErr: ErrRateLimited, wantErr: ErrRateLimited, wantSecondary: true},
+		{name: "missing dataset is an answer", primaryErr: ErrDatasetNotFound, wantErr: ErrDatasetNotFound},
+		{name: "invalid request is an answer", primaryErr: ErrInvalidRequest, wantErr: ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &recordingRAG{err: tt.primaryErr}
+			secondary := &recordingRAG{err: tt.secondaryErr}
+			service := NewFallbackRAG([]namedRAG{{name: "primary", service: primary}, {name: "secondary", service: secondary}}, time.Second, log.NewLogger(&config.Config{}))
+
+			res, err := service.QueryRecords(context.Background(), &QueryRecordsRequest{DatasetID: "ds", Query: "q"})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Len(t, res.Chunks, 1)
+			}
+			assert.Equal(t, []string{"query_records"}, primary.recorded())
+			if tt.wantSecondary {
+				assert.Equal(t, []string{"query_records"}, secondary.recorded())
+			} else {
+				assert.Empty(t, secondary.recorded())
+			}
+		})
+	}
+}
+
+func TestFallbackRAGWrites(t *testing.T) {
+	tests := []struct {
+		name string
+		call func(s RAGService) error
+		op   string
+	}{
+		{name: "upsert records", op: "upsert_records", call: func(s RAGService) error {
+			_, err := s.UpsertRecords(context.Background(), &UpsertRecordsRequest{DatasetID: "ds", DocID: "doc"})
+			return err
+		}},
+		{name: "delete records", op: "delete_records", call: func(s RAGService) error {
+			return s.DeleteRecords(context.Background(), "ds", []string{"doc"})
+		}},
+		{name: "update document tags", op: "update_document_tags", call: func(s RAGService) error {
+			return s.UpdateDocumentTags(context.Background(), "ds", "doc", []string{"go"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary, secondary := &recordingRAG{}, &recordingRAG{}
+			service := NewFallbackRAG([]namedRAG{{name: "primary", service: primary}, {name: "secondary", service: secondary}}, time.Second, log.NewLogger(&config.Config{}))
+			require.NoError(t, tt.call(service))
+			assert.Equal(t, []string{tt.op}, primary.recorded())
+			assert.Eventually(t, func() bool { return len(secondary.recorded()) == 1 }, time.Second, time.Millisecond, "the write is replayed on the secondary")
+
+			// a write the primary rejects is neither retried on nor replayed to the secondary
+			failing, idle := &recordingRAG{err: ErrUnavailable}, &recordingRAG{}
+			service = NewFallbackRAG([]namedRAG{{name: "primary", service: failing}, {name: "secondary", service: idle}}, time.Second, log.NewLogger(&config.Config{}))
+			assert.ErrorIs(t, tt.call(service), ErrUnavailable)
+			assert.Never(t, func() bool { return len(idle.recorded()) > 0 }, 20*time.Millisecond, time.Millisecond)
+		})
+	}
+}
+
+func TestFallbackRAGModelsUsePrimary(t *testing.T) {
+	primary, secondary := &recordingRAG{}, &recordingRAG{}
+	service := NewFallbackRAG([]namedRAG{{name: "primary", service: primary}, {name: "secondary", service: secondary}}, time.Second, log.NewLogger(&config.Config{}))
+	_, err := service.GetModelList(context.Background())
+	require.NoError(t, err)
+	_, err = service.GetModel(context.Background(), "m1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"get_model_list", "get_model"}, primary.recorded())
+	assert.Empty(t, secondary.recorded())
+}
@@ -0,0 +1,248 @@
+package rag
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	raglite "github.com/chaitin/raglite-go-sdk"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// fileExtensions are the binary formats UpsertFile accepts besides uploadExtensions.
+var fileExtensions = []string{".pdf", ".docx"}
+
+// fileContentTypes name the extension of files uploaded without one.
+var fileContentTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": ".docx",
+}
+
+// UpsertFileRequest uploads a file for the provider to parse, instead of text content.
+type UpsertFileRequest struct {
+	DatasetID string
+	// DocID empty creates a document
+	DocID string
+	// Filename picks the parser by its extension, it is also the document title
+	Filename string
+	File     io.Reader
+	// ContentType names the format of File when Filename has no extension
+	ContentType string
+	GroupIDs    []int
+	Tags        []string
+}
+
+// fileExtension returns the format of the file to upload, one of fileExtensions or uploadExtensions.
+func fileExtension(req *UpsertFileRequest) (string, error) {
+	if req.Filename == "" || strings.ContainsAny(req.Filename, `/\`) {
+		return "", fmt.Errorf("%w: filename must be a file name: %q", ErrInvalidRequest, req.Filename)
+	}
+	ext := strings.ToLower(path.Ext(req.Filename))
+	if ext == "" {
+		mediaType, _, _ := strings.Cut(req.ContentType, ";")
+		ext = fileContentTypes[strings.TrimSpace(mediaType)]
+	}
+	if !slices.Contains(fileExtensions, ext) && !slices.Contains(uploadExtensions, ext) {
+		return "", fmt.Errorf("%w: unsupported file format of %s", ErrInvalidRequest, req.Filename)
+	}
+	return ext, nil
+}
+
+// UpsertFile streams the file to raglite, which extracts the text itself. Parsing goes on after
+// it returns, ListDocuments reports it in Status and ProgressMsg. The file is not hashed, so
+// unlike UpsertRecords every call uploads.
+func (s *CTRAG) UpsertFile(ctx context.Context, req *UpsertFileRequest) (_ string, err error) {
+	ctx, span := s.startSpan(ctx, "UpsertFile", datasetAttr(req.DatasetID), attribute.String("rag.document_id", req.DocID), attribute.String("rag.filename", req.Filename))
+	defer func() { endSpan(span, err) }()
+	ext, err := fileExtension(req)
+	if err != nil {
+		return "", err
+	}
+	groupIDs, err := normalizeGroupIDs(req.GroupIDs)
+	if err != nil {
+		return "", err
+	}
+	filename := req.Filename
+	if path.Ext(filename) == "" {
+		filename += ext
+	}
+	data := &raglite.UploadDocumentRequest{
+		DatasetID:  req.DatasetID,
+		DocumentID: req.DocID,
+		Title:      req.Filename,
+		File:       req.File,
+		Filename:   filename,
+		Tags:       req.Tags,
+		Metadata: map[string]interface{}{
+			"title":      req.Filename,
+			"updated_at": s.determinism.now(ctx).UTC().Format(time.RFC3339),
+		},
+	}
+	if groupIDs != nil {
+		data.Metadata["group_ids"] = groupIDs
+	}
+	return s.upload(ctx, data)
+}
+
+// defaultMaxFileBytes bounds files extracted here when rag.max_document_bytes is not set.
+const defaultMaxFileBytes = 32 << 20
+
+// upsertFileAsText extracts the text of the file here and upserts it as content, for providers
+// that only take text. Files and extracted text over maxBytes fail with ErrDocumentTooLarge,
+// 0 means defaultMaxFileBytes.
+func upsertFileAsText(ctx context.Context, service RAGService, req *UpsertFileRequest, maxBytes int) (string, error) {
+	ext, err := fileExtension(req)
+	if err != nil {
+		return "", err
+	}
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxFileBytes
+	}
+	text, err := extractFileText(ctx, ext, req.File, int64(maxBytes))
+	if err != nil {
+		return "", fmt.Errorf("extract text of %s failed: %w", req.Filename, err)
+	}
+	id := req.DocID
+	if id == "" {
+		id = uuid.New().String()
+	}
+	res, err := service.UpsertRecords(ctx, &UpsertRecordsRequest{
+		ID:          id,
+		DatasetID:   req.DatasetID,
+		DocID:       req.DocID,
+		Title:       req.Filename,
+		Content:     text,
+		ContentType: ContentTypeMarkdown,
+		GroupIDs:    req.GroupIDs,
+		Tags:        req.Tags,
+		Filename:    strings.TrimSuffix(req.Filename, path.Ext(req.Filename)) + ".txt",
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.DocID, nil
+}
+
+// extractFileText returns the text of a file in one of fileExtensions or uploadExtensions.
+// The file, and the text of compressed formats, are read up to limit bytes.
+// pdf needs pdftotext of poppler-utils on the PATH.
+func extractFileText(ctx context.Context, ext string, r io.Reader, limit int64) (string, error) {
+	content, err := readLimited(r, limit)
+	if err != nil {
+		return "", err
+	}
+	switch ext {
+	case ".pdf":
+		return pdfText(ctx, content, limit)
+	case ".docx":
+		return docxText(content, limit)
+	default:
+		return string(content), nil
+	}
+}
+
+// readLimited reads all of r, more than limit bytes fail with ErrDocumentTooLarge.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	content, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > limit {
+		return nil, fmt.Errorf("%w: file exceeds %d bytes", ErrDocumentTooLarge, limit)
+	}
+	return content, nil
+}
+
+// pdfText runs pdftotext on content, stopping it once its text exceeds limit bytes.
+func pdfText(ctx context.Context, content []byte, limit int64) (string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "pdftotext", "-enc", "UTF-8", "-", "-")
+	cmd.Stdin = bytes.NewReader(content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("%w: pdftotext is not installed", ErrNotImplemented)
+		}
+		return "", fmt.Errorf("start pdftotext failed: %w", err)
+	}
+	out, readErr := readLimited(stdout, limit)
+	if readErr != nil {
+		cancel()
+	}
+	err = cmd.Wait()
+	if readErr != nil {
+		return "", readErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("pdftotext failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// docxText reads the paragraphs of word/document.xml, one per line. The xml is read up to limit
+// bytes, a small docx may unpack to far more.
+func docxText(content []byte, limit int64) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return "", fmt.Errorf("%w: not a docx file: %v", ErrInvalidRequest, err)
+	}
+	f, err := zr.Open("word/document.xml")
+	if err != nil {
+		return "", fmt.Errorf("%w: not a docx file: %v", ErrInvalidRequest, err)
+	}
+	defer f.Close()
+	document, err := readLimited(f, limit)
+	if err != nil {
+		return "", err
+	}
+	var text strings.Builder
+	inText := false
+	dec := xml.NewDecoder(bytes.NewReader(document))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return text.String(), nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("parse docx failed: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				text.WriteString("\t")
+			case "br":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				text.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				text.Write(t)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+const (
+	// maxGroupCountDocuments bounds the documents CountDocumentsByGroup lists, raglite cannot
+	// aggregate metadata so they are counted here
+	maxGroupCountDocuments = 10000
+	// UnrestrictedGroupID keys the documents without group IDs, which every group can see
+	UnrestrictedGroupID = 0
+)
+
+// CountDocumentsByGroup lists the documents and counts them client side, datasets with more
+// than 10000 documents are rejected with ErrInvalidRequest.
+func (s *CTRAG) CountDocumentsByGroup(ctx context.Context, datasetID string) (_ map[int]int, err error) {
+	ctx, span := s.startSpan(ctx, "CountDocumentsByGroup", datasetAttr(datasetID))
+	defer func() { endSpan(span, err) }()
+	total, err := s.CountDocuments(ctx, datasetID)
+	if err != nil {
+		return nil, err
+	}
+	if total > maxGroupCountDocuments {
+		return nil, fmt.Errorf("%w: dataset has %d documents, counting by group is limited to %d", ErrInvalidRequest, total, maxGroupCountDocuments)
+	}
+	docs, err := s.ListDocuments(ctx, datasetID, nil)
+	if err != nil {
+		return nil, err
+	}
+	return countByGroup(docs), nil
+}
+
+func countByGroup(docs []Document) map[int]int {
+	counts := make(map[int]int)
+	seen := make(map[string]bool, len(docs))
+	for _, doc := range docs {
+		if doc.MetaData.Archived {
+			continue
+		}
+		docID := doc.ID
+		if doc.MetaData.BaseDocID != "" {
+			docID = doc.MetaData.BaseDocID
+		}
+		if seen[docID] {
+			continue
+		}
+		seen[docID] = true
+		if doc.MetaData.GroupIDs == nil {
+			counts[UnrestrictedGroupID]++
+			continue
+		}
+		for _, groupID := range slices.Compact(slices.Sorted(slices.Values(doc.MetaData.GroupIDs))) {
+			counts[groupID]++
+		}
+	}
+	return counts
+}
@@ -0,0 +1,63 @@
+package rag
+
+import (
+	"fmt"
+	"sync"
+)
+
+// KnowledgeBaseOptions are per dataset settings of a provider.
+type KnowledgeBaseOptions struct {
+	// EnforceGroupFilter refuses queries without GroupIDs with ErrGroupFilterRequired,
+	// so restricted documents cannot surface when a caller forgets the filter
+	EnforceGroupFilter bool
+}
+
+// groupFilterPolicy tracks the datasets enforcing the group filter. Datasets from config always
+// enforce it, the others are set with SetKnowledgeBaseOptions and kept in memory only.
+type groupFilterPolicy struct {
+	mu       sync.RWMutex
+	enforced map[string]bool
+	config   map[string]bool
+}
+
+func newGroupFilterPolicy(configured []string) *groupFilterPolicy {
+	p := &groupFilterPolicy{
+		enforced: make(map[string]bool),
+		config:   make(map[string]bool, len(configured)),
+	}
+	for _, datasetID := range configured {
+		p.config[datasetID] = true
+	}
+	return p
+}
+
+func (p *groupFilterPolicy) set(datasetID string, opts KnowledgeBaseOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if opts.EnforceGroupFilter {
+		p.enforced[datasetID] = true
+	} else {
+		delete(p.enforced, datasetID)
+	}
+}
+
+func (p *groupFilterPolicy) forget(datasetID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.enforced, datasetID)
+}
+
+// check returns ErrGroupFilterRequired when req has no GroupIDs but one of its datasets enforces them.
+func (p *groupFilterPolicy) check(req *QueryRecordsRequest) error {
+	if len(req.GroupIDs) > 0 {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, datasetID := range req.datasetIDs() {
+		if p.config[datasetID] || p.enforced[datasetID] {
+			return fmt.Errorf("%w: dataset %s", ErrGroupFilterRequired, datasetID)
+		}
+	}
+	return nil
+}
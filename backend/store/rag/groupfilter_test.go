@@ -0,0 +1,38 @@
+package rag
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+func TestGroupFilterRequired(t *testing.T) {
+	cfg := &config.Config{}
+	// rejected queries never reach raglite
+	cfg.RAG.CTRAG.BaseURL = "http://raglite.invalid"
+	cfg.RAG.GroupFilterDatasets = []string{"restricted"}
+	service, err := NewCTRAG(cfg, log.NewLogger(cfg))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = service.QueryRecords(ctx, &QueryRecordsRequest{DatasetID: "restricted", Query: "salary"})
+	assert.ErrorIs(t, err, ErrGroupFilterRequired)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	// a restricted dataset among several still needs the filter
+	_, err = service.QueryRecords(ctx, &QueryRecordsRequest{DatasetIDs: []string{"public", "restricted"}, Query: "salary"})
+	assert.ErrorIs(t, err, ErrGroupFilterRequired)
+
+	require.NoError(t, service.SetKnowledgeBaseOptions(ctx, "hr", KnowledgeBaseOptions{EnforceGroupFilter: true}))
+	_, err = service.QueryRecords(ctx, &QueryRecordsRequest{DatasetID: "hr", Query: "salary"})
+	assert.ErrorIs(t, err, ErrGroupFilterRequired)
+	assert.NoError(t, service.groupFilter.check(&QueryRecordsRequest{DatasetID: "hr", GroupIDs: []int{1}}))
+
+	require.NoError(t, service.SetKnowledgeBaseOptions(ctx, "hr", KnowledgeBaseOptions{}))
+	assert.NoError(t, service.groupFilter.check(&QueryRecordsRequest{DatasetID: "hr"}))
+	assert.ErrorIs(t, service.groupFilter.check(&QueryRecordsRequest{DatasetID: "restricted"}), ErrGroupFilterRequired)
+}
@@ -0,0 +1,98 @@
+package rag
+
+import (
+	"html"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// snippetLength is the rough length of a highlighted snippet in characters.
+const snippetLength = 200
+
+var sentenceEndRe = regexp.MustCompile(`[.!?。！？；;\n]+`)
+
+// highlightSnippet picks the sentence of content sharing the most terms with query, extends it
+// to about snippetLength characters and wraps the query terms in <em> markers.
+// The rest of the snippet is html escaped.
+func highlightSnippet(content, query string) string {
+	terms := queryTerms(query)
+	sentences := splitSentences(content)
+	if len(sentences) == 0 {
+		return ""
+	}
+	best, bestScore := 0, -1
+	for i, sentence := range sentences {
+		lower := strings.ToLower(sentence)
+		score := 0
+		for _, term := range terms {
+			if strings.Contains(lower, term) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	var sb strings.Builder
+	for i := best; i < len(sentences) && utf8.RuneCountInString(sb.String()) < snippetLength; i++ {
+		sb.WriteString(sentences[i])
+	}
+	snippet := []rune(strings.TrimSpace(sb.String()))
+	if len(snippet) > snippetLength {
+		snippet = snippet[:snippetLength]
+	}
+	return markTerms(html.EscapeString(string(snippet)), terms)
+}
+
+func splitSentences(content string) []string {
+	var sentences []string
+	last := 0
+	for _, loc := range sentenceEndRe.FindAllStringIndex(content, -1) {
+		if sentence := content[last:loc[1]]; strings.TrimSpace(sentence) != "" {
+			sentences = append(sentences, sentence)
+		}
+		last = loc[1]
+	}
+	if sentence := content[last:]; strings.TrimSpace(sentence) != "" {
+		sentences = append(sentences, sentence)
+	}
+	return sentences
+}
+
+// queryTerms returns the distinct terms of a query, see splitTerms.
+func queryTerms(query string) []string {
+	seen := make(map[string]struct{})
+	var terms []string
+	for _, term := range splitTerms(query) {
+		if _, ok := seen[term]; !ok {
+			seen[term] = struct{}{}
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func markTerms(text string, terms []string) string {
+	if len(terms) == 0 {
+		return text
+	}
+	sorted := slices.Clone(terms)
+	// longer terms first, so a term is not cut by a shorter one it contains
+	sort.SliceStable(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	quoted := make([]string, len(sorted))
+	for i, term := range sorted {
+		quoted[i] = regexp.QuoteMeta(html.EscapeString(term))
+		// words only match whole words, CJK bigrams match anywhere
+		if r, _ := utf8.DecodeRuneInString(term); !unicode.Is(unicode.Han, r) {
+			quoted[i] = `\b` + quoted[i] + `\b`
+		}
+	}
+	re := regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+	marked := re.ReplaceAllString(text, "<em>$0</em>")
+	// adjacent matches, e.g. overlapping CJK bigrams, become one marked span
+	return strings.ReplaceAll(marked, "</em><em>", "")
+}
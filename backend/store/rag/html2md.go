@@ -1,7 +1,10 @@
 package rag
 
 import (
+	"fmt"
+	"net/url"
 	"path"
+	"regexp"
 	"strings"
 
 	"github.com/JohannesKaufmann/dom"
@@ -10,26 +13,149 @@ import (
 	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/commonmark"
 	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/table"
 	"golang.org/x/net/html"
+
+	"github.com/chaitin/panda-wiki/config"
 )
 
-func NewHTML2MDConverter() *converter.Converter {
-	conv := converter.NewConverter(
-		converter.WithPlugins(
-			base.NewBasePlugin(),
-			commonmark.NewCommonmarkPlugin(),
-			table.NewTablePlugin(
-				table.WithSpanCellBehavior(table.SpanBehaviorMirror),
-				table.WithNewlineBehavior(table.NewlineBehaviorPreserve),
-			),
+// html2mdOptions are the tunables of NewHTML2MDConverter.
+type html2mdOptions struct {
+	joinCJKLines    bool
+	disableEscaping bool
+	urls            urlPolicy
+	boilerplate     *boilerplatePolicy
+}
+
+// HTML2MDOption customizes a converter built by NewHTML2MDConverter.
+type HTML2MDOption func(*html2mdOptions)
+
+// WithCJKLineJoin drops line breaks between Chinese or Japanese characters in text, which
+// the converter otherwise turns into spaces in the middle of a sentence.
+func WithCJKLineJoin() HTML2MDOption {
+	return func(o *html2mdOptions) { o.joinCJKLines = true }
+}
+
+// WithEscapingDisabled keeps markdown characters in text as they are instead of escaping them.
+func WithEscapingDisabled() HTML2MDOption {
+	return func(o *html2mdOptions) { o.disableEscaping = true }
+}
+
+// WithBaseURL resolves relative and protocol-relative links and images against base.
+func WithBaseURL(base *url.URL) HTML2MDOption {
+	return func(o *html2mdOptions) { o.urls.baseURL = base }
+}
+
+// WithNodeURL rewrites internal /node/<id> links to nodeURL with "{id}" replaced by the node ID.
+// A relative nodeURL is then resolved like other links.
+func WithNodeURL(nodeURL string) HTML2MDOption {
+	return func(o *html2mdOptions) { o.urls.nodeURL = nodeURL }
+}
+
+// WithImagesStripped drops img elements.
+func WithImagesStripped() HTML2MDOption {
+	return func(o *html2mdOptions) { o.urls.stripImages = true }
+}
+
+// WithBoilerplateRemoved removes script, style and noscript elements and the elements matching
+// selectors before conversion, see DefaultBoilerplateSelectors. mainContent then keeps only the
+// main content of the page.
+func WithBoilerplateRemoved(selectors []string, mainContent bool) (HTML2MDOption, error) {
+	policy := &boilerplatePolicy{mainContent: mainContent}
+	for _, s := range selectors {
+		sel, err := parseSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		policy.selectors = append(policy.selectors, sel)
+	}
+	return func(o *html2mdOptions) { o.boilerplate = policy }, nil
+}
+
+// HTML2MDOptionsFromConfig returns the converter options set in rag.markdown.
+func HTML2MDOptionsFromConfig(cfg config.RAGMarkdownConfig) ([]HTML2MDOption, error) {
+	var opts []HTML2MDOption
+	switch strings.ToLower(cfg.Locale) {
+	// korean separates words with spaces, so its line breaks are kept
+	case "zh", "ja":
+		opts = append(opts, WithCJKLineJoin())
+	}
+	if cfg.DisableEscaping {
+		opts = append(opts, WithEscapingDisabled())
+	}
+	if cfg.BaseURL != "" {
+		base, err := url.Parse(cfg.BaseURL)
+		if err != nil || !base.IsAbs() {
+			return nil, fmt.Errorf("markdown base_url must be an absolute url: %q", cfg.BaseURL)
+		}
+		opts = append(opts, WithBaseURL(base))
+	}
+	if cfg.NodeURL != "" {
+		if !strings.Contains(cfg.NodeURL, "{id}") {
+			return nil, fmt.Errorf("markdown node_url must contain {id}: %q", cfg.NodeURL)
+		}
+		opts = append(opts, WithNodeURL(cfg.NodeURL))
+	}
+	if cfg.StripImages {
+		opts = append(opts, WithImagesStripped())
+	}
+	if !cfg.KeepBoilerplate {
+		selectors := cfg.BoilerplateSelectors
+		if len(selectors) == 0 {
+			selectors = DefaultBoilerplateSelectors
+		}
+		opt, err := WithBoilerplateRemoved(selectors, cfg.MainContent)
+		if err != nil {
+			return nil, fmt.Errorf("markdown boilerplate_selectors: %w", err)
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+func NewHTML2MDConverter(opts ...HTML2MDOption) *converter.Converter {
+	var o html2mdOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	plugins := converter.WithPlugins(
+		base.NewBasePlugin(),
+		commonmark.NewCommonmarkPlugin(),
+		table.NewTablePlugin(
+			table.WithSpanCellBehavior(table.SpanBehaviorMirror),
+			table.WithNewlineBehavior(table.NewlineBehaviorPreserve),
 		),
 	)
+	var conv *converter.Converter
+	if o.disableEscaping {
+		conv = converter.NewConverter(plugins, converter.WithEscapeMode(converter.EscapeModeDisabled))
+	} else {
+		conv = converter.NewConverter(plugins)
+	}
+	// boilerplate goes first, the other pre-renderers need not walk it
+	if o.boilerplate != nil {
+		conv.Register.PreRenderer(o.boilerplate.clean, converter.PriorityEarly-1)
+	}
+	if o.joinCJKLines {
+		conv.Register.PreRenderer(joinCJKLines, converter.PriorityEarly)
+	}
+	if o.urls.enabled() {
+		conv.Register.PreRenderer(o.urls.rewriteTree, converter.PriorityEarly)
+	}
 	// 注册自定义渲染器
 	// attachment to md link
 	conv.Register.RendererFor("span", converter.TagTypeInline, renderAttachment, converter.PriorityEarly)
 	// task list
 	conv.Register.RendererFor("ul", converter.TagTypeBlock, renderTaskList, converter.PriorityEarly)
-	// flowchart/diagram to mermaid code block
-	conv.Register.RendererFor("div", converter.TagTypeBlock, renderFlowchart, converter.PriorityEarly)
+	// mermaid, plantuml and flowchart containers to code blocks of their source, ahead of renderCodeBlock
+	conv.Register.RendererFor("div", converter.TagTypeBlock, renderDiagram, converter.PriorityEarly-1)
+	conv.Register.RendererFor("pre", converter.TagTypeBlock, renderDiagram, converter.PriorityEarly-1)
+	// formulas as their TeX source
+	conv.Register.RendererFor("span", converter.TagTypeInline, renderMath, converter.PriorityEarly)
+	conv.Register.RendererFor("math", converter.TagTypeInline, renderMath, converter.PriorityEarly)
+	conv.Register.RendererFor("div", converter.TagTypeBlock, renderMath, converter.PriorityEarly)
+	// code blocks keep their language and exact whitespace
+	conv.Register.RendererFor("pre", converter.TagTypeBlock, renderCodeBlock, converter.PriorityEarly)
+	// tables with spans and block cells, the table plugin renders what renderTable passes on
+	conv.Register.RendererFor("table", converter.TagTypeBlock, renderTable, converter.PriorityEarly)
 	return conv
 }
 
@@ -129,39 +255,157 @@ func getTextFromTaskItem(node *html.Node) string {
 	return strings.TrimSpace(textContent.String())
 }
 
-// renderFlowchart 将流程图 div 转换为 Mermaid 代码块
-func renderFlowchart(ctx converter.Context, w converter.Writer, node *html.Node) converter.RenderStatus {
-	if node.Type != html.ElementNode || node.Data != "div" {
+// diagramLanguages maps the class or data-type of diagram containers to the fence language.
+// data-type="flow" is the flowchart node of our editor, which is mermaid.
+var diagramLanguages = map[string]string{
+	"flow":     "mermaid",
+	"mermaid":  "mermaid",
+	"plantuml": "plantuml",
+}
+
+// renderDiagram 将 mermaid/plantuml/流程图容器转换为代码块，保留图表源码
+func renderDiagram(ctx converter.Context, w converter.Writer, node *html.Node) converter.RenderStatus {
+	if node.Type != html.ElementNode {
 		return converter.RenderTryNext
 	}
-
-	// 仅处理 data-type="flow" 的 div
-	dataType, ok := dom.GetAttribute(node, "data-type")
-	if !ok || dataType != "flow" {
+	lang := diagramLanguage(node)
+	if lang == "" {
 		return converter.RenderTryNext
 	}
 
-	// 提取 data-code 属性
-	code, hasCode := dom.GetAttribute(node, "data-code")
-	if !hasCode || strings.TrimSpace(code) == "" {
+	// 优先使用 data-code 属性，其次是容器内的源码文本
+	var code string
+	if dataCode, ok := dom.GetAttribute(node, "data-code"); ok {
+		// 解码 HTML 实体，处理转义的换行符
+		code = strings.ReplaceAll(html.UnescapeString(dataCode), "\\n", "\n")
+	} else if dom.FindFirstNode(node, func(n *html.Node) bool { return n.Type == html.ElementNode && n.Data == "svg" }) == nil {
+		// 已渲染为 svg 的容器没有源码
+		code = codeText(node)
+	}
+	code = strings.Trim(code, "\n")
+	if strings.TrimSpace(code) == "" {
 		return converter.RenderTryNext
 	}
 
-	// 解码 HTML 实体
-	code = html.UnescapeString(code)
-	// 处理转义的换行符
-	code = strings.ReplaceAll(code, "\\n", "\n")
+	fence := codeFence(code)
+	w.WriteString("\n\n" + fence + lang + "\n")
+	w.WriteString(code)
+	w.WriteString("\n" + fence + "\n\n")
+	return converter.RenderSuccess
+}
 
-	// 写入 Mermaid 代码块
-	if _, err := w.WriteString("\n```mermaid\n"); err != nil {
-		return converter.RenderTryNext
+func diagramLanguage(node *html.Node) string {
+	if dataType, ok := dom.GetAttribute(node, "data-type"); ok {
+		if lang, ok := diagramLanguages[dataType]; ok {
+			return lang
+		}
 	}
-	if _, err := w.WriteString(code); err != nil {
-		return converter.RenderTryNext
+	for _, class := range dom.GetClasses(node) {
+		class = strings.TrimPrefix(class, "language-")
+		if lang, ok := diagramLanguages[class]; ok && class != "flow" {
+			return lang
+		}
 	}
-	if _, err := w.WriteString("\n```\n\n"); err != nil {
-		return converter.RenderTryNext
+	return ""
+}
+
+// cjkLineBreak matches a line break with its surrounding spaces between two Han or kana
+// characters or CJK punctuation.
+var cjkLineBreak = regexp.MustCompile(`([\p{Han}\p{Hiragana}\p{Katakana}\x{3000}-\x{303F}\x{FF00}-\x{FFEF}])[ \t]*\r?\n\s*([\p{Han}\p{Hiragana}\p{Katakana}\x{3000}-\x{303F}\x{FF00}-\x{FFEF}])`)
+
+// joinCJKLines removes line breaks between CJK characters in text nodes, preformatted
+// content is left alone.
+func joinCJKLines(ctx converter.Context, doc *html.Node) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && (n.Data == "pre" || n.Data == "code" || n.Data == "textarea") {
+			return
+		}
+		if n.Type == html.TextNode {
+			n.Data = cjkLineBreak.ReplaceAllString(n.Data, "$1$2")
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
 	}
+	walk(doc)
+}
 
-	return converter.RenderSuccess
+// urlPolicy rewrites the links and images of converted html.
+type urlPolicy struct {
+	baseURL     *url.URL
+	nodeURL     string
+	stripImages bool
+}
+
+func (p urlPolicy) enabled() bool {
+	return p.baseURL != nil || p.nodeURL != "" || p.stripImages
+}
+
+// nodePathRe matches the path of an internal link to a wiki node.
+var nodePathRe = regexp.MustCompile(`^/node/([^/]+)/?$`)
+
+// rewrite returns the URL raw should point at. Absolute URLs, data URIs and fragments are kept,
+// node links take the node URL, and other relative URLs are resolved against the base URL.
+func (p urlPolicy) rewrite(raw string) string {
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+		return raw
+	}
+	ref, err := url.Parse(trimmed)
+	if err != nil || ref.Scheme != "" {
+		return raw
+	}
+	if m := nodePathRe.FindStringSubmatch(ref.Path); m != nil && p.nodeURL != "" && ref.Host == "" {
+		nodeRef, err := url.Parse(strings.ReplaceAll(p.nodeURL, "{id}", url.PathEscape(m[1])))
+		if err != nil {
+			return raw
+		}
+		nodeRef.RawQuery, nodeRef.Fragment = ref.RawQuery, ref.Fragment
+		if nodeRef.IsAbs() {
+			return nodeRef.String()
+		}
+		ref = nodeRef
+	}
+	if p.baseURL == nil {
+		return ref.String()
+	}
+	return p.baseURL.ResolveReference(ref).String()
+}
+
+// rewriteTree rewrites link and image URLs and drops the images when stripImages is set.
+func (p urlPolicy) rewriteTree(ctx converter.Context, doc *html.Node) {
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for child := n.FirstChild; child != nil; {
+			next := child.NextSibling
+			if p.stripImages && child.Type == html.ElementNode && child.Data == "img" {
+				n.RemoveChild(child)
+			} else {
+				walk(child)
+			}
+			child = next
+		}
+		if n.Type != html.ElementNode {
+			return
+		}
+		for i, attr := range n.Attr {
+			if (n.Data == "a" && attr.Key == "href") || (n.Data == "img" && attr.Key == "src") {
+				n.Attr[i].Val = p.rewrite(attr.Val)
+			}
+		}
+	}
+	walk(doc)
+}
+
+// conversionLogSnippet is how many runes of html a failed conversion logs
+const conversionLogSnippet = 200
+
+// truncateRunes cuts s to at most n runes, marking the cut with "...".
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n]) + "..."
 }
@@ -0,0 +1,111 @@
+package rag
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+type SearchMode string
+
+// raglite retrieves by vector similarity only, its retrieval_mode picks full or smart retrieval
+// rather than a search mode. Keyword and hybrid search rank the chunks of that retrieval here.
+const (
+	// SearchModeVector is semantic retrieval, it is the default
+	SearchModeVector SearchMode = "vector"
+	// SearchModeKeyword orders the retrieved chunks by the query terms they contain
+	SearchModeKeyword SearchMode = "keyword"
+	// SearchModeHybrid fuses vector and keyword rankings with reciprocal rank fusion
+	SearchModeHybrid SearchMode = "hybrid"
+)
+
+// rrfK dampens the weight of top ranks, 60 is the value from the original RRF paper.
+const rrfK = 60
+
+// defaultHybridAlpha weighs the vector and keyword legs of a hybrid search equally.
+const defaultHybridAlpha = 0.5
+
+func validateHybridAlpha(alpha float64) error {
+	if math.IsNaN(alpha) || alpha < 0 || alpha > 1 {
+		return fmt.Errorf("%w: hybrid alpha must be between 0 and 1, got %v", ErrInvalidRequest, alpha)
+	}
+	return nil
+}
+
+// hybridWeights returns the RRF weights of the vector and the keyword leg, alpha 0 means the default.
+func hybridWeights(alpha float64) []float64 {
+	if alpha == 0 {
+		alpha = defaultHybridAlpha
+	}
+	return []float64{alpha, 1 - alpha}
+}
+
+func (m SearchMode) resolve() (SearchMode, error) {
+	switch m {
+	case "":
+		return SearchModeVector, nil
+	case SearchModeVector, SearchModeKeyword, SearchModeHybrid:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported search mode: %s", ErrInvalidRequest, m)
+	}
+}
+
+// rankByKeywords returns copies of the chunks containing terms of query, scored by the share of
+// the terms they contain and ordered by that score. Ties keep the order of chunks.
+func rankByKeywords(chunks []*domain.NodeContentChunk, query string) []*domain.NodeContentChunk {
+	terms := slices.DeleteFunc(queryTerms(query), isStopTerm)
+	if len(terms) == 0 {
+		return nil
+	}
+	var ranked []*domain.NodeContentChunk
+	for _, chunk := range chunks {
+		content := strings.ToLower(chunk.Content)
+		matched := 0
+		for _, term := range terms {
+			if strings.Contains(content, term) {
+				matched++
+			}
+		}
+		if matched == 0 {
+			continue
+		}
+		keywordChunk := *chunk
+		keywordChunk.Score = float64(matched) / float64(len(terms))
+		ranked = append(ranked, &keywordChunk)
+	}
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].Score > ranked[j].Score })
+	return ranked
+}
+
+// fuseRRF merges ranked lists by reciprocal rank fusion. A chunk found by several
+// lists keeps the score of its first occurrence, ties keep the order of the lists.
+func fuseRRF(lists ...[]*domain.NodeContentChunk) []*domain.NodeContentChunk {
+	weights := make([]float64, len(lists))
+	for i := range weights {
+		weights[i] = 1
+	}
+	return fuseWeightedRRF(weights, lists...)
+}
+
+// fuseWeightedRRF is fuseRRF with the contribution of each list scaled by its weight.
+func fuseWeightedRRF(weights []float64, lists ...[]*domain.NodeContentChunk) []*domain.NodeContentChunk {
+	fused := make(map[string]float64)
+	var chunks []*domain.NodeContentChunk
+	for i, list := range lists {
+		for rank, chunk := range list {
+			if _, ok := fused[chunk.ID]; !ok {
+				chunks = append(chunks, chunk)
+			}
+			fused[chunk.ID] += weights[i] / float64(rrfK+rank+1)
+		}
+	}
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return fused[chunks[i].ID] > fused[chunks[j].ID]
+	})
+	return chunks
+}
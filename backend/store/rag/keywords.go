@@ -0,0 +1,71 @@
+package rag
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxKeywords bounds the keywords returned by GetKeywords.
+const maxKeywords = 10
+
+var englishStopwords = map[string]struct{}{
+	"a": {}, "an": {}, "and": {}, "are": {}, "as": {}, "at": {}, "be": {}, "by": {}, "can": {},
+	"do": {}, "does": {}, "for": {}, "from": {}, "how": {}, "i": {}, "if": {}, "in": {}, "is": {},
+	"it": {}, "my": {}, "of": {}, "on": {}, "or": {}, "the": {}, "to": {}, "what": {}, "when": {},
+	"where": {}, "which": {}, "who": {}, "why": {}, "with": {}, "you": {}, "your": {},
+}
+
+// chineseStopChars are function words, bigrams containing them are rarely topics.
+const chineseStopChars = "的了是在和有我你他她它们吗呢吧啊这那么什怎如何个也就都与及或"
+
+// extractKeywords returns the most frequent terms of text, split the same way as queries
+// are for highlighting: lower-cased words, and character bigrams for CJK text.
+// Ties keep the order of first occurrence, so the result is stable.
+func extractKeywords(text string) []string {
+	counts := make(map[string]int)
+	var terms []string
+	for _, term := range splitTerms(text) {
+		if isStopTerm(term) {
+			continue
+		}
+		if counts[term] == 0 {
+			terms = append(terms, term)
+		}
+		counts[term]++
+	}
+	sort.SliceStable(terms, func(i, j int) bool { return counts[terms[i]] > counts[terms[j]] })
+	return terms[:min(len(terms), maxKeywords)]
+}
+
+// splitTerms splits text into lower-cased words, CJK words have no spaces
+// between them and are split into character bigrams instead.
+func splitTerms(text string) []string {
+	var terms []string
+	for _, field := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	}) {
+		runes := []rune(field)
+		if !unicode.Is(unicode.Han, runes[0]) || len(runes) == 1 {
+			terms = append(terms, field)
+			continue
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			terms = append(terms, string(runes[i:i+2]))
+		}
+	}
+	return terms
+}
+
+func isStopTerm(term string) bool {
+	if _, ok := englishStopwords[term]; ok {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(term)
+	if unicode.Is(unicode.Han, r) {
+		return utf8.RuneCountInString(term) < 2 || strings.ContainsAny(term, chineseStopChars)
+	}
+	// single letters and bare numbers
+	return len(term) < 2 || strings.IndexFunc(term, unicode.IsLetter) < 0
+}
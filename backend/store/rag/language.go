@@ -0,0 +1,93 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+// Languages detected at upsert and accepted by QueryRecordsRequest.Language.
+const (
+	LanguageChinese = "zh"
+	LanguageEnglish = "en"
+)
+
+func validateLanguage(lang string) error {
+	switch lang {
+	case "", LanguageChinese, LanguageEnglish:
+		return nil
+	default:
+		return fmt.Errorf("%w: unsupported language: %s", ErrInvalidRequest, lang)
+	}
+}
+
+// detectLanguage tells Chinese from English markdown by comparing Han characters with Latin
+// words, code blocks are skipped since they are English in both. Empty means no text to judge.
+func detectLanguage(markdown string) string {
+	han, words := 0, 0
+	inCode := false
+	for _, line := range strings.Split(markdown, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			continue
+		}
+		inWord := false
+		for _, r := range line {
+			switch {
+			case unicode.Is(unicode.Han, r):
+				han++
+				inWord = false
+			case unicode.Is(unicode.Latin, r):
+				if !inWord {
+					words++
+				}
+				inWord = true
+			default:
+				inWord = false
+			}
+		}
+	}
+	switch {
+	case han == 0 && words == 0:
+		return ""
+	case han >= words:
+		return LanguageChinese
+	default:
+		return LanguageEnglish
+	}
+}
+
+// chunkLanguage is the language recorded for the chunk's document, or detected from the
+// chunk for documents upserted before it was recorded.
+func chunkLanguage(chunk *domain.NodeContentChunk) string {
+	if lang, ok := chunk.Metadata["lang"].(string); ok && lang != "" {
+		return lang
+	}
+	return detectLanguage(chunk.Content)
+}
+
+// preferLanguage keeps the chunks in lang, topped up with the best chunks in other languages
+// when fewer than n match. The order is kept within both groups.
+func preferLanguage(chunks []*domain.NodeContentChunk, lang string, n int) []*domain.NodeContentChunk {
+	if lang == "" {
+		return chunks
+	}
+	matched := make([]*domain.NodeContentChunk, 0, len(chunks))
+	var others []*domain.NodeContentChunk
+	for _, chunk := range chunks {
+		if chunkLanguage(chunk) == lang {
+			matched = append(matched, chunk)
+		} else {
+			others = append(others, chunk)
+		}
+	}
+	if len(matched) >= n {
+		return matched
+	}
+	return append(matched, others[:min(n-len(matched), len(others))]...)
+}
@@ -0,0 +1,207 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+// LocalRAG is the provider for on-prem deployments backed by Qdrant and a local embeddings service.
+// Embedding models come from config, so model management calls are no-ops.
+// Dataset and document operations are not implemented yet and return ErrNotImplemented.
+type LocalRAG struct {
+	config           config.LocalRAGConfig
+	maxDocumentBytes int
+	logger           *log.Logger
+}
+
+func NewLocalRAG(config *config.Config, logger *log.Logger) (*LocalRAG, error) {
+	if config.RAG.Local.QdrantURL == "" {
+		return nil, fmt.Errorf("local rag qdrant url is required")
+	}
+	if config.RAG.Local.EmbeddingURL == "" {
+		return nil, fmt.Errorf("local rag embedding url is required")
+	}
+	return &LocalRAG{
+		config:           config.RAG.Local,
+		maxDocumentBytes: config.RAG.MaxDocumentBytes,
+		logger:           logger.WithModule("store.vector.local"),
+	}, nil
+}
+
+func (s *LocalRAG) notImplemented(op string) error {
+	return fmt.Errorf("local rag %s: %w", op, ErrNotImplemented)
+}
+
+func (s *LocalRAG) Capabilities() Capabilities {
+	return Capabilities{}
+}
+
+func (s *LocalRAG) CreateKnowledgeBase(ctx context.Context, name string, provider string) (string, error) {
+	return "", s.notImplemented("create knowledge base")
+}
+
+func (s *LocalRAG) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	return nil, s.notImplemented("list knowledge bases")
+}
+
+func (s *LocalRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	return nil, s.notImplemented("upsert records")
+}
+
+func (s *LocalRAG) BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) ([]UpsertResult, error) {
+	return nil, s.notImplemented("batch upsert records")
+}
+
+func (s *LocalRAG) UpsertFile(ctx context.Context, req *UpsertFileRequest) (string, error) {
+	return upsertFileAsText(ctx, s, req, s.maxDocumentBytes)
+}
+
+func (s *LocalRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	return nil, s.notImplemented("query records")
+}
+
+func (s *LocalRAG) QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error) {
+	return nil, s.notImplemented("query records")
+}
+
+func (s *LocalRAG) QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error) {
+	return streamQueryResult(ctx, func(ctx context.Context) (*QueryResult, error) {
+		return s.QueryRecords(ctx, req)
+	})
+}
+
+func (s *LocalRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	return s.notImplemented("delete records")
+}
+
+func (s *LocalRAG) DeleteAllDocuments(ctx context.Context, datasetID string) error {
+	return s.notImplemented("delete all documents")
+}
+
+func (s *LocalRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
+	return s.notImplemented("delete knowledge base")
+}
+
+func (s *LocalRAG) SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) error {
+	return s.notImplemented("set knowledge base options")
+}
+
+func (s *LocalRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error {
+	return s.notImplemented("update document group ids")
+}
+
+func (s *LocalRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error {
+	return s.notImplemented("update document permissions")
+}
+
+func (s *LocalRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error {
+	return s.notImplemented("update document tags")
+}
+
+func (s *LocalRAG) UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) error {
+	return s.notImplemented("update document metadata")
+}
+
+func (s *LocalRAG) UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) error {
+	return s.notImplemented("update document")
+}
+
+func (s *LocalRAG) ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	return s.notImplemented("archive documents")
+}
+
+func (s *LocalRAG) RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	return s.notImplemented("restore documents")
+}
+
+func (s *LocalRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error) {
+	return nil, s.notImplemented("list documents")
+}
+
+func (s *LocalRAG) CountDocuments(ctx context.Context, datasetID string) (int, error) {
+	return 0, s.notImplemented("count documents")
+}
+
+func (s *LocalRAG) CountDocumentsByGroup(ctx context.Context, datasetID string) (map[int]int, error) {
+	return nil, s.notImplemented("count documents by group")
+}
+
+func (s *LocalRAG) BrowseRecords(ctx context.Context, req *BrowseRecordsRequest) ([]BrowseEntry, error) {
+	return nil, s.notImplemented("browse records")
+}
+
+func (s *LocalRAG) DatasetExists(ctx context.Context, datasetID string) (bool, error) {
+	return false, s.notImplemented("dataset exists")
+}
+
+func (s *LocalRAG) GetDocument(ctx context.Context, datasetID string, docID string) (*Document, error) {
+	return nil, s.notImplemented("get document")
+}
+
+func (s *LocalRAG) GetDocumentContent(ctx context.Context, datasetID string, docID string) (string, error) {
+	return "", s.notImplemented("get document content")
+}
+
+func (s *LocalRAG) GetDocumentChunks(ctx context.Context, datasetID string, docID string) ([]*domain.NodeContentChunk, error) {
+	return nil, s.notImplemented("get document chunks")
+}
+
+func (s *LocalRAG) GetKeywords(ctx context.Context, datasetID string, text string) ([]string, error) {
+	return extractKeywords(text), nil
+}
+
+// Ping calls the health endpoints of Qdrant and the embeddings service.
+func (s *LocalRAG) Ping(ctx context.Context) error {
+	for _, url := range []string{
+		strings.TrimRight(s.config.QdrantURL, "/") + "/healthz",
+		strings.TrimRight(s.config.EmbeddingURL, "/") + "/health",
+	} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return fmt.Errorf("ping local rag failed: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("ping local rag %s failed: %w", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("ping local rag %s failed: status %d", url, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+func (s *LocalRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
+	return []*domain.Model{}, nil
+}
+
+func (s *LocalRAG) GetModelsByType(ctx context.Context) (map[domain.ModelType][]*domain.Model, error) {
+	return map[domain.ModelType][]*domain.Model{}, nil
+}
+
+func (s *LocalRAG) GetModel(ctx context.Context, id string) (*domain.Model, error) {
+	return nil, fmt.Errorf("%w: %s", ErrModelNotFound, id)
+}
+
+func (s *LocalRAG) AddModel(ctx context.Context, model *domain.Model) (string, error) {
+	return model.ID, nil
+}
+
+func (s *LocalRAG) UpdateModel(ctx context.Context, model *domain.Model) error {
+	return nil
+}
+
+func (s *LocalRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
+	return nil
+}
+
+func (s *LocalRAG) DeleteModel(ctx context.Context, model *domain.Model) error {
+	return nil
+}
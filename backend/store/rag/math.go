@@ -0,0 +1,95 @@
+package rag
+
+import (
+	"strings"
+
+	"github.com/JohannesKaufmann/dom"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"golang.org/x/net/html"
+)
+
+// texEncoding is the annotation encoding of the TeX source KaTeX and MathJax keep in MathML.
+const texEncoding = "application/x-tex"
+
+// renderMath writes formulas as their TeX source, $...$ inline and $$...$$ for display math,
+// instead of the rendered span tree. It handles the math nodes of our editor, which keep the
+// source in data-latex, KaTeX output and bare MathML. Formulas without a source fall back to
+// their aria-label or alt text.
+func renderMath(ctx converter.Context, w converter.Writer, n *html.Node) converter.RenderStatus {
+	if !isMath(n) {
+		return converter.RenderTryNext
+	}
+	tex := mathSource(n)
+	if tex == "" {
+		label := mathLabel(n)
+		if label == "" {
+			return converter.RenderTryNext
+		}
+		w.WriteString(label)
+		return converter.RenderSuccess
+	}
+	if isDisplayMath(n) {
+		w.WriteString("\n\n$$\n" + tex + "\n$$\n\n")
+	} else {
+		w.WriteString("$" + tex + "$")
+	}
+	return converter.RenderSuccess
+}
+
+func isMath(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if _, ok := dom.GetAttribute(n, "data-latex"); ok {
+		return true
+	}
+	return n.Data == "math" || dom.HasClass(n, "katex") || dom.HasClass(n, "katex-display")
+}
+
+func isDisplayMath(n *html.Node) bool {
+	if dom.HasClass(n, "katex-display") {
+		return true
+	}
+	if dataType, _ := dom.GetAttribute(n, "data-type"); dataType == "block-math" {
+		return true
+	}
+	math := dom.FindFirstNode(n, func(c *html.Node) bool { return c.Type == html.ElementNode && c.Data == "math" })
+	if math == nil {
+		return false
+	}
+	display, _ := dom.GetAttribute(math, "display")
+	return display == "block"
+}
+
+// mathSource returns the TeX of a formula, from data-latex or the TeX annotation.
+func mathSource(n *html.Node) string {
+	if tex, ok := dom.GetAttribute(n, "data-latex"); ok {
+		return strings.TrimSpace(tex)
+	}
+	annotation := dom.FindFirstNode(n, func(c *html.Node) bool {
+		if c.Type != html.ElementNode || c.Data != "annotation" {
+			return false
+		}
+		encoding, _ := dom.GetAttribute(c, "encoding")
+		return encoding == texEncoding
+	})
+	if annotation == nil {
+		return ""
+	}
+	return strings.TrimSpace(codeText(annotation))
+}
+
+// mathLabel returns the accessible text of a formula, MathML keeps it in alttext.
+func mathLabel(n *html.Node) string {
+	for _, key := range []string{"aria-label", "alt"} {
+		if label, _ := dom.GetAttribute(n, key); strings.TrimSpace(label) != "" {
+			return strings.TrimSpace(label)
+		}
+	}
+	math := dom.FindFirstNode(n, func(c *html.Node) bool { return c.Type == html.ElementNode && c.Data == "math" })
+	if math == nil {
+		return ""
+	}
+	label, _ := dom.GetAttribute(math, "alttext")
+	return strings.TrimSpace(label)
+}
@@ -0,0 +1,32 @@
+package rag
+
+import "time"
+
+// MetricsRecorder receives latency and error counts of provider requests, keyed by operation.
+// Implementations must be safe for concurrent use.
+type MetricsRecorder interface {
+	ObserveLatency(op string, d time.Duration)
+	IncError(op string)
+}
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveLatency(string, time.Duration) {}
+func (noopMetrics) IncError(string)                      {}
+
+// WithMetricsRecorder reports every raglite request to r.
+func WithMetricsRecorder(r MetricsRecorder) CTRAGOption {
+	return func(s *CTRAG) {
+		if r != nil {
+			s.metrics = r
+		}
+	}
+}
+
+// observe records one finished raglite request.
+func (s *CTRAG) observe(op string, start time.Time, err error) {
+	s.metrics.ObserveLatency(op, time.Since(start))
+	if err != nil {
+		s.metrics.IncError(op)
+	}
+}
@@ -0,0 +1,32 @@
+package rag
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingMetrics struct {
+	latencies map[string]int
+	errors    map[string]int
+}
+
+func (m *countingMetrics) ObserveLatency(op string, d time.Duration) { m.latencies[op]++ }
+func (m *countingMetrics) IncError(op string)                        { m.errors[op]++ }
+
+func TestObserve(t *testing.T) {
+	rec := &countingMetrics{latencies: map[string]int{}, errors: map[string]int{}}
+	s := &CTRAG{metrics: noopMetrics{}}
+	WithMetricsRecorder(rec)(s)
+
+	s.observe("retrieve", time.Now(), nil)
+	s.observe("retrieve", time.Now(), errors.New("boom"))
+
+	assert.Equal(t, 2, rec.latencies["retrieve"])
+	assert.Equal(t, 1, rec.errors["retrieve"])
+
+	WithMetricsRecorder(nil)(s)
+	assert.Equal(t, rec, s.metrics)
+}
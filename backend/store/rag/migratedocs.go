@@ -0,0 +1,124 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// migrateDocumentWorkers bounds the concurrent documents of MigrateDocuments
+const migrateDocumentWorkers = 4
+
+// MigrateDocumentsError lists the documents that failed to migrate, the others were migrated.
+type MigrateDocumentsError struct {
+	Failed map[string]error
+}
+
+func (e *MigrateDocumentsError) Error() string {
+	docIDs := make([]string, 0, len(e.Failed))
+	for docID := range e.Failed {
+		docIDs = append(docIDs, docID)
+	}
+	sort.Strings(docIDs)
+	msgs := make([]string, len(docIDs))
+	for i, docID := range docIDs {
+		msgs[i] = fmt.Sprintf("%s: %v", docID, e.Failed[docID])
+	}
+	return fmt.Sprintf("migrate documents failed for %d documents: %s", len(e.Failed), strings.Join(msgs, "; "))
+}
+
+func (e *MigrateDocumentsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, err := range e.Failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// MigrateDocuments copies documents from one dataset to another with their content, tags,
+// group IDs and the rest of their metadata, and returns the new doc ID of each old one.
+// Documents keep their IDs and unchanged content is skipped, so a failed run can be retried
+// with the same docIDs without duplicates. The source documents are left in place.
+// When some documents fail it returns a *MigrateDocumentsError naming them together with
+// the mapping of the others. The content is read with GetDocumentContent, a source that can't
+// read documents back, such as CTRAG, fails every document with ErrNotImplemented.
+func MigrateDocuments(ctx context.Context, service RAGService, srcDatasetID, dstDatasetID string, docIDs []string) (map[string]string, error) {
+	if srcDatasetID == dstDatasetID {
+		return nil, fmt.Errorf("%w: source and destination dataset are both %s", ErrInvalidRequest, srcDatasetID)
+	}
+	migrated := make(map[string]string, len(docIDs))
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	workers := make(chan struct{}, migrateDocumentWorkers)
+	var wg sync.WaitGroup
+	for _, docID := range docIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			workers <- struct{}{}
+			defer func() { <-workers }()
+			newID, err := migrateDocument(ctx, service, srcDatasetID, dstDatasetID, docID)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed[docID] = err
+				return
+			}
+			migrated[docID] = newID
+		}()
+	}
+	wg.Wait()
+	if len(failed) > 0 {
+		return migrated, &MigrateDocumentsError{Failed: failed}
+	}
+	return migrated, nil
+}
+
+func migrateDocument(ctx context.Context, service RAGService, srcDatasetID, dstDatasetID, docID string) (string, error) {
+	doc, err := service.GetDocument(ctx, srcDatasetID, docID)
+	if err != nil {
+		return "", err
+	}
+	content, err := service.GetDocumentContent(ctx, srcDatasetID, docID)
+	if err != nil {
+		return "", err
+	}
+	meta := doc.MetaData
+	req := &UpsertRecordsRequest{
+		ID:             docID,
+		DatasetID:      dstDatasetID,
+		DocID:          docID,
+		Title:          meta.Title,
+		Content:        content,
+		ContentType:    ContentTypeMarkdown,
+		GroupIDs:       meta.GroupIDs,
+		Tags:           doc.Tags,
+		ParentDocID:    meta.ParentDocID,
+		Anchor:         meta.Anchor,
+		URL:            meta.URL,
+		SplitOversized: meta.Parts > 0,
+		Metadata:       meta.Custom,
+	}
+	if updatedAt, err := time.Parse(time.RFC3339, meta.UpdatedAt); err == nil {
+		req.UpdatedAt = updatedAt
+	}
+	res, err := service.UpsertRecords(ctx, req)
+	if err != nil {
+		return "", fmt.Errorf("upsert into %s failed: %w", dstDatasetID, err)
+	}
+	// visibility and archiving have no upsert fields
+	if meta.Visibility != "" {
+		if err := service.UpdateDocumentPermissions(ctx, dstDatasetID, res.DocID, meta.GroupIDs, meta.Visibility); err != nil {
+			return "", err
+		}
+	}
+	if meta.Archived {
+		if err := service.ArchiveDocuments(ctx, dstDatasetID, []string{res.DocID}); err != nil {
+			return "", err
+		}
+	}
+	return res.DocID, nil
+}
@@ -0,0 +1,359 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+// DocumentLoader rebuilds the upsert request of a listed document, since providers do not return content.
+type DocumentLoader func(ctx context.Context, doc Document) (*UpsertRecordsRequest, error)
+
+// MigrationReport summarizes one MigrateKnowledgeBase run.
+type MigrationReport struct {
+	Total    int               `json:"total"`
+	Migrated int               `json:"migrated"`
+	Skipped  int               `json:"skipped"`
+	Failed   map[string]string `json:"failed"`
+}
+
+var _ RAGService = (*MigratingRAG)(nil)
+
+// MigratingRAG dual-writes to a source and a target provider while switching providers.
+// Reads are served by the source until cutover, then by the target.
+// Both providers are expected to address datasets and documents by the same IDs, so datasets
+// are created on the source only and set up on the target by operators. Models stay on the
+// source, the stored model IDs are its own.
+type MigratingRAG struct {
+	source  RAGService
+	target  RAGService
+	cutover atomic.Bool
+	logger  *log.Logger
+}
+
+func NewMigratingRAG(source, target RAGService, cutover bool, logger *log.Logger) *MigratingRAG {
+	s := &MigratingRAG{
+		source: source,
+		target: target,
+		logger: logger.WithModule("store.vector.migrating"),
+	}
+	s.SetCutover(cutover)
+	return s
+}
+
+// SetCutover switches reads between the source and the target provider.
+func (s *MigratingRAG) SetCutover(cutover bool) {
+	s.cutover.Store(cutover)
+}
+
+func (s *MigratingRAG) reader() RAGService {
+	if s.cutover.Load() {
+		return s.target
+	}
+	return s.source
+}
+
+// Capabilities are those of both providers, reads move to the target at cutover.
+func (s *MigratingRAG) Capabilities() Capabilities {
+	return s.source.Capabilities().intersect(s.target.Capabilities())
+}
+
+func (s *MigratingRAG) CreateKnowledgeBase(ctx context.Context, name string, provider string) (string, error) {
+	return s.source.CreateKnowledgeBase(ctx, name, provider)
+}
+
+func (s *MigratingRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	return s.reader().QueryRecords(ctx, req)
+}
+
+func (s *MigratingRAG) QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error) {
+	multiReq, err := multiQueryRequest(datasetIDs, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryRecords(ctx, multiReq)
+}
+
+func (s *MigratingRAG) QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error) {
+	return s.reader().QueryRecordsStream(ctx, req)
+}
+
+func (s *MigratingRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error) {
+	return s.reader().ListDocuments(ctx, datasetID, documentIDs)
+}
+
+func (s *MigratingRAG) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	return s.reader().ListKnowledgeBases(ctx)
+}
+
+func (s *MigratingRAG) CountDocuments(ctx context.Context, datasetID string) (int, error) {
+	return s.reader().CountDocuments(ctx, datasetID)
+}
+
+func (s *MigratingRAG) CountDocumentsByGroup(ctx context.Context, datasetID string) (map[int]int, error) {
+	return s.reader().CountDocumentsByGroup(ctx, datasetID)
+}
+
+func (s *MigratingRAG) BrowseRecords(ctx context.Context, req *BrowseRecordsRequest) ([]BrowseEntry, error) {
+	return s.reader().BrowseRecords(ctx, req)
+}
+
+func (s *MigratingRAG) DatasetExists(ctx context.Context, datasetID string) (bool, error) {
+	return s.reader().DatasetExists(ctx, datasetID)
+}
+
+func (s *MigratingRAG) GetDocument(ctx context.Context, datasetID string, docID string) (*Document, error) {
+	return s.reader().GetDocument(ctx, datasetID, docID)
+}
+
+func (s *MigratingRAG) GetDocumentContent(ctx context.Context, datasetID string, docID string) (string, error) {
+	return s.reader().GetDocumentContent(ctx, datasetID, docID)
+}
+
+func (s *MigratingRAG) GetDocumentChunks(ctx context.Context, datasetID string, docID string) ([]*domain.NodeContentChunk, error) {
+	return s.reader().GetDocumentChunks(ctx, datasetID, docID)
+}
+
+func (s *MigratingRAG) GetKeywords(ctx context.Context, datasetID string, text string) ([]string, error) {
+	return s.reader().GetKeywords(ctx, datasetID, text)
+}
+
+// Ping checks both providers, since every write goes to both.
+func (s *MigratingRAG) Ping(ctx context.Context) error {
+	if err := s.source.Ping(ctx); err != nil {
+		return err
+	}
+	if err := s.target.Ping(ctx); err != nil {
+		return fmt.Errorf("ping migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	res, err := s.source.UpsertRecords(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	targetReq := *req
+	targetReq.DocID = res.DocID
+	if _, err := s.target.UpsertRecords(ctx, &targetReq); err != nil {
+		return nil, fmt.Errorf("upsert records to migration target failed: %w", err)
+	}
+	return res, nil
+}
+
+// BatchUpsertRecords upserts one by one through UpsertRecords, so the target gets the source doc IDs.
+func (s *MigratingRAG) BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) ([]UpsertResult, error) {
+	return batchUpsert(ctx, reqs, defaultBatchUpsertWorkers, s.UpsertRecords)
+}
+
+// UpsertFile buffers the file to upload it to both providers.
+func (s *MigratingRAG) UpsertFile(ctx context.Context, req *UpsertFileRequest) (string, error) {
+	content, err := io.ReadAll(req.File)
+	if err != nil {
+		return "", fmt.Errorf("read file failed: %w", err)
+	}
+	sourceReq := *req
+	sourceReq.File = bytes.NewReader(content)
+	docID, err := s.source.UpsertFile(ctx, &sourceReq)
+	if err != nil {
+		return "", err
+	}
+	targetReq := *req
+	targetReq.DocID = docID
+	targetReq.File = bytes.NewReader(content)
+	if _, err := s.target.UpsertFile(ctx, &targetReq); err != nil {
+		return "", fmt.Errorf("upsert file to migration target failed: %w", err)
+	}
+	return docID, nil
+}
+
+func (s *MigratingRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	if err := s.source.DeleteRecords(ctx, datasetID, docIDs); err != nil {
+		return err
+	}
+	if err := s.target.DeleteRecords(ctx, datasetID, docIDs); err != nil {
+		return fmt.Errorf("delete records from migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) DeleteAllDocuments(ctx context.Context, datasetID string) error {
+	if err := s.source.DeleteAllDocuments(ctx, datasetID); err != nil {
+		return err
+	}
+	if err := s.target.DeleteAllDocuments(ctx, datasetID); err != nil {
+		return fmt.Errorf("delete all documents from migration target failed: %w", err)
+	}
+	return nil
+}
+
+// DeleteKnowledgeBase also deletes the dataset on the target, unless it was never set up there.
+func (s *MigratingRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
+	if err := s.source.DeleteKnowledgeBase(ctx, datasetID); err != nil {
+		return err
+	}
+	if err := s.target.DeleteKnowledgeBase(ctx, datasetID); err != nil && !errors.Is(err, ErrDatasetNotFound) {
+		return fmt.Errorf("delete knowledge base from migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error {
+	if err := s.source.UpdateDocumentGroupIDs(ctx, datasetID, docID, groupIds); err != nil {
+		return err
+	}
+	if err := s.target.UpdateDocumentGroupIDs(ctx, datasetID, docID, groupIds); err != nil {
+		return fmt.Errorf("update document group IDs on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error {
+	if err := s.source.UpdateDocumentPermissions(ctx, datasetID, docID, groupIds, visibility); err != nil {
+		return err
+	}
+	if err := s.target.UpdateDocumentPermissions(ctx, datasetID, docID, groupIds, visibility); err != nil {
+		return fmt.Errorf("update document permissions on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error {
+	if err := s.source.UpdateDocumentTags(ctx, datasetID, docID, tags); err != nil {
+		return err
+	}
+	if err := s.target.UpdateDocumentTags(ctx, datasetID, docID, tags); err != nil {
+		return fmt.Errorf("update document tags on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) error {
+	if err := s.source.UpdateDocumentMetadata(ctx, datasetID, docID, groupIds, tags); err != nil {
+		return err
+	}
+	if err := s.target.UpdateDocumentMetadata(ctx, datasetID, docID, groupIds, tags); err != nil {
+		return fmt.Errorf("update document metadata on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) error {
+	if err := s.source.UpdateDocument(ctx, datasetID, docID, patch); err != nil {
+		return err
+	}
+	if err := s.target.UpdateDocument(ctx, datasetID, docID, patch); err != nil {
+		return fmt.Errorf("update document on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	if err := s.source.ArchiveDocuments(ctx, datasetID, docIDs); err != nil {
+		return err
+	}
+	if err := s.target.ArchiveDocuments(ctx, datasetID, docIDs); err != nil {
+		return fmt.Errorf("archive documents on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	if err := s.source.RestoreDocuments(ctx, datasetID, docIDs); err != nil {
+		return err
+	}
+	if err := s.target.RestoreDocuments(ctx, datasetID, docIDs); err != nil {
+		return fmt.Errorf("restore documents on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) error {
+	if err := s.source.SetKnowledgeBaseOptions(ctx, datasetID, opts); err != nil {
+		return err
+	}
+	if err := s.target.SetKnowledgeBaseOptions(ctx, datasetID, opts); err != nil {
+		return fmt.Errorf("set knowledge base options on migration target failed: %w", err)
+	}
+	return nil
+}
+
+func (s *MigratingRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
+	return s.source.GetModelList(ctx)
+}
+
+func (s *MigratingRAG) GetModelsByType(ctx context.Context) (map[domain.ModelType][]*domain.Model, error) {
+	return s.source.GetModelsByType(ctx)
+}
+
+func (s *MigratingRAG) GetModel(ctx context.Context, id string) (*domain.Model, error) {
+	return s.source.GetModel(ctx, id)
+}
+
+func (s *MigratingRAG) AddModel(ctx context.Context, model *domain.Model) (string, error) {
+	return s.source.AddModel(ctx, model)
+}
+
+func (s *MigratingRAG) UpdateModel(ctx context.Context, model *domain.Model) error {
+	return s.source.UpdateModel(ctx, model)
+}
+
+func (s *MigratingRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
+	return s.source.UpsertModel(ctx, model)
+}
+
+func (s *MigratingRAG) DeleteModel(ctx context.Context, model *domain.Model) error {
+	return s.source.DeleteModel(ctx, model)
+}
+
+// MigrateKnowledgeBase copies every document of a dataset from the source to the target.
+// Documents already present in the target are skipped, so a failed run can simply be retried.
+func (s *MigratingRAG) MigrateKnowledgeBase(ctx context.Context, datasetID string, load DocumentLoader) (*MigrationReport, error) {
+	docs, err := s.source.ListDocuments(ctx, datasetID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list source documents failed: %w", err)
+	}
+	migrated, err := s.target.ListDocuments(ctx, datasetID, nil)
+	if err != nil {
+		return nil, fmt.Errorf("list target documents failed: %w", err)
+	}
+	existing := make(map[string]struct{}, len(migrated))
+	for _, doc := range migrated {
+		existing[doc.ID] = struct{}{}
+	}
+
+	report := &MigrationReport{Total: len(docs), Failed: make(map[string]string)}
+	for i, doc := range docs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if _, ok := existing[doc.ID]; ok {
+			report.Skipped++
+			continue
+		}
+		req, err := load(ctx, doc)
+		if err == nil {
+			req.DatasetID = datasetID
+			req.DocID = doc.ID
+			_, err = s.target.UpsertRecords(ctx, req)
+		}
+		if err != nil {
+			report.Failed[doc.ID] = err.Error()
+			s.logger.Error("migrate document failed", log.String("dataset_id", datasetID), log.String("doc_id", doc.ID), log.Error(err))
+			continue
+		}
+		report.Migrated++
+		if (i+1)%100 == 0 || i+1 == len(docs) {
+			s.logger.Info("migrate knowledge base progress", log.String("dataset_id", datasetID), log.Int("done", i+1), log.Int("total", len(docs)))
+		}
+	}
+	s.logger.Info("migrate knowledge base finished", log.String("dataset_id", datasetID), log.Any("report", report))
+	return report, nil
+}
@@ -0,0 +1,93 @@
+package rag
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+func TestMigratingRAGDualWrite(t *testing.T) {
+	writes := []struct {
+		op   string
+		call func(s RAGService) error
+	}{
+		{op: "upsert_records", call: func(s RAGService) error {
+			_, err := s.UpsertRecords(context.Background(), &UpsertRecordsRequest{DatasetID: "ds", DocID: "doc"})
+			return err
+		}},
+		{op: "delete_records", call: func(s RAGService) error {
+			return s.DeleteRecords(context.Background(), "ds", []string{"doc"})
+		}},
+		{op: "update_document_tags", call: func(s RAGService) error {
+			return s.UpdateDocumentTags(context.Background(), "ds", "doc", []string{"go"})
+		}},
+		{op: "delete_knowledge_base", call: func(s RAGService) error {
+			return s.DeleteKnowledgeBase(context.Background(), "ds")
+		}},
+	}
+	tests := []struct {
+		name       string
+		sourceErr  error
+		targetErr  error
+		wantErr    error
+		wantTarget bool
+	}{
+		{name: "both providers apply", wantTarget: true},
+		{name: "target fails after the source applied", targetErr: ErrUnavailable, wantErr: ErrUnavailable, wantTarget: true},
+		{name: "source fails and the target is left alone", sourceErr: ErrUnavailable, wantErr: ErrUnavailable},
+	}
+	for _, w := range writes {
+		for _, tt := range tests {
+			t.Run(w.op+"/"+tt.name, func(t *testing.T) {
+				source, target := &recordingRAG{err: tt.sourceErr}, &recordingRAG{err: tt.targetErr}
+				service := NewMigratingRAG(source, target, false, log.NewLogger(&config.Config{}))
+				err := w.call(service)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+				} else {
+					require.NoError(t, err)
+				}
+				if tt.targetErr != nil {
+					assert.ErrorContains(t, err, "migration target")
+				}
+				assert.Equal(t, []string{w.op}, source.recorded())
+				if tt.wantTarget {
+					assert.Equal(t, []string{w.op}, target.recorded())
+				} else {
+					assert.Empty(t, target.recorded())
+				}
+			})
+		}
+	}
+}
+
+func TestMigratingRAGDeleteKnowledgeBaseMissingOnTarget(t *testing.T) {
+	source, target := &recordingRAG{}, &recordingRAG{err: ErrDatasetNotFound}
+	service := NewMigratingRAG(source, target, false, log.NewLogger(&config.Config{}))
+	require.NoError(t, service.DeleteKnowledgeBase(context.Background(), "ds"))
+	assert.Equal(t, []string{"delete_knowledge_base"}, target.recorded())
+}
+
+func TestMigratingRAGReads(t *testing.T) {
+	source, target := &recordingRAG{}, &recordingRAG{}
+	service := NewMigratingRAG(source, target, false, log.NewLogger(&config.Config{}))
+	_, err := service.QueryRecords(context.Background(), &QueryRecordsRequest{DatasetID: "ds", Query: "q"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"query_records"}, source.recorded())
+	assert.Empty(t, target.recorded())
+
+	service.SetCutover(true)
+	_, err = service.QueryRecords(context.Background(), &QueryRecordsRequest{DatasetID: "ds", Query: "q"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"query_records"}, target.recorded())
+
+	// models stay on the source after cutover
+	_, err = service.GetModelList(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"query_records", "get_model_list"}, source.recorded())
+}
@@ -0,0 +1,127 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+// maxExpandNeighbors bounds how many blocks are added on each side of a chunk.
+const maxExpandNeighbors = 10
+
+func validateExpandNeighbors(n int) error {
+	if n < 0 || n > maxExpandNeighbors {
+		return fmt.Errorf("%w: expand neighbors must be between 0 and %d, got %d", ErrInvalidRequest, maxExpandNeighbors, n)
+	}
+	return nil
+}
+
+type docKey struct{ datasetID, docID string }
+
+// neighborExpander widens chunks with the markdown blocks around them in their document.
+// raglite has no chunk listing, so the neighbors come from the stored markdown: documents
+// that can't be read keep their chunks as they are. It is not safe for concurrent use.
+type neighborExpander struct {
+	s        *CTRAG
+	n        int
+	byDoc    map[docKey][]*domain.NodeContentChunk
+	loaded   map[docKey]bool
+	replaced map[*domain.NodeContentChunk]*domain.NodeContentChunk
+}
+
+// newNeighborExpander prepares expanding chunks by n blocks on each side, all chunks of a
+// document must be passed here so overlapping ones can be merged.
+func (s *CTRAG) newNeighborExpander(chunks []*domain.NodeContentChunk, n int) *neighborExpander {
+	e := &neighborExpander{
+		s:        s,
+		n:        n,
+		byDoc:    make(map[docKey][]*domain.NodeContentChunk),
+		loaded:   make(map[docKey]bool),
+		replaced: make(map[*domain.NodeContentChunk]*domain.NodeContentChunk, len(chunks)),
+	}
+	for _, chunk := range chunks {
+		key := docKey{chunk.DatasetID, chunk.DocID}
+		e.byDoc[key] = append(e.byDoc[key], chunk)
+	}
+	return e
+}
+
+// expand returns chunk widened with its neighbors, or nil when it was merged into another
+// chunk of its document. A document is read when the first of its chunks is expanded.
+func (e *neighborExpander) expand(ctx context.Context, chunk *domain.NodeContentChunk) *domain.NodeContentChunk {
+	key := docKey{chunk.DatasetID, chunk.DocID}
+	if !e.loaded[key] {
+		e.loaded[key] = true
+		markdown, err := e.s.GetDocumentContent(ctx, key.datasetID, key.docID)
+		if err != nil {
+			e.s.logger.Warn("read document for neighbor expansion failed, keep original chunks", log.String("dataset_id", key.datasetID), log.String("doc_id", key.docID), log.Error(err))
+		} else {
+			for original, expanded := range expandChunks(markdown, e.byDoc[key], e.n) {
+				e.replaced[original] = expanded
+			}
+		}
+	}
+	if expanded, ok := e.replaced[chunk]; ok {
+		return expanded
+	}
+	return chunk
+}
+
+// expandChunks maps every chunk of one document to a copy widened by n blocks of markdown
+// on each side. Chunks whose widened ranges overlap are merged into the one with the
+// highest score, the others map to nil. Chunks not found in markdown map to themselves.
+func expandChunks(markdown string, chunks []*domain.NodeContentChunk, n int) map[*domain.NodeContentChunk]*domain.NodeContentChunk {
+	blocks := markdownBlocks(markdown)
+	// ends[i] is the offset right after block i
+	ends := make([]int, len(blocks))
+	offset := 0
+	for i, block := range blocks {
+		offset += len(block)
+		ends[i] = offset
+	}
+	type span struct {
+		lo, hi int
+		chunk  *domain.NodeContentChunk
+	}
+	result := make(map[*domain.NodeContentChunk]*domain.NodeContentChunk, len(chunks))
+	var spans []span
+	for _, chunk := range chunks {
+		content := strings.TrimSpace(chunk.Content)
+		start := strings.Index(markdown, content)
+		if content == "" || start < 0 {
+			result[chunk] = chunk
+			continue
+		}
+		first := sort.SearchInts(ends, start+1)
+		last := sort.SearchInts(ends, start+len(content))
+		spans = append(spans, span{lo: max(first-n, 0), hi: min(last+n, len(blocks)-1), chunk: chunk})
+	}
+	// chunks are ranked, a stable sort keeps the better chunk first among equal starts
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].lo < spans[j].lo })
+	for i := 0; i < len(spans); {
+		group := []*domain.NodeContentChunk{spans[i].chunk}
+		lo, hi := spans[i].lo, spans[i].hi
+		j := i + 1
+		for ; j < len(spans) && spans[j].lo <= hi; j++ {
+			group = append(group, spans[j].chunk)
+			hi = max(hi, spans[j].hi)
+		}
+		best := group[0]
+		for _, chunk := range group[1:] {
+			if chunk.Score > best.Score {
+				best = chunk
+			}
+			result[chunk] = nil
+		}
+		result[group[0]] = nil
+		expanded := *best
+		expanded.Content = strings.Join(blocks[lo:hi+1], "")
+		result[best] = &expanded
+		i = j
+	}
+	return result
+}
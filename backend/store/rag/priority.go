@@ -0,0 +1,129 @@
+package rag
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Priority classifies RAG calls so interactive traffic is not starved by background jobs.
+type Priority int
+
+const (
+	PriorityInteractive Priority = iota
+	PriorityBackground
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	default:
+		return "background"
+	}
+}
+
+type priorityCtxKey struct{}
+
+// WithPriority marks all RAG calls made with ctx as the given class.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityCtxKey{}, p)
+}
+
+// PriorityFromContext returns the class carried by ctx, defaulting to interactive.
+// Unknown classes count as background, so they can't take the reserved slots.
+func PriorityFromContext(ctx context.Context) Priority {
+	p, ok := ctx.Value(priorityCtxKey{}).(Priority)
+	if !ok || p == PriorityInteractive {
+		return PriorityInteractive
+	}
+	return PriorityBackground
+}
+
+// PriorityStats is a snapshot of limiter usage for one priority class.
+type PriorityStats struct {
+	Acquired int64
+	InFlight int64
+	Waited   time.Duration
+}
+
+// priorityLimiter bounds concurrent calls to the backend. Background calls must first
+// take a slot from a smaller pool, so the reserved slots are always left for interactive calls.
+type priorityLimiter struct {
+	all        chan struct{}
+	background chan struct{}
+
+	acquired [2]atomic.Int64
+	inFlight [2]atomic.Int64
+	waited   [2]atomic.Int64
+}
+
+// newPriorityLimiter returns nil (no limiting) when maxConcurrency is not positive.
+func newPriorityLimiter(maxConcurrency, interactiveReserved int) *priorityLimiter {
+	if maxConcurrency <= 0 {
+		return nil
+	}
+	if interactiveReserved < 0 {
+		interactiveReserved = 0
+	}
+	if interactiveReserved >= maxConcurrency {
+		interactiveReserved = maxConcurrency - 1
+	}
+	return &priorityLimiter{
+		all:        make(chan struct{}, maxConcurrency),
+		background: make(chan struct{}, maxConcurrency-interactiveReserved),
+	}
+}
+
+func (l *priorityLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	p := PriorityFromContext(ctx)
+	start := time.Now()
+	if p == PriorityBackground {
+		select {
+		case l.background <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	select {
+	case l.all <- struct{}{}:
+	case <-ctx.Done():
+		if p == PriorityBackground {
+			<-l.background
+		}
+		return nil, ctx.Err()
+	}
+	l.acquired[p].Add(1)
+	l.inFlight[p].Add(1)
+	l.waited[p].Add(int64(time.Since(start)))
+
+	var released atomic.Bool
+	return func() {
+		if !released.CompareAndSwap(false, true) {
+			return
+		}
+		l.inFlight[p].Add(-1)
+		<-l.all
+		if p == PriorityBackground {
+			<-l.background
+		}
+	}, nil
+}
+
+func (l *priorityLimiter) stats() map[Priority]PriorityStats {
+	res := make(map[Priority]PriorityStats, 2)
+	if l == nil {
+		return res
+	}
+	for _, p := range []Priority{PriorityInteractive, PriorityBackground} {
+		res[p] = PriorityStats{
+			Acquired: l.acquired[p].Load(),
+			InFlight: l.inFlight[p].Load(),
+			Waited:   time.Duration(l.waited[p].Load()),
+		}
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package rag
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriorityFromContext(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, PriorityInteractive, PriorityFromContext(ctx))
+	assert.Equal(t, PriorityBackground, PriorityFromContext(WithPriority(ctx, PriorityBackground)))
+	assert.Equal(t, PriorityBackground, PriorityFromContext(WithPriority(ctx, Priority(7))))
+	assert.Equal(t, PriorityBackground, PriorityFromContext(WithPriority(ctx, Priority(-1))))
+
+	l := newPriorityLimiter(2, 1)
+	release, err := l.acquire(WithPriority(ctx, Priority(7)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), l.stats()[PriorityBackground].InFlight)
+	release()
+}
+
+func TestPriorityLimiter_Disabled(t *testing.T) {
+	l := newPriorityLimiter(0, 0)
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestPriorityLimiter_BackgroundCannotTakeReservedSlots(t *testing.T) {
+	l := newPriorityLimiter(4, 1)
+	bgCtx := WithPriority(context.Background(), PriorityBackground)
+
+	var releases []func()
+	for i := 0; i < 3; i++ {
+		release, err := l.acquire(bgCtx)
+		require.NoError(t, err)
+		releases = append(releases, release)
+	}
+
+	ctx, cancel := context.WithTimeout(bgCtx, 20*time.Millisecond)
+	defer cancel()
+	_, err := l.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release, err := l.acquire(context.Background())
+	require.NoError(t, err)
+	release()
+
+	for _, release := range releases {
+		release()
+	}
+	assert.Equal(t, int64(0), l.stats()[PriorityBackground].InFlight)
+}
+
+// TestPriorityLimiter_InteractiveServedBeforeQueuedBackground queues background calls behind
+// a held background slot and checks an interactive call is served while they wait.
+func TestPriorityLimiter_InteractiveServedBeforeQueuedBackground(t *testing.T) {
+	l := newPriorityLimiter(3, 1)
+	bgCtx := WithPriority(context.Background(), PriorityBackground)
+	var held []func()
+	for i := 0; i < 2; i++ {
+		release, err := l.acquire(bgCtx)
+		require.NoError(t, err)
+		held = append(held, release)
+	}
+
+	var wg sync.WaitGroup
+	var served atomic.Int64
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := l.acquire(bgCtx)
+			if !assert.NoError(t, err) {
+				return
+			}
+			served.Add(1)
+			release()
+		}()
+	}
+
+	// the timeout only keeps a broken limiter from hanging the test
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	release, err := l.acquire(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, served.Load())
+	release()
+
+	for _, release := range held {
+		release()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(4), served.Load())
+	assert.Equal(t, int64(6), l.stats()[PriorityBackground].Acquired)
+	assert.Equal(t, int64(1), l.stats()[PriorityInteractive].Acquired)
+}
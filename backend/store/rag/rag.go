@@ -3,9 +3,17 @@ package rag
 import (
 	"context"
 	"fmt"
+	"math"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/cloudwego/eino/schema"
 	"github.com/google/wire"
+	"go.opentelemetry.io/otel"
 
 	"github.com/chaitin/panda-wiki/config"
 	"github.com/chaitin/panda-wiki/domain"
@@ -13,29 +21,336 @@ import (
 )
 
 type QueryRecordsRequest struct {
-	DatasetID           string
-	Query               string
-	GroupIDs            []int
-	Tags                []string
+	DatasetID string
+	Query     string
+	GroupIDs  []int
+	// Tags filters by document tags, combined as TagMatchMode says
+	Tags []string
+	// TagMatchMode empty means TagMatchAny
+	TagMatchMode TagMatchMode
+	// SimilarityThreshold 0 uses the configured default, NoSimilarityThreshold disables it
 	SimilarityThreshold float64
-	HistoryMsgs         []*schema.Message
-	MaxChunksPerDoc     int
+	// HistoryMsgs lets the provider rewrite the query, only the last maxHistoryTurns turns are used
+	HistoryMsgs     []*schema.Message
+	MaxChunksPerDoc int
+	// DatasetIDs queries more datasets together with DatasetID, results are merged by score
+	DatasetIDs []string
+	// MetadataFilters match document metadata set at upsert time, group_ids is reserved for GroupIDs
+	MetadataFilters map[string]any
+	// SearchMode defaults to vector, hybrid results are ordered by fused rank rather than score
+	SearchMode SearchMode
+	// RecencyBoost is a half-life in days, scores are halved for every half-life of document age.
+	// Documents indexed without an update time are not penalized. Hybrid search keeps the fused order.
+	RecencyBoost float64
+	// DateFrom and DateTo keep documents dated within the range, a zero bound is open. The date is
+	// read from the custom metadata field DateField, DefaultDateField when empty, or updated_at.
+	// Documents without the date are left out while a bound is set.
+	DateFrom  time.Time
+	DateTo    time.Time
+	DateField string
+	// HybridAlpha is the weight of the vector leg in hybrid search, the keyword leg gets 1-HybridAlpha.
+	// 0 means 0.5, use SearchModeKeyword for keyword only results.
+	HybridAlpha float64
+	// Offset and Limit page through the ranked results, both 0 returns the top 10
+	Offset int
+	Limit  int
+	// Rerank over-fetches candidates and reorders them with the configured rerank model,
+	// scores become the rerank relevance scores. It is ignored when no rerank model is configured.
+	Rerank bool
+	// MMRLambda enables maximal marginal relevance selection from over-fetched candidates,
+	// 1 ranks by relevance only and lower values favor diverse chunks, 0 disables it
+	MMRLambda float64
+	// Highlight fills NodeContentChunk.Snippet with the best matching part of each returned chunk
+	Highlight bool
+	// DisableQueryRewrite ignores HistoryMsgs, QueryResult.RewrittenQuery is then always Query
+	DisableQueryRewrite bool
+	// ExcludeTags drops documents carrying any of these tags, even if they match Tags
+	ExcludeTags []string
+	// ExcludeDocIDs drops chunks of these documents, more candidates are fetched to make up for them
+	ExcludeDocIDs []string
+	// RerankModel picks a rerank model by name or ID, empty means the first registered one
+	RerankModel string
+	// ExpandNeighbors widens each returned chunk with the N markdown blocks before and after it
+	// in its document, overlapping chunks of a document are merged. Chunks that can't be
+	// located in their document, or of providers that can't read documents back such as CTRAG,
+	// are returned unchanged.
+	ExpandNeighbors int
+	// Dedup drops chunks nearly identical to a higher scored chunk, e.g. boilerplate copied
+	// into several documents, combine with MaxChunksPerDoc to also cap chunks per document
+	Dedup bool
+	// DryRun builds the provider requests without sending them, they are returned as QueryResult.DryRunPayload
+	DryRun bool
+	// Language prefers chunks in this language, LanguageChinese or LanguageEnglish, other
+	// languages only fill up to the requested chunk count. Empty ranks all languages alike.
+	Language string
+	// EmbeddingModel picks the embedding model of the query by name or ID, empty uses the dataset's
+	EmbeddingModel string
+}
+
+// multiQueryRequest is req querying datasetIDs instead of its own datasets, req is not modified.
+func multiQueryRequest(datasetIDs []string, req *QueryRecordsRequest) (*QueryRecordsRequest, error) {
+	if len(datasetIDs) == 0 {
+		return nil, fmt.Errorf("%w: no dataset to query", ErrInvalidRequest)
+	}
+	multiReq := *req
+	multiReq.DatasetID = ""
+	multiReq.DatasetIDs = datasetIDs
+	return &multiReq, nil
+}
+
+// ModelTypeUnknown buckets models of a type this version does not know in GetModelsByType.
+const ModelTypeUnknown domain.ModelType = "unknown"
+
+// knownModelTypes are the model types GetModelsByType keeps as they are.
+var knownModelTypes = []domain.ModelType{
+	domain.ModelTypeChat, domain.ModelTypeEmbedding, domain.ModelTypeRerank, domain.ModelTypeAnalysis, domain.ModelTypeAnalysisVL,
+}
+
+// groupModelsByType groups models by model type, keeping their order in each group.
+func groupModelsByType(models []*domain.Model) map[domain.ModelType][]*domain.Model {
+	groups := make(map[domain.ModelType][]*domain.Model)
+	for _, model := range models {
+		modelType := model.Type
+		if !slices.Contains(knownModelTypes, modelType) {
+			modelType = ModelTypeUnknown
+		}
+		groups[modelType] = append(groups[modelType], model)
+	}
+	return groups
+}
+
+// datasetIDs returns DatasetID and DatasetIDs without duplicates.
+func (req *QueryRecordsRequest) datasetIDs() []string {
+	ids := make([]string, 0, len(req.DatasetIDs)+1)
+	if req.DatasetID != "" {
+		ids = append(ids, req.DatasetID)
+	}
+	for _, id := range req.DatasetIDs {
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		ids = append(ids, req.DatasetID)
+	}
+	return ids
 }
 
+const (
+	// queryDatasetWorkers bounds the concurrent retrieves of a multi-dataset query
+	queryDatasetWorkers = 4
+	defaultTopK         = 10
+	// MaxRetrieveResults caps how deep paging can go and what QueryResult.Total can count
+	MaxRetrieveResults = 100
+)
+
+type QueryResult struct {
+	// Query is the same as RewrittenQuery, kept for existing callers.
+	//
+	// Deprecated: use RewrittenQuery.
+	Query string
+	// OriginalQuery is QueryRecordsRequest.Query as sent by the caller
+	OriginalQuery string
+	// RewrittenQuery is the query used for retrieval, possibly rewritten from the chat history
+	RewrittenQuery string
+	// ExpansionTerms are the terms the rewrite added on top of OriginalQuery
+	ExpansionTerms []string
+	// SubQueries are the distinct queries the provider retrieved with, several datasets
+	// can rewrite the history differently. Empty when the query was not rewritten.
+	SubQueries []string
+	// DryRunPayload holds the provider specific requests of a DryRun query, for CTRAG a
+	// []raglite.RetrieveRequest with one request per dataset, or two in hybrid mode
+	DryRunPayload any
+	Chunks        []*domain.NodeContentChunk
+	// Total counts matches above the similarity threshold, up to MaxRetrieveResults
+	Total int
+	// Stats describes the retrieval, nil when the provider does not report it
+	Stats *RetrievalStats
+}
+
+// newQueryResult fills the query fields of a QueryResult from the original and rewritten query.
+func newQueryResult(original, rewritten string) *QueryResult {
+	return &QueryResult{
+		Query:          rewritten,
+		OriginalQuery:  original,
+		RewrittenQuery: rewritten,
+		ExpansionTerms: expansionTerms(original, rewritten),
+		Chunks:         []*domain.NodeContentChunk{},
+	}
+}
+
+// expansionTerms returns the terms of rewritten that are not in original.
+func expansionTerms(original, rewritten string) []string {
+	terms := []string{}
+	originalTerms := queryTerms(original)
+	for _, term := range queryTerms(rewritten) {
+		if !slices.Contains(originalTerms, term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+type ContentType string
+
+const (
+	// ContentTypeAuto detects html by heuristics, it is the default
+	ContentTypeAuto     ContentType = "auto"
+	ContentTypeHTML     ContentType = "html"
+	ContentTypeMarkdown ContentType = "markdown"
+)
+
 type UpsertRecordsRequest struct {
 	ID        string
 	DatasetID string
 	DocID     string
 	Title     string
 	Content   string
-	GroupIDs  []int
-	Tags      []string
+	// ContentType forces html conversion or raw markdown passthrough, empty means auto
+	ContentType ContentType
+	GroupIDs    []int
+	Tags        []string
+	// ParentDocID and Anchor link an attachment document to the document that references it
+	ParentDocID string
+	Anchor      string
+	// URL is where the document is read, returned with retrieved chunks for citations
+	URL string
+	// UpdatedAt is stored for RecencyBoost, zero means now
+	UpdatedAt time.Time
+	// Force uploads even if the document already has the same content hash
+	Force bool
+	// New skips looking up the document stored under DocID, for first imports where there is
+	// none. Without the lookup unchanged content is not skipped and stale parts are not deleted.
+	New bool
+	// SplitOversized uploads content over the provider size limit as several documents
+	// sharing DocID as base, instead of failing with ErrDocumentTooLarge
+	SplitOversized bool
+	// Filename is the uploaded file name, its extension picks the provider parser.
+	// Empty means the sanitized Title with a short ID suffix, or "<ID>.md" without a title,
+	// see uploadExtensions for the allowed extensions.
+	// It is not part of the content hash, re-parsing unchanged content needs Force.
+	Filename string
+	// ChunkSize in bytes cuts the markdown into chunks uploaded as parts of the document,
+	// raglite takes no chunking parameters. 0 means rag.chunking.size, which 0 leaves to raglite.
+	// Chunks larger than the raglite chunk size are cut again by raglite.
+	ChunkSize int
+	// ChunkOverlap 0 means rag.chunking.overlap, negative means no overlap
+	ChunkOverlap int
+	// ChunkStrategy empty means rag.chunking.strategy, then ChunkStrategyMarkdownHeading
+	ChunkStrategy ChunkStrategy
+	// Metadata is stored with the document for MetadataFilters and citations,
+	// the keys of managedMetadataKeys are set from the fields above and rejected here
+	Metadata map[string]any
+}
+
+// uploadExtensions are the file extensions raglite parses as text.
+var uploadExtensions = []string{".md", ".markdown", ".txt", ".json", ".csv", ".html", ".htm"}
+
+// maxTitleFilenameLength caps the runes of a title used as file name
+const maxTitleFilenameLength = 100
+
+// uploadFilename returns the file name to upload req as.
+func uploadFilename(req *UpsertRecordsRequest) (string, error) {
+	if req.Filename == "" {
+		title := sanitizeFilename(req.Title)
+		if title == "" {
+			return fmt.Sprintf("%s.md", req.ID), nil
+		}
+		// titles are not unique, the ID prefix tells apart documents sharing one
+		return fmt.Sprintf("%s_%s.md", title, req.ID[:min(8, len(req.ID))]), nil
+	}
+	if strings.ContainsAny(req.Filename, `/\`) {
+		return "", fmt.Errorf("%w: filename must not contain a path: %s", ErrInvalidRequest, req.Filename)
+	}
+	if !slices.Contains(uploadExtensions, strings.ToLower(path.Ext(req.Filename))) {
+		return "", fmt.Errorf("%w: unsupported file extension of %s, supported: %s", ErrInvalidRequest, req.Filename, strings.Join(uploadExtensions, ", "))
+	}
+	return req.Filename, nil
+}
+
+// sanitizeFilename replaces the characters file systems reject and trims the title
+// to maxTitleFilenameLength runes.
+func sanitizeFilename(title string) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return ' '
+		}
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, title)
+	name = strings.Join(strings.Fields(name), " ")
+	if runes := []rune(name); len(runes) > maxTitleFilenameLength {
+		name = string(runes[:maxTitleFilenameLength])
+	}
+	return strings.Trim(name, ". ")
+}
+
+// partFilename numbers the file name of part i of a split document, keeping its extension.
+func partFilename(filename string, i int) string {
+	ext := path.Ext(filename)
+	return fmt.Sprintf("%s-%d%s", strings.TrimSuffix(filename, ext), i, ext)
+}
+
+type UpsertResult struct {
+	DocID string
+	// Skipped reports the document was already indexed with the same content
+	Skipped bool
+	// Patched reports only group IDs or tags changed, they were updated without uploading again
+	Patched bool
+	// RawHTML reports the html failed to convert to markdown and was uploaded as is
+	RawHTML bool
+	// SkippedParts counts the parts of a split document left as they were, their content did not change
+	SkippedParts int
 }
 
 type DocumentMetadata struct {
-	GroupIDs []int `json:"group_ids"`
+	GroupIDs    []int  `json:"group_ids"`
+	ParentDocID string `json:"parent_doc_id,omitempty"`
+	Anchor      string `json:"anchor,omitempty"`
+	Visibility  string `json:"visibility,omitempty"`
+	Title       string `json:"title,omitempty"`
+	URL         string `json:"url,omitempty"`
+	// BaseDocID and Parts are set on the parts of a split document, Parts only on the base part
+	BaseDocID string `json:"base_doc_id,omitempty"`
+	Parts     int    `json:"parts,omitempty"`
+	// UpdatedAt is RFC 3339
+	UpdatedAt   string `json:"updated_at,omitempty"`
+	ContentHash string `json:"content_hash,omitempty"`
+	// PartHash lets an upsert skip the parts of a split document that did not change
+	PartHash string `json:"part_hash,omitempty"`
+	// Lang is detected from the markdown at upsert, see detectLanguage
+	Lang     string `json:"lang,omitempty"`
+	Archived bool   `json:"archived,omitempty"`
+	// Chunking is the strategy/size/overlap a document was chunked with before upload, empty
+	// when raglite chunked it
+	Chunking string `json:"chunking,omitempty"`
+	// Custom is the UpsertRecordsRequest.Metadata the document was upserted with
+	Custom map[string]any `json:"custom,omitempty"`
+}
+
+// DocumentPatch changes the metadata of a document without uploading it again.
+// Fields left nil are not touched.
+type DocumentPatch struct {
+	// GroupIDs pointing at an empty slice sets no groups, pointing at a nil slice lifts the group
+	// restriction
+	GroupIDs *[]int
+	// Tags pointing at an empty slice clears the tags
+	Tags  *[]string
+	Title *string
+	// Metadata sets these custom metadata keys, other custom keys are kept
+	Metadata map[string]any
 }
 
+func (p DocumentPatch) empty() bool {
+	return p.GroupIDs == nil && p.Tags == nil && p.Title == nil && len(p.Metadata) == 0
+}
+
+// DocumentStatusSucceeded is the Document.Status of a document parsed and indexed without error.
+const DocumentStatusSucceeded = "SUCCEEDED"
+
+// Document is a document as listed by the provider, Status and ProgressMsg report its parsing.
 type Document struct {
 	ID          string           `json:"id"`
 	Name        string           `json:"name"`
@@ -44,28 +359,398 @@ type Document struct {
 	ProgressMsg string           `json:"progress_msg"`
 	MetaData    DocumentMetadata `json:"meta_data"`
 	Tags        []string         `json:"tags"`
+	// CreatedAt and UpdatedAt are zero when the provider does not report them, UpdatedAt then
+	// falls back to the update time recorded at upsert
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// MetadataDecodeError is set when the stored metadata does not fit DocumentMetadata,
+	// e.g. group IDs stored as strings. The fields that fit are decoded.
+	MetadataDecodeError string `json:"metadata_decode_error,omitempty"`
+}
+
+// KnowledgeBase is a dataset as listed by the provider.
+type KnowledgeBase struct {
+	ID            string
+	Name          string
+	DocumentCount int
 }
 
 type RAGService interface {
-	CreateKnowledgeBase(ctx context.Context) (string, error)
-	UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (string, error)
-	QueryRecords(ctx context.Context, req *QueryRecordsRequest) (string, []*domain.NodeContentChunk, error)
+	// CreateKnowledgeBase creates a dataset named for operators, a uuid is used when name is empty.
+	// The returned ID is the reference used everywhere else. provider names a rag.instances entry
+	// to create it in, empty is the default provider.
+	CreateKnowledgeBase(ctx context.Context, name string, provider string) (string, error)
+	UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error)
+	// BatchUpsertRecords upserts several documents concurrently, results are in the order of reqs.
+	// When some fail their results are zero and the error is a *BatchUpsertError naming them.
+	BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) ([]UpsertResult, error)
+	// UpsertFile uploads a pdf, docx or text file and returns its doc ID. Providers that only
+	// take text get the text extracted here.
+	UpsertFile(ctx context.Context, req *UpsertFileRequest) (string, error)
+	QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error)
+	// QueryRecordsMulti queries datasetIDs in one ranking, the datasets are retrieved concurrently and
+	// their chunks merged by score before Limit applies, each chunk carries its DatasetID.
+	// It is QueryRecords with req.DatasetIDs set, req is not modified.
+	QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error)
+	// QueryRecordsStream sends the chunks of QueryRecords in order, as early as the provider can.
+	// The error channel gets at most one error, both channels are closed when the query ends.
+	QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error)
 	DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error
+	// DeleteAllDocuments deletes every document of a dataset and keeps the dataset, an empty dataset is fine
+	DeleteAllDocuments(ctx context.Context, datasetID string) error
 	DeleteKnowledgeBase(ctx context.Context, datasetID string) error
+	// SetKnowledgeBaseOptions changes the settings of a dataset. They are kept in memory by the provider
+	// and must be set again after a restart, rag.group_filter_datasets is the durable alternative.
+	SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) error
+	// UpdateDocumentGroupIDs replaces the group IDs of a document, nil group IDs clear the restriction
+	// and an empty slice restricts it to no group
 	UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error
+	// UpdateDocumentPermissions sets group IDs and visibility together, nil group IDs clear the restriction
+	UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error
+	// UpdateDocumentTags replaces the tags of a document, an empty slice clears them and nil leaves them unchanged
+	UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error
+	// UpdateDocumentMetadata sets group IDs and tags in a single update. For both, nil leaves the
+	// field unchanged and an empty slice sets it empty, use UpdateDocumentGroupIDs to lift a
+	// group restriction.
+	UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) error
+	// UpdateDocument applies patch in a single update, fields left nil are not touched
+	UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) error
+	// ArchiveDocuments hides documents from retrieval without deleting them, RestoreDocuments undoes it.
+	// Uploading an archived document again restores it.
+	ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) error
+	RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) error
 	ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error)
+	CountDocuments(ctx context.Context, datasetID string) (int, error)
+	// CountDocumentsByGroup counts the documents of a dataset each group ID can see, documents
+	// without group IDs under UnrestrictedGroupID. The parts of a split document count once and
+	// archived documents are left out.
+	CountDocumentsByGroup(ctx context.Context, datasetID string) (map[int]int, error)
+	// BrowseRecords lists the most recently updated documents matching the filters, for browsing without a query
+	BrowseRecords(ctx context.Context, req *BrowseRecordsRequest) ([]BrowseEntry, error)
+	DatasetExists(ctx context.Context, datasetID string) (bool, error)
+	// GetDocument returns ErrDocumentNotFound when the document does not exist
+	GetDocument(ctx context.Context, datasetID string, docID string) (*Document, error)
+	// GetDocumentContent returns the stored markdown of a document, parts of a split document are joined.
+	// Providers that can't read documents back, such as CTRAG, return ErrNotImplemented.
+	GetDocumentContent(ctx context.Context, datasetID string, docID string) (string, error)
+	// GetDocumentChunks returns the chunks the provider indexed for a document in document order,
+	// a missing document is ErrDocumentNotFound. See Capabilities.SupportsDocumentChunks.
+	GetDocumentChunks(ctx context.Context, datasetID string, docID string) ([]*domain.NodeContentChunk, error)
+	// GetKeywords extracts the topic keywords of text, most frequent first and without duplicates
+	GetKeywords(ctx context.Context, datasetID string, text string) ([]string, error)
+	// Ping checks that the backend is reachable, for readiness probes
+	Ping(ctx context.Context) error
+	// Capabilities reports the request features the provider honors
+	Capabilities() Capabilities
+	// ListKnowledgeBases lists every dataset of the provider, including those no knowledge base refers to
+	ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error)
 
 	GetModelList(ctx context.Context) ([]*domain.Model, error)
+	// GetModelsByType groups GetModelList by model type, keeping the order of the list in each group.
+	// Models of other or empty types are grouped under ModelTypeUnknown rather than dropped.
+	GetModelsByType(ctx context.Context) (map[domain.ModelType][]*domain.Model, error)
+	GetModel(ctx context.Context, id string) (*domain.Model, error)
 	AddModel(ctx context.Context, model *domain.Model) (string, error)
 	UpdateModel(ctx context.Context, model *domain.Model) error
 	UpsertModel(ctx context.Context, model *domain.Model) error
 	DeleteModel(ctx context.Context, model *domain.Model) error
 }
 
+// sortChunksByScore orders chunks by descending score, keeping the provider order on ties.
+// In determinism mode ties are broken by doc ID and then chunk ID instead.
+func sortChunksByScore(chunks []*domain.NodeContentChunk, deterministic bool) {
+	sort.SliceStable(chunks, func(i, j int) bool {
+		if chunks[i].Score != chunks[j].Score {
+			return chunks[i].Score > chunks[j].Score
+		}
+		if !deterministic {
+			return false
+		}
+		if chunks[i].DocID != chunks[j].DocID {
+			return chunks[i].DocID < chunks[j].DocID
+		}
+		return chunks[i].ID < chunks[j].ID
+	})
+}
+
+// retrieveTopK returns how many results to fetch for a request, paging fetches up to the cap.
+func retrieveTopK(req *QueryRecordsRequest) (int, error) {
+	if req.Offset < 0 || req.Limit < 0 {
+		return 0, fmt.Errorf("%w: offset and limit must not be negative", ErrInvalidRequest)
+	}
+	if req.Offset == 0 && req.Limit == 0 {
+		return defaultTopK, nil
+	}
+	if req.Offset+pageLimit(req) > MaxRetrieveResults {
+		return 0, fmt.Errorf("%w: offset plus limit must not exceed %d", ErrInvalidRequest, MaxRetrieveResults)
+	}
+	return MaxRetrieveResults, nil
+}
+
+func pageLimit(req *QueryRecordsRequest) int {
+	if req.Limit == 0 {
+		return defaultTopK
+	}
+	return req.Limit
+}
+
+// maxHistoryTurns bounds how much conversation is sent along for query rewriting.
+const maxHistoryTurns = 5
+
+// historyWindow returns the messages of the last maxHistoryTurns user turns, none if rewriting is disabled.
+func historyWindow(req *QueryRecordsRequest) []*schema.Message {
+	if req.DisableQueryRewrite {
+		return nil
+	}
+	turns := 0
+	for i := len(req.HistoryMsgs) - 1; i >= 0; i-- {
+		if req.HistoryMsgs[i].Role != schema.User {
+			continue
+		}
+		turns++
+		if turns == maxHistoryTurns {
+			return req.HistoryMsgs[i:]
+		}
+	}
+	return req.HistoryMsgs
+}
+
+// excludeOverfetch returns how many extra candidates to fetch so excluded documents and dropped
+// duplicates do not shrink the result. How many documents carry an excluded tag, miss one of
+// all required tags, fall outside the date range or how many chunks are duplicates is unknown,
+// so each fetches topK more.
+func excludeOverfetch(req *QueryRecordsRequest, topK int) int {
+	extra := 0
+	if len(req.ExcludeTags) > 0 {
+		extra += topK
+	}
+	if req.Dedup {
+		extra += topK
+	}
+	if req.TagMatchMode == TagMatchAll && len(req.Tags) > 1 {
+		extra += topK
+	}
+	if req.Language != "" {
+		extra += topK
+	}
+	if req.hasDateRange() {
+		extra += topK
+	}
+	switch {
+	case len(req.ExcludeDocIDs) == 0:
+	case req.MaxChunksPerDoc > 0:
+		extra += len(req.ExcludeDocIDs) * req.MaxChunksPerDoc
+	default:
+		extra += topK
+	}
+	return extra
+}
+
+// excludeDocs drops chunks of the given documents, keeping the order of the rest.
+func excludeDocs(chunks []*domain.NodeContentChunk, docIDs []string) []*domain.NodeContentChunk {
+	if len(docIDs) == 0 {
+		return chunks
+	}
+	excluded := make(map[string]struct{}, len(docIDs))
+	for _, id := range docIDs {
+		excluded[id] = struct{}{}
+	}
+	kept := make([]*domain.NodeContentChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		if _, ok := excluded[chunk.DocID]; !ok {
+			kept = append(kept, chunk)
+		}
+	}
+	return kept
+}
+
+// pageChunks slices the requested page out of ranked chunks.
+func pageChunks(chunks []*domain.NodeContentChunk, req *QueryRecordsRequest) []*domain.NodeContentChunk {
+	if req.Offset >= len(chunks) {
+		return []*domain.NodeContentChunk{}
+	}
+	end := min(req.Offset+pageLimit(req), len(chunks))
+	return chunks[req.Offset:end]
+}
+
+// reservedMetadataKeys are metadata keys set from dedicated request fields, callers cannot filter on them directly.
+var reservedMetadataKeys = []string{"group_ids", "archived"}
+
+// managedMetadataKeys are the document metadata keys set by the provider code,
+// the other keys are custom metadata of the upsert.
+var managedMetadataKeys = []string{
+	"group_ids", "archived", "visibility", "parent_doc_id", "anchor", "title", "url", "updated_at",
+	"content_hash", "part_hash", "lang", "base_doc_id", "parts", "chunking", "raw_html",
+}
+
+// normalizeGroupIDs drops duplicate group IDs and rejects negative ones, so retrieval filters
+// match the stored metadata. nil, meaning unchanged, stays nil.
+func normalizeGroupIDs(groupIDs []int) ([]int, error) {
+	if groupIDs == nil {
+		return nil, nil
+	}
+	normalized := make([]int, 0, len(groupIDs))
+	for _, id := range groupIDs {
+		if id < 0 {
+			return nil, fmt.Errorf("%w: invalid group id %d", ErrInvalidRequest, id)
+		}
+		if !slices.Contains(normalized, id) {
+			normalized = append(normalized, id)
+		}
+	}
+	return normalized, nil
+}
+
+func validateCustomMetadata(metadata map[string]any) error {
+	for key, value := range metadata {
+		if key == "" || slices.Contains(managedMetadataKeys, key) {
+			return fmt.Errorf("%w: metadata key %q is reserved", ErrInvalidRequest, key)
+		}
+		if err := validateMetadataValue(value); err != nil {
+			return fmt.Errorf("%w: metadata %q: %v", ErrInvalidRequest, key, err)
+		}
+	}
+	return nil
+}
+
+// customMetadata returns the keys of raw document metadata that are not managed, nil if there are none.
+func customMetadata(raw map[string]interface{}) map[string]any {
+	var custom map[string]any
+	for key, value := range raw {
+		if slices.Contains(managedMetadataKeys, key) {
+			continue
+		}
+		if custom == nil {
+			custom = make(map[string]any)
+		}
+		custom[key] = value
+	}
+	return custom
+}
+
+func validateMetadataFilters(filters map[string]any) error {
+	for key, value := range filters {
+		if slices.Contains(reservedMetadataKeys, key) {
+			return fmt.Errorf("%w: metadata filter key %q is reserved", ErrInvalidRequest, key)
+		}
+		if err := validateMetadataValue(value); err != nil {
+			return fmt.Errorf("%w: metadata filter %q: %v", ErrInvalidRequest, key, err)
+		}
+	}
+	return nil
+}
+
+func validateMetadataValue(value any) error {
+	switch v := value.(type) {
+	case nil:
+		return fmt.Errorf("value must not be nil")
+	case float64:
+		if math.IsNaN(v) {
+			return fmt.Errorf("value must not be NaN")
+		}
+	case float32:
+		if math.IsNaN(float64(v)) {
+			return fmt.Errorf("value must not be NaN")
+		}
+	case []any:
+		for _, item := range v {
+			if err := validateMetadataValue(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// NoSimilarityThreshold as QueryRecordsRequest.SimilarityThreshold returns matches of any score.
+const NoSimilarityThreshold = -1
+
+func validateSimilarityThreshold(threshold float64) error {
+	if math.IsNaN(threshold) || threshold < 0 || threshold > 1 {
+		return fmt.Errorf("%w: similarity threshold must be between 0 and 1, got %v", ErrInvalidRequest, threshold)
+	}
+	return nil
+}
+
 func NewRAGService(config *config.Config, logger *log.Logger) (RAGService, error) {
+	service, err := newFallbackService(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.RAG.Instances) > 0 {
+		instances := make(map[string]RAGService, len(config.RAG.Instances))
+		for name, instance := range config.RAG.Instances {
+			if name == "" || strings.Contains(name, datasetProviderSep) {
+				return nil, fmt.Errorf("invalid rag provider instance name: %q", name)
+			}
+			instances[name], err = NewRAGService(subConfig(config, instance), logger)
+			if err != nil {
+				return nil, fmt.Errorf("create rag provider instance %s failed: %w", name, err)
+			}
+		}
+		service = NewRouterRAG(service, instances, logger)
+	}
+	if target := config.RAG.Migration.Target; target != nil {
+		targetService, err := NewRAGService(subConfig(config, *target), logger)
+		if err != nil {
+			return nil, fmt.Errorf("create migration target rag provider failed: %w", err)
+		}
+		service = NewMigratingRAG(service, targetService, config.RAG.Migration.Cutover, logger)
+	}
+	if config.RAG.QueryCache.TTL > 0 && !IsDisabled(service) {
+		service = NewCachedRAG(service, config.RAG.QueryCache.TTL, config.RAG.QueryCache.MaxEntries, logger)
+	}
+	return service, nil
+}
+
+func newFallbackService(config *config.Config, logger *log.Logger) (RAGService, error) {
+	primary, err := newProvider(config, logger)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.RAG.Fallbacks) == 0 {
+		return primary, nil
+	}
+	providers := []namedRAG{{name: config.RAG.Provider, service: primary}}
+	for i, fallback := range config.RAG.Fallbacks {
+		service, err := newProvider(subConfig(config, fallback), logger)
+		if err != nil {
+			return nil, fmt.Errorf("create fallback rag provider %d failed: %w", i, err)
+		}
+		providers = append(providers, namedRAG{
+			name:    fmt.Sprintf("%s#%d", fallback.Provider, i+1),
+			service: service,
+		})
+	}
+	return NewFallbackRAG(providers, config.RAG.FallbackTimeout, logger), nil
+}
+
+// subConfig replaces the RAG section of config, keeping its default similarity threshold and
+// document size limit unless rag sets them.
+func subConfig(config *config.Config, rag config.RAGConfig) *config.Config {
+	sub := *config
+	sub.RAG = rag
+	if sub.RAG.DefaultSimilarityThreshold == 0 {
+		sub.RAG.DefaultSimilarityThreshold = config.RAG.DefaultSimilarityThreshold
+	}
+	if sub.RAG.MaxDocumentBytes == 0 {
+		sub.RAG.MaxDocumentBytes = config.RAG.MaxDocumentBytes
+	}
+	return &sub
+}
+
+func newProvider(config *config.Config, logger *log.Logger) (RAGService, error) {
 	switch config.RAG.Provider {
 	case "ct":
-		return NewCTRAG(config, logger)
+		var opts []CTRAGOption
+		if config.GetBool("apm.enabled") {
+			opts = append(opts, WithTracer(otel.Tracer(tracerName)))
+		}
+		return NewCTRAG(config, logger, opts...)
+	case "disabled":
+		return NewDisabledRAG(config, logger)
+	case "local":
+		return NewLocalRAG(config, logger)
 	default:
 		return nil, fmt.Errorf("unsupported vector provider: %s", config.RAG.Provider)
 	}
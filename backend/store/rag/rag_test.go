@@ -0,0 +1,1100 @@
+package rag
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	raglite "github.com/chaitin/raglite-go-sdk"
+	"github.com/cloudwego/eino/schema"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+func chunkIDs(chunks []*domain.NodeContentChunk) []string {
+	ids := make([]string, len(chunks))
+	for i, c := range chunks {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+func TestSortChunksByScore(t *testing.T) {
+	input := func() []*domain.NodeContentChunk {
+		return []*domain.NodeContentChunk{
+			{ID: "3", DocID: "b", Score: 0.5},
+			{ID: "1", DocID: "a", Score: 0.9},
+			{ID: "2", DocID: "a", Score: 0.5},
+			{ID: "4", DocID: "c", Score: 0.7},
+		}
+	}
+
+	chunks := input()
+	sortChunksByScore(chunks, false)
+	assert.Equal(t, []string{"1", "4", "3", "2"}, chunkIDs(chunks))
+
+	chunks = input()
+	sortChunksByScore(chunks, true)
+	assert.Equal(t, []string{"1", "4", "2", "3"}, chunkIDs(chunks))
+}
+
+func TestPageChunks(t *testing.T) {
+	chunks := make([]*domain.NodeContentChunk, 25)
+	for i := range chunks {
+		chunks[i] = &domain.NodeContentChunk{ID: string(rune('a' + i))}
+	}
+
+	topK, err := retrieveTopK(&QueryRecordsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, defaultTopK, topK)
+	assert.Len(t, pageChunks(chunks, &QueryRecordsRequest{}), defaultTopK)
+
+	req := &QueryRecordsRequest{Offset: 20, Limit: 10}
+	topK, err = retrieveTopK(req)
+	require.NoError(t, err)
+	assert.Equal(t, MaxRetrieveResults, topK)
+	assert.Equal(t, []string{"u", "v", "w", "x", "y"}, chunkIDs(pageChunks(chunks, req)))
+
+	assert.Empty(t, pageChunks(chunks, &QueryRecordsRequest{Offset: 30, Limit: 10}))
+
+	_, err = retrieveTopK(&QueryRecordsRequest{Offset: MaxRetrieveResults, Limit: 1})
+	assert.Error(t, err)
+	_, err = retrieveTopK(&QueryRecordsRequest{Limit: -1})
+	assert.Error(t, err)
+}
+
+func TestFuseRRF(t *testing.T) {
+	vector := []*domain.NodeContentChunk{{ID: "a"}, {ID: "b"}, {ID: "c"}}
+	keyword := []*domain.NodeContentChunk{{ID: "d"}, {ID: "c"}}
+	assert.Equal(t, []string{"c", "a", "d", "b"}, chunkIDs(fuseRRF(vector, keyword)))
+	assert.Empty(t, fuseRRF(nil, nil))
+}
+
+func TestRerankChunks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req rerankRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "/v1/rerank", r.URL.Path)
+		assert.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		assert.Equal(t, []string{"a", "b", "c"}, req.Documents)
+		assert.Equal(t, 2, req.TopN)
+		_, _ = w.Write([]byte(`{"results":[{"index":2,"relevance_score":0.9},{"index":0,"relevance_score":0.4}]}`))
+	}))
+	defer srv.Close()
+
+	model := &domain.Model{Model: "bge-reranker-v2-m3", BaseURL: srv.URL + "/v1", APIKey: "key"}
+	chunks := []*domain.NodeContentChunk{
+		{ID: "1", Content: "a", Score: 0.8},
+		{ID: "2", Content: "b", Score: 0.7},
+		{ID: "3", Content: "c", Score: 0.6},
+	}
+	reranked, err := rerankChunks(context.Background(), model, "q", chunks, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3", "1"}, chunkIDs(reranked))
+	assert.Equal(t, 0.9, reranked[0].Score)
+	assert.Equal(t, 0.8, chunks[0].Score)
+}
+
+func TestRerankFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	s := &CTRAG{metrics: noopMetrics{}, logger: log.NewLogger(&config.Config{})}
+	model := &domain.Model{Model: "bge-reranker-v2-m3", BaseURL: srv.URL + "/v1"}
+	chunks := []*domain.NodeContentChunk{{ID: "1", Content: "a"}, {ID: "2", Content: "b"}, {ID: "3", Content: "c"}}
+	reranked, err := s.rerankWith(context.Background(), model, "q", chunks, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, chunkIDs(reranked))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.rerankWith(ctx, model, "q", chunks, 2)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRerankStrict(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	s := &CTRAG{metrics: noopMetrics{}, logger: log.NewLogger(&config.Config{}), rerankStrict: true}
+	model := &domain.Model{Model: "bge-reranker-v2-m3", BaseURL: srv.URL + "/v1"}
+	chunks := []*domain.NodeContentChunk{{ID: "1", Content: "a"}, {ID: "2", Content: "b"}}
+	_, err := s.rerankWith(context.Background(), model, "q", chunks, 2)
+	assert.ErrorIs(t, err, ErrUnavailable)
+}
+
+// TestRerankReleasesLimiterSlot checks the rerank call to the model endpoint runs without a raglite slot.
+func TestRerankReleasesLimiterSlot(t *testing.T) {
+	var service *CTRAG
+	models := `[]`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/models":
+			_, _ = w.Write([]byte(`{"success":true,"data":{"models":` + models + `}}`))
+		case "/v1/rerank":
+			ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+			defer cancel()
+			release, err := service.limiter.acquire(ctx)
+			if assert.NoError(t, err, "the rerank call holds the only slot") {
+				release()
+			}
+			_, _ = w.Write([]byte(`{"results":[{"index":1,"relevance_score":0.9},{"index":0,"relevance_score":0.1}]}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{}
+	cfg.RAG.CTRAG.BaseURL = srv.URL
+	cfg.RAG.CTRAG.MaxConcurrency = 1
+	var err error
+	service, err = NewCTRAG(cfg, log.NewLogger(cfg))
+	require.NoError(t, err)
+	chunks := []*domain.NodeContentChunk{{ID: "1", Content: "a"}, {ID: "2", Content: "b"}}
+
+	// without a rerank model the retrieval order is kept, unless rerank is strict
+	reranked, err := service.rerank(context.Background(), "q", chunks, 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, chunkIDs(reranked))
+	service.rerankStrict = true
+	_, err = service.rerank(context.Background(), "q", chunks, 2, "")
+	assert.ErrorIs(t, err, ErrModelNotFound)
+
+	models = `[{"id":"m1","name":"bge-reranker-v2-m3","model_type":"rerank","config":{"api_base":"` + srv.URL + `/v1"}}]`
+	reranked, err = service.rerank(context.Background(), "q", chunks, 2, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"2", "1"}, chunkIDs(reranked))
+	assert.Equal(t, int64(0), service.limiter.stats()[PriorityInteractive].InFlight)
+}
+
+func TestValidateMetadataFilters(t *testing.T) {
+	assert.NoError(t, validateMetadataFilters(nil))
+	assert.NoError(t, validateMetadataFilters(map[string]any{"space": "docs", "version": []any{"1.0", 2.0}}))
+	assert.ErrorIs(t, validateMetadataFilters(map[string]any{"group_ids": []int{1}}), ErrInvalidRequest)
+	assert.ErrorIs(t, validateMetadataFilters(map[string]any{"space": nil}), ErrInvalidRequest)
+	assert.ErrorIs(t, validateMetadataFilters(map[string]any{"score": math.NaN()}), ErrInvalidRequest)
+	assert.ErrorIs(t, validateMetadataFilters(map[string]any{"versions": []any{1.0, math.NaN()}}), ErrInvalidRequest)
+}
+
+func TestExcludeDocs(t *testing.T) {
+	chunks := []*domain.NodeContentChunk{
+		{ID: "1", DocID: "self"},
+		{ID: "2", DocID: "a"},
+		{ID: "3", DocID: "self"},
+		{ID: "4", DocID: "b"},
+	}
+	assert.Equal(t, []string{"2", "4"}, chunkIDs(excludeDocs(chunks, []string{"self"})))
+	assert.Len(t, excludeDocs(chunks, nil), 4)
+
+	assert.Equal(t, 0, excludeOverfetch(&QueryRecordsRequest{}, 10))
+	assert.Equal(t, 10, excludeOverfetch(&QueryRecordsRequest{ExcludeDocIDs: []string{"self"}}, 10))
+	assert.Equal(t, 6, excludeOverfetch(&QueryRecordsRequest{ExcludeDocIDs: []string{"a", "b"}, MaxChunksPerDoc: 3}, 10))
+	assert.Equal(t, 16, excludeOverfetch(&QueryRecordsRequest{ExcludeTags: []string{"internal"}, ExcludeDocIDs: []string{"a", "b"}, MaxChunksPerDoc: 3}, 10))
+}
+
+func TestSelectMMR(t *testing.T) {
+	chunks := []*domain.NodeContentChunk{
+		{ID: "1", DocID: "a", Content: "install the agent on linux", Score: 0.9},
+		{ID: "2", DocID: "a", Content: "install the agent on linux hosts", Score: 0.88},
+		{ID: "3", DocID: "b", Content: "configure proxy settings", Score: 0.7},
+	}
+	assert.Equal(t, []string{"1", "2"}, chunkIDs(selectMMR(chunks, 2, 1)))
+	assert.Equal(t, []string{"1", "3"}, chunkIDs(selectMMR(chunks, 2, 0.5)))
+	assert.Len(t, selectMMR(chunks, 10, 0.5), 3)
+
+	assert.Equal(t, []string{"1", "3"}, chunkIDs(capChunksPerDoc(chunks, 1)))
+	assert.ErrorIs(t, validateMMRLambda(math.NaN()), ErrInvalidRequest)
+	assert.ErrorIs(t, validateMMRLambda(1.5), ErrInvalidRequest)
+}
+
+func TestFuseWeightedRRF(t *testing.T) {
+	vector := []*domain.NodeContentChunk{{ID: "v1"}, {ID: "v2"}}
+	keyword := []*domain.NodeContentChunk{{ID: "k1"}, {ID: "k2"}}
+	assert.Equal(t, []string{"v1", "k1", "v2", "k2"}, chunkIDs(fuseWeightedRRF(hybridWeights(0), vector, keyword)))
+	assert.Equal(t, []string{"k1", "k2", "v1", "v2"}, chunkIDs(fuseWeightedRRF(hybridWeights(0.2), vector, keyword)))
+	assert.ErrorIs(t, validateHybridAlpha(-0.1), ErrInvalidRequest)
+}
+
+func TestDocCache(t *testing.T) {
+	c := newDocCache()
+	c.put("ds", []Document{{ID: "a"}, {ID: "b"}})
+	c.put("other", []Document{{ID: "a"}})
+
+	found, missing := c.get("ds", []string{"a", "b", "c"})
+	assert.Len(t, found, 2)
+	assert.Equal(t, []string{"c"}, missing)
+
+	c.invalidate("ds", "a")
+	_, missing = c.get("ds", []string{"a", "b"})
+	assert.Equal(t, []string{"a"}, missing)
+
+	c.invalidate("ds")
+	_, missing = c.get("ds", []string{"b"})
+	assert.Equal(t, []string{"b"}, missing)
+	found, _ = c.get("other", []string{"a"})
+	assert.Len(t, found, 1)
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	content := "PandaWiki is a wiki. Error E1024 means the <disk> is full! Restart after cleanup."
+	assert.Equal(t, "Error <em>E1024</em> means the &lt;disk&gt; <em>is</em> full! Restart after cleanup.", highlightSnippet(content, "what is e1024"))
+
+	assert.Equal(t, "如何<em>配置代理</em>。", highlightSnippet("安装步骤。如何配置代理。", "配置代理"))
+	assert.Equal(t, "", highlightSnippet("", "query"))
+}
+
+func TestSplitMarkdown(t *testing.T) {
+	markdown := "# Intro\nshort text\n\n## Code\n```go\nfunc a() {}\n\nfunc b() {}\n```\n\n## End\nbye\n"
+	assert.Equal(t, []string{markdown}, splitMarkdown(markdown, len(markdown)))
+
+	parts := splitMarkdown(markdown, 48)
+	assert.Equal(t, markdown, strings.Join(parts, ""))
+	for _, part := range parts {
+		assert.LessOrEqual(t, len(part), 48)
+	}
+	// the code block is not cut
+	assert.Contains(t, parts, "## Code\n```go\nfunc a() {}\n\nfunc b() {}\n```\n\n")
+
+	for _, part := range splitMarkdown("```\nline one\nline two\nline three\n```\n", 24) {
+		assert.True(t, strings.HasPrefix(part, "```\n") && strings.HasSuffix(part, "```\n"), part)
+		assert.LessOrEqual(t, len(part), 24)
+	}
+
+	assert.Equal(t, "base", PartDocID("base", 0))
+}
+
+func TestMaxDocumentBytes(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.RAG.CTRAG.BaseURL = "http://raglite.invalid"
+	cfg.RAG.MaxDocumentBytes = 1 << 20
+	s, err := NewCTRAG(cfg, log.NewLogger(cfg))
+	require.NoError(t, err)
+	assert.Equal(t, 1<<20, s.maxContentSize)
+
+	cfg.RAG.CTRAG.MaxContentSize = 4096
+	s, err = NewCTRAG(cfg, log.NewLogger(cfg))
+	require.NoError(t, err)
+	assert.Equal(t, 4096, s.maxContentSize)
+
+	assert.Equal(t, 1<<20, subConfig(cfg, config.RAGConfig{Provider: "ct"}).RAG.MaxDocumentBytes)
+	assert.Equal(t, 512, subConfig(cfg, config.RAGConfig{MaxDocumentBytes: 512}).RAG.MaxDocumentBytes)
+}
+
+// Markdown over rag.max_document_bytes is rejected unless SplitOversized is set, then it is
+// split at block boundaries into parts within the limit.
+func TestUpsertRecordsOversized(t *testing.T) {
+	s := &CTRAG{tracer: noopTracer(), metrics: noopMetrics{}, maxContentSize: 32}
+	markdown := "# Install\n\nrun the installer\n\n## Configure\n\nedit the config file\n"
+	_, err := s.UpsertRecords(context.Background(), &UpsertRecordsRequest{DatasetID: "ds", DocID: "doc", Content: markdown, ContentType: ContentTypeMarkdown})
+	assert.ErrorIs(t, err, ErrDocumentTooLarge)
+
+	parts := splitMarkdown(markdown, s.maxContentSize)
+	require.Greater(t, len(parts), 1)
+	assert.Equal(t, markdown, strings.Join(parts, ""))
+	for _, part := range parts {
+		assert.LessOrEqual(t, len(part), s.maxContentSize)
+	}
+}
+
+func TestRecencyFactor(t *testing.T) {
+	now := time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
+	assert.InDelta(t, 0.5, recencyFactor("2025-01-01T00:00:00Z", now, 30), 1e-9)
+	assert.InDelta(t, 0.25, recencyFactor("2024-12-02T00:00:00Z", now, 30), 1e-9)
+	assert.Equal(t, 1.0, recencyFactor("", now, 30))
+	assert.Equal(t, 1.0, recencyFactor("2025-02-01T00:00:00Z", now, 30))
+	assert.ErrorIs(t, validateRecencyBoost(-1), ErrInvalidRequest)
+}
+
+func TestUploadHash(t *testing.T) {
+	data := func(groupIDs []int) *raglite.UploadDocumentRequest {
+		return &raglite.UploadDocumentRequest{Title: "doc", Metadata: map[string]interface{}{"group_ids": groupIDs, "url": "/node/1"}}
+	}
+	assert.Equal(t, uploadHash("# doc", data([]int{1})), uploadHash("# doc", data([]int{1})))
+	assert.NotEqual(t, uploadHash("# doc", data([]int{1})), uploadHash("# doc v2", data([]int{1})))
+	assert.NotEqual(t, uploadHash("# doc", data([]int{1})), uploadHash("# doc", data([]int{2})))
+}
+
+func TestHistoryWindow(t *testing.T) {
+	var history []*schema.Message
+	for i := 0; i < 7; i++ {
+		history = append(history, &schema.Message{Role: schema.User, Content: "q"}, &schema.Message{Role: schema.Assistant, Content: "a"})
+	}
+	assert.Len(t, historyWindow(&QueryRecordsRequest{HistoryMsgs: history}), 2*maxHistoryTurns)
+	assert.Len(t, historyWindow(&QueryRecordsRequest{HistoryMsgs: history[:4]}), 4)
+	assert.Empty(t, historyWindow(&QueryRecordsRequest{HistoryMsgs: history, DisableQueryRewrite: true}))
+}
+
+func TestNewQueryResult(t *testing.T) {
+	res := newQueryResult("how to install", "How to install PandaWiki on Linux")
+	assert.Equal(t, "how to install", res.OriginalQuery)
+	assert.Equal(t, res.RewrittenQuery, res.Query)
+	assert.Equal(t, []string{"pandawiki", "on", "linux"}, res.ExpansionTerms)
+	assert.Empty(t, newQueryResult("安装", "安装").ExpansionTerms)
+}
+
+func TestExpandChunks(t *testing.T) {
+	markdown := "# Install\n\nStep 1\n\nStep 2\n\nStep 3\n\nStep 4\n"
+	step2 := &domain.NodeContentChunk{DocID: "doc", Content: "Step 2", Score: 0.5}
+	step3 := &domain.NodeContentChunk{DocID: "doc", Content: "Step 3\n", Score: 0.9}
+	missing := &domain.NodeContentChunk{DocID: "doc", Content: "Step 9"}
+	expanded := expandChunks(markdown, []*domain.NodeContentChunk{step3, step2, missing}, 1)
+	assert.Nil(t, expanded[step2])
+	assert.Equal(t, "Step 1\n\nStep 2\n\nStep 3\n\nStep 4\n", expanded[step3].Content)
+	assert.Equal(t, 0.9, expanded[step3].Score)
+	assert.True(t, expanded[missing] == missing)
+	assert.Equal(t, "Step 3\n", step3.Content)
+}
+
+func TestDatasetCache(t *testing.T) {
+	cache := newDatasetCache(0)
+	checkedAt := time.Now()
+	cache.add("ds", checkedAt)
+	assert.True(t, cache.known("ds"))
+	cache.remove("ds")
+	assert.False(t, cache.known("ds"))
+	// a check that started before the delete must not bring the dataset back
+	cache.add("ds", checkedAt)
+	assert.False(t, cache.known("ds"))
+	cache.add("ds", time.Now().Add(time.Second))
+	assert.True(t, cache.known("ds"))
+
+	disabled := newDatasetCache(-1)
+	disabled.add("ds", time.Now())
+	assert.False(t, disabled.known("ds"))
+}
+
+func TestDedupChunks(t *testing.T) {
+	chunks := []*domain.NodeContentChunk{
+		{ID: "a", DocID: "doc1", Content: "Contact support at support@example.com for help", Score: 0.9},
+		{ID: "b", DocID: "doc2", Content: "contact support at support@example.com for help", Score: 0.8},
+		{ID: "c", DocID: "doc2", Content: "Install the server with docker compose", Score: 0.7},
+	}
+	deduped := dedupChunks(chunks)
+	require.Len(t, deduped, 2)
+	assert.Equal(t, "a", deduped[0].ID)
+	assert.Equal(t, "c", deduped[1].ID)
+}
+
+func TestUploadFilename(t *testing.T) {
+	name, err := uploadFilename(&UpsertRecordsRequest{ID: "node"})
+	require.NoError(t, err)
+	assert.Equal(t, "node.md", name)
+	name, err = uploadFilename(&UpsertRecordsRequest{ID: "3f2a9c1e-node", Title: " API: GET /nodes?\tv2 "})
+	require.NoError(t, err)
+	assert.Equal(t, "API_ GET _nodes_ v2_3f2a9c1e.md", name)
+	name, err = uploadFilename(&UpsertRecordsRequest{ID: "node", Title: "..."})
+	require.NoError(t, err)
+	assert.Equal(t, "node.md", name)
+	name, err = uploadFilename(&UpsertRecordsRequest{ID: "node", Filename: "data.JSON"})
+	require.NoError(t, err)
+	assert.Equal(t, "data.JSON", name)
+	_, err = uploadFilename(&UpsertRecordsRequest{Filename: "report.exe"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	_, err = uploadFilename(&UpsertRecordsRequest{Filename: "../node.md"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	assert.Equal(t, "data-2.json", partFilename("data.json", 2))
+}
+
+func TestRetrieveRequests(t *testing.T) {
+	data := &raglite.RetrieveRequest{Query: "install", TopK: 10}
+	reqs := retrieveRequests([]string{"a", "b"}, data)
+	require.Len(t, reqs, 2)
+	assert.Equal(t, "b", reqs[1].DatasetID)
+	assert.Empty(t, data.DatasetID)
+}
+
+func TestRankByKeywords(t *testing.T) {
+	chunks := []*domain.NodeContentChunk{
+		{ID: "a", Content: "Configure the proxy", Score: 0.9},
+		{ID: "b", Content: "Install the agent behind a proxy", Score: 0.8},
+		{ID: "c", Content: "Release notes", Score: 0.7},
+		{ID: "d", Content: "Agent install guide", Score: 0.6},
+	}
+	ranked := rankByKeywords(chunks, "how to install the agent")
+	assert.Equal(t, []string{"b", "d"}, chunkIDs(ranked))
+	assert.Equal(t, 1.0, ranked[0].Score)
+	assert.Equal(t, 0.9, chunks[0].Score)
+	assert.Nil(t, rankByKeywords(chunks, "how to"))
+}
+
+func TestQueryRecordsSearchModes(t *testing.T) {
+	var retrieves []map[string]any
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/search":
+			var body map[string]any
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			retrieves = append(retrieves, body)
+			_, _ = w.Write([]byte(`{"success":true,"data":{"query":"install agent","results":[
+				{"chunk_id":"c1","document_id":"d1","content":"Configure the proxy","score":0.9},
+				{"chunk_id":"c2","document_id":"d1","content":"Install the agent","score":0.5}]}}`))
+		case "/api/v1/datasets/ds/documents":
+			_, _ = w.Write([]byte(`{"success":true,"data":{"documents":[{"id":"d1","dataset_id":"ds","status":"completed"}],"total":1}}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{}
+	cfg.RAG.CTRAG.BaseURL = srv.URL
+	service, err := NewCTRAG(cfg, log.NewLogger(cfg))
+	require.NoError(t, err)
+	for mode, want := range map[SearchMode][]string{
+		"":                {"c1"},
+		SearchModeVector:  {"c1"},
+		SearchModeKeyword: {"c2"},
+		SearchModeHybrid:  {"c1", "c2"},
+	} {
+		retrieves = nil
+		res, err := service.QueryRecords(context.Background(), &QueryRecordsRequest{
+			DatasetID: "ds", Query: "install agent", SearchMode: mode, SimilarityThreshold: 0.6,
+		})
+		require.NoError(t, err, mode)
+		assert.ElementsMatch(t, want, chunkIDs(res.Chunks), mode)
+		// raglite only knows full and smart retrieval, every mode is one plain retrieve
+		require.Len(t, retrieves, 1, mode)
+		assert.NotContains(t, retrieves[0], "retrieval_mode", mode)
+		assert.NotContains(t, retrieves[0], "similarity_threshold", mode)
+	}
+}
+
+func TestExtractKeywords(t *testing.T) {
+	assert.Equal(t, []string{"docker", "install", "compose"}, extractKeywords("How to install with Docker? Docker compose, docker 2"))
+	assert.Equal(t, []string{"安装", "部署"}, extractKeywords("如何安装？安装和部署"))
+	assert.Empty(t, extractKeywords("the of 1"))
+}
+
+func TestStreamQueryResult(t *testing.T) {
+	query := func(ctx context.Context) (*QueryResult, error) {
+		res := newQueryResult("q", "q")
+		res.Chunks = []*domain.NodeContentChunk{{ID: "a"}, {ID: "b"}}
+		return res, nil
+	}
+	chunks, errs := streamQueryResult(context.Background(), query)
+	var ids []string
+	for chunk := range chunks {
+		ids = append(ids, chunk.ID)
+	}
+	assert.Equal(t, []string{"a", "b"}, ids)
+	assert.NoError(t, <-errs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chunks, errs = streamQueryResult(ctx, query)
+	<-chunks
+	cancel()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	_, ok := <-chunks
+	assert.False(t, ok)
+}
+
+func TestExcludeArchived(t *testing.T) {
+	chunks := []*domain.NodeContentChunk{{ID: "a", DocID: "doc1"}, {ID: "b", DocID: "doc2"}, {ID: "c", DocID: "doc3"}}
+	docs := map[string]Document{
+		"doc1": {ID: "doc1"},
+		"doc2": {ID: "doc2", MetaData: DocumentMetadata{Archived: true}},
+	}
+	kept := excludeArchived(chunks, docs)
+	require.Len(t, kept, 2)
+	assert.Equal(t, "a", kept[0].ID)
+	assert.Equal(t, "c", kept[1].ID)
+}
+
+func TestRequireTags(t *testing.T) {
+	chunks := []*domain.NodeContentChunk{{ID: "a", DocID: "doc1"}, {ID: "b", DocID: "doc2"}, {ID: "c", DocID: "missing"}}
+	docs := map[string]Document{
+		"doc1": {ID: "doc1", Tags: []string{"pro", "v2"}},
+		"doc2": {ID: "doc2", Tags: []string{"pro", "v1"}},
+	}
+	kept := requireTags(chunks, docs, []string{"pro", "v2"})
+	require.Len(t, kept, 1)
+	assert.Equal(t, "a", kept[0].ID)
+	_, err := TagMatchMode("some").resolve()
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestRetrievalCounter(t *testing.T) {
+	var counter retrievalCounter
+	chunks := counter.filterThreshold([]*domain.NodeContentChunk{{ID: "a", Score: 0.9}, {ID: "b", Score: 0.1}, {ID: "c", Score: 0.5}}, 0.5)
+	require.Len(t, chunks, 2)
+	assert.Equal(t, "c", chunks[1].ID)
+	counter.filterThreshold([]*domain.NodeContentChunk{{ID: "d", Score: 0.01}}, 0)
+
+	counter.countMetadataFiltered(2, 1)
+	stats := counter.stats("ct", time.Second)
+	assert.Equal(t, &RetrievalStats{Provider: "ct", Duration: time.Second, Candidates: 4, FilteredByThreshold: 1, FilteredByMetadata: 1}, stats)
+}
+
+func TestCountByGroup(t *testing.T) {
+	docs := []Document{
+		{ID: "a", MetaData: DocumentMetadata{GroupIDs: []int{1, 2, 2}}},
+		{ID: "b-1", MetaData: DocumentMetadata{GroupIDs: []int{2}, BaseDocID: "b"}},
+		{ID: "b-2", MetaData: DocumentMetadata{GroupIDs: []int{2}, BaseDocID: "b"}},
+		{ID: "c"},
+		{ID: "d", MetaData: DocumentMetadata{GroupIDs: []int{}}},
+		{ID: "e", MetaData: DocumentMetadata{GroupIDs: []int{1}, Archived: true}},
+	}
+	assert.Equal(t, map[int]int{1: 1, 2: 2, UnrestrictedGroupID: 1}, countByGroup(docs))
+}
+
+func TestBrowseDocuments(t *testing.T) {
+	docs := []Document{
+		{ID: "old", MetaData: DocumentMetadata{Title: "Old", UpdatedAt: "2024-01-01T00:00:00Z"}, Tags: []string{"faq"}},
+		{ID: "new-1", MetaData: DocumentMetadata{Title: "New", BaseDocID: "new", UpdatedAt: "2024-03-01T00:00:00Z"}, Tags: []string{"faq"}},
+		{ID: "new-2", MetaData: DocumentMetadata{Title: "New", BaseDocID: "new", UpdatedAt: "2024-03-01T00:00:00Z"}, Tags: []string{"faq"}},
+		{ID: "restricted", MetaData: DocumentMetadata{GroupIDs: []int{2}, UpdatedAt: "2024-04-01T00:00:00Z"}, Tags: []string{"faq"}},
+		{ID: "archived", MetaData: DocumentMetadata{Archived: true, UpdatedAt: "2024-05-01T00:00:00Z"}, Tags: []string{"faq"}},
+		{ID: "untagged", MetaData: DocumentMetadata{UpdatedAt: "2024-06-01T00:00:00Z"}},
+	}
+	entries := browseDocuments(docs, &BrowseRecordsRequest{GroupIDs: []int{1}, Tags: []string{"faq"}}, TagMatchAny)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "new", entries[0].DocID)
+	assert.Equal(t, "old", entries[1].DocID)
+
+	entries = browseDocuments(docs, &BrowseRecordsRequest{GroupIDs: []int{2}, Limit: 1}, TagMatchAny)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "untagged", entries[0].DocID)
+}
+
+func TestDetectLanguage(t *testing.T) {
+	assert.Equal(t, LanguageChinese, detectLanguage("# 安装\n\n使用 Docker 安装 PandaWiki，然后打开浏览器。\n\n```bash\ndocker compose up -d --build\n```"))
+	assert.Equal(t, LanguageEnglish, detectLanguage("# Install\n\nRun the installer, then open 控制台 in the browser."))
+	assert.Empty(t, detectLanguage("```\n安装\n```\n1. 2."))
+}
+
+func TestPreferLanguage(t *testing.T) {
+	chunks := []*domain.NodeContentChunk{
+		{ID: "en1", Content: "How to install the server", Metadata: map[string]any{}},
+		{ID: "zh1", Content: "如何安装服务器", Metadata: map[string]any{}},
+		{ID: "en2", Content: "安装", Metadata: map[string]any{"lang": LanguageEnglish}},
+		{ID: "zh2", Content: "Install guide", Metadata: map[string]any{"lang": LanguageChinese}},
+	}
+	ids := func(chunks []*domain.NodeContentChunk) []string {
+		var ids []string
+		for _, chunk := range chunks {
+			ids = append(ids, chunk.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"zh1", "zh2"}, ids(preferLanguage(chunks, LanguageChinese, 2)))
+	assert.Equal(t, []string{"zh1", "zh2", "en1"}, ids(preferLanguage(chunks, LanguageChinese, 3)))
+	assert.Equal(t, []string{"en1", "en2", "zh1"}, ids(preferLanguage(chunks, LanguageEnglish, 3)))
+	assert.Len(t, preferLanguage(chunks, "", 1), 4)
+}
+
+func TestRateLimitedTransport(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newRateLimitedTransport(nil, newRateLimiter(100, 1))}
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("body"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int64(2), requests.Load())
+
+	assert.Nil(t, newRateLimiter(0, 10))
+	assert.Equal(t, 3*time.Second, retryAfter("3", time.Now()))
+	assert.Equal(t, defaultRetryAfter, retryAfter("soon", time.Now()))
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	l := newRateLimiter(10, 2)
+	now := l.last
+	assert.Zero(t, l.reserve(now))
+	assert.Zero(t, l.reserve(now))
+	assert.Equal(t, 100*time.Millisecond, l.reserve(now))
+	assert.Equal(t, 200*time.Millisecond, l.reserve(now))
+}
+
+func TestJoinCJKLines(t *testing.T) {
+	// <p>安装完成后，\n    打开控制台。\nThen\nlog in</p><pre>第一行\n第二行</pre>
+	text := &html.Node{Type: html.TextNode, Data: "安装完成后，\n    打开控制台。\nThen\nlog in"}
+	pre := &html.Node{Type: html.ElementNode, Data: "pre"}
+	preText := &html.Node{Type: html.TextNode, Data: "第一行\n第二行", Parent: pre}
+	pre.FirstChild = preText
+	p := &html.Node{Type: html.ElementNode, Data: "p", FirstChild: text, NextSibling: pre}
+	text.Parent = p
+	doc := &html.Node{Type: html.DocumentNode, FirstChild: p}
+
+	joinCJKLines(nil, doc)
+	assert.Equal(t, "安装完成后，打开控制台。\nThen\nlog in", text.Data)
+	assert.Equal(t, "第一行\n第二行", preText.Data)
+
+	opts, err := HTML2MDOptionsFromConfig(config.RAGMarkdownConfig{Locale: "ZH", DisableEscaping: true, KeepBoilerplate: true})
+	assert.NoError(t, err)
+	assert.Len(t, opts, 2)
+	opts, err = HTML2MDOptionsFromConfig(config.RAGMarkdownConfig{Locale: "ko", KeepBoilerplate: true})
+	assert.NoError(t, err)
+	assert.Empty(t, opts)
+}
+
+func TestRewriteURLs(t *testing.T) {
+	base, _ := url.Parse("https://wiki.example.com/")
+	p := urlPolicy{baseURL: base, nodeURL: "https://wiki.example.com/node/{id}"}
+	for raw, want := range map[string]string{
+		"/static/uploads/a.png":           "https://wiki.example.com/static/uploads/a.png",
+		"uploads/b.png":                   "https://wiki.example.com/uploads/b.png",
+		"//cdn.example.com/c.png":         "https://cdn.example.com/c.png",
+		"http://other.example.com/d.png":  "http://other.example.com/d.png",
+		"data:image/png;base64,iVBORw0KG": "data:image/png;base64,iVBORw0KG",
+		"mailto:ops@example.com":          "mailto:ops@example.com",
+		"#install":                        "#install",
+		"/node/0192ab?tab=1#setup":        "https://wiki.example.com/node/0192ab?tab=1#setup",
+	} {
+		assert.Equal(t, want, p.rewrite(raw), raw)
+	}
+	assert.Equal(t, "/static/a.png", urlPolicy{nodeURL: "/docs/{id}"}.rewrite("/static/a.png"))
+	assert.Equal(t, "https://wiki.example.com/docs/n1", urlPolicy{baseURL: base, nodeURL: "/docs/{id}"}.rewrite("/node/n1"))
+
+	// <p><img src="/a.png"><a href="/node/n1">guide</a></p>
+	img := &html.Node{Type: html.ElementNode, Data: "img", Attr: []html.Attribute{{Key: "src", Val: "/a.png"}}}
+	a := &html.Node{Type: html.ElementNode, Data: "a", Attr: []html.Attribute{{Key: "href", Val: "/node/n1"}}, PrevSibling: img}
+	img.NextSibling = a
+	para := &html.Node{Type: html.ElementNode, Data: "p", FirstChild: img, LastChild: a}
+	img.Parent, a.Parent = para, para
+	urlPolicy{baseURL: base, stripImages: true}.rewriteTree(nil, para)
+	assert.Equal(t, a, para.FirstChild)
+	assert.Equal(t, "https://wiki.example.com/node/n1", a.Attr[0].Val)
+
+	_, err := HTML2MDOptionsFromConfig(config.RAGMarkdownConfig{BaseURL: "/relative"})
+	assert.Error(t, err)
+	_, err = HTML2MDOptionsFromConfig(config.RAGMarkdownConfig{NodeURL: "https://wiki.example.com/node/"})
+	assert.Error(t, err)
+}
+
+func TestPermissionsOnlyChange(t *testing.T) {
+	upload := func(groupIDs []int, tags []string) *raglite.UploadDocumentRequest {
+		data := &raglite.UploadDocumentRequest{Title: "Install", Tags: tags, Metadata: map[string]interface{}{"title": "Install"}}
+		if groupIDs != nil {
+			data.Metadata["group_ids"] = groupIDs
+		}
+		return data
+	}
+	existing := &Document{
+		Tags:     []string{"faq"},
+		MetaData: DocumentMetadata{GroupIDs: []int{1}, ContentHash: uploadHash("# Install", upload([]int{1}, []string{"faq"}))},
+	}
+	assert.True(t, permissionsOnlyChange("# Install", upload([]int{1, 2}, []string{"guide"}), existing))
+	assert.False(t, permissionsOnlyChange("# Install v2", upload([]int{1, 2}, nil), existing))
+	// removing the restriction needs the upload
+	assert.False(t, permissionsOnlyChange("# Install", upload(nil, []string{"faq"}), existing))
+}
+
+func TestReusableUpload(t *testing.T) {
+	assert.True(t, reusableUpload(&Document{Status: DocumentStatusSucceeded}, false))
+	assert.False(t, reusableUpload(&Document{Status: DocumentStatusSucceeded}, true))
+	assert.False(t, reusableUpload(nil, false))
+	// a failed parse is retried even with unchanged content
+	assert.False(t, reusableUpload(&Document{Status: "FAILED"}, false))
+	assert.False(t, reusableUpload(&Document{Status: DocumentStatusSucceeded, MetaData: DocumentMetadata{Archived: true}}, false))
+}
+
+func TestChunkMarkdown(t *testing.T) {
+	_, err := resolveChunking(&UpsertRecordsRequest{ChunkSize: 100, ChunkOverlap: 100}, config.RAGChunkingConfig{})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	c, err := resolveChunking(&UpsertRecordsRequest{ChunkOverlap: -1}, config.RAGChunkingConfig{Size: 64, Overlap: 8})
+	assert.NoError(t, err)
+	assert.Equal(t, chunking{size: 64, strategy: ChunkStrategyMarkdownHeading}, c)
+
+	markdown := "# API\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n## Errors\n\nshort\n"
+	assert.Equal(t, []string{"# API\n\n| a | b |\n|---|---|\n| 1 | 2 |\n\n", "## Errors\n\nshort\n"}, chunkMarkdown(markdown, c))
+
+	c = chunking{size: 12, overlap: 4, strategy: ChunkStrategySentence}
+	for _, chunk := range chunkMarkdown("One two. Three four! 五六七。", c) {
+		assert.LessOrEqual(t, len(chunk), 12)
+	}
+	assert.Equal(t, []string{"abc", "abcdef", "defghi", "ghij"}, chunkMarkdown("abcdefghij", chunking{size: 6, overlap: 3, strategy: ChunkStrategyFixed}))
+}
+
+func TestPartHash(t *testing.T) {
+	data := &raglite.UploadDocumentRequest{Title: "API", Metadata: map[string]interface{}{"base_doc_id": "doc", "content_hash": "a", "updated_at": "2024-01-01T00:00:00Z"}}
+	edited := &raglite.UploadDocumentRequest{Title: "API", Metadata: map[string]interface{}{"base_doc_id": "doc", "content_hash": "b", "updated_at": "2024-02-01T00:00:00Z"}}
+	assert.Equal(t, partHash("## GET /nodes\n", data), partHash("## GET /nodes\n", edited))
+	assert.NotEqual(t, partHash("## GET /nodes\n", data), partHash("## GET /nodes/{id}\n", data))
+	edited.Title = "API v2"
+	assert.NotEqual(t, partHash("## GET /nodes\n", data), partHash("## GET /nodes\n", edited))
+}
+
+func TestDocxText(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("word/document.xml")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte(`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+		`<w:p><w:r><w:t>Install</w:t></w:r></w:p><w:p><w:r><w:t>Run</w:t><w:tab/><w:t>make</w:t></w:r></w:p></w:body></w:document>`))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	text, err := docxText(buf.Bytes(), 1<<20)
+	assert.NoError(t, err)
+	assert.Equal(t, "Install\nRun\tmake\n", text)
+
+	_, err = docxText([]byte("not a zip"), 1<<20)
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	// a zip bomb unpacks far over the limit
+	buf.Reset()
+	zw = zip.NewWriter(&buf)
+	w, err = zw.Create("word/document.xml")
+	require.NoError(t, err)
+	_, err = w.Write(bytes.Repeat([]byte("<w:p/>"), 1<<16))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.Less(t, buf.Len(), 16<<10)
+	_, err = docxText(buf.Bytes(), 16<<10)
+	assert.ErrorIs(t, err, ErrDocumentTooLarge)
+}
+
+func TestExtractFileText(t *testing.T) {
+	text, err := extractFileText(context.Background(), ".md", strings.NewReader("# Install"), 9)
+	assert.NoError(t, err)
+	assert.Equal(t, "# Install", text)
+	_, err = extractFileText(context.Background(), ".md", strings.NewReader("# Install\n"), 9)
+	assert.ErrorIs(t, err, ErrDocumentTooLarge)
+	_, err = extractFileText(context.Background(), ".pdf", strings.NewReader("%PDF-1.7 and more"), 9)
+	assert.ErrorIs(t, err, ErrDocumentTooLarge)
+}
+
+func TestFileExtension(t *testing.T) {
+	ext, err := fileExtension(&UpsertFileRequest{Filename: "Manual.PDF"})
+	assert.NoError(t, err)
+	assert.Equal(t, ".pdf", ext)
+	ext, err = fileExtension(&UpsertFileRequest{Filename: "manual", ContentType: "application/pdf; charset=binary"})
+	assert.NoError(t, err)
+	assert.Equal(t, ".pdf", ext)
+	_, err = fileExtension(&UpsertFileRequest{Filename: "setup.exe"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestCustomMetadata(t *testing.T) {
+	assert.NoError(t, validateCustomMetadata(map[string]any{"space": "dev", "version": 3.2}))
+	assert.ErrorIs(t, validateCustomMetadata(map[string]any{"group_ids": []int{1}}), ErrInvalidRequest)
+	assert.ErrorIs(t, validateCustomMetadata(map[string]any{"author": nil}), ErrInvalidRequest)
+
+	raw := map[string]interface{}{"group_ids": []any{1.0}, "title": "Install", "space": "dev"}
+	assert.Equal(t, map[string]any{"space": "dev"}, customMetadata(raw))
+	assert.Nil(t, customMetadata(map[string]interface{}{"title": "Install"}))
+}
+
+func TestDecodeDocumentMetadata(t *testing.T) {
+	meta, err := decodeDocumentMetadata(raglite.JSON{Data: map[string]interface{}{"group_ids": []any{1.0, 2.0}, "title": "Install"}})
+	assert.NoError(t, err)
+	assert.Equal(t, DocumentMetadata{GroupIDs: []int{1, 2}, Title: "Install"}, meta)
+
+	meta, err = decodeDocumentMetadata(raglite.JSON{Data: map[string]interface{}{"group_ids": []any{"1"}, "title": "Install"}})
+	assert.Error(t, err)
+	assert.Equal(t, "Install", meta.Title)
+
+	meta, err = decodeDocumentMetadata(raglite.JSON{})
+	assert.NoError(t, err)
+	assert.Equal(t, DocumentMetadata{}, meta)
+}
+
+func TestToDocument(t *testing.T) {
+	s := &CTRAG{logger: log.NewLogger(&config.Config{})}
+	var document raglite.Document
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"d1","dataset_id":"ds","filename":"install-d1.md","status":"completed",
+		"created_at":"2024-05-01T08:30:00Z","updated_at":"2024-05-02T08:30:00Z",
+		"metadata":{"group_ids":[1,2],"title":"Install","space":"dev"}}`), &document))
+	doc := s.toDocument(context.Background(), document)
+	assert.Equal(t, "Install", doc.Name)
+	assert.Equal(t, time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), doc.CreatedAt.UTC())
+	assert.Equal(t, time.Date(2024, 5, 2, 8, 30, 0, 0, time.UTC), doc.UpdatedAt.UTC())
+	assert.Equal(t, []int{1, 2}, doc.MetaData.GroupIDs)
+	assert.Equal(t, map[string]any{"space": "dev"}, doc.MetaData.Custom)
+	assert.Empty(t, doc.MetadataDecodeError)
+
+	document = raglite.Document{}
+	require.NoError(t, json.Unmarshal([]byte(`{"id":"d2","filename":"faq-d2.md","metadata":{"group_ids":"all","title":"FAQ","updated_at":"2024-06-01T00:00:00Z"}}`), &document))
+	doc = s.toDocument(context.Background(), document)
+	// documents without a raglite update time fall back to the one stored in metadata
+	assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), doc.UpdatedAt.UTC())
+	assert.Equal(t, "FAQ", doc.Name)
+	assert.NotEmpty(t, doc.MetadataDecodeError)
+}
+
+func TestBoilerplateClean(t *testing.T) {
+	el := func(tag string, attrs ...html.Attribute) *html.Node {
+		return &html.Node{Type: html.ElementNode, Data: tag, Attr: attrs}
+	}
+	text := func(s string) *html.Node { return &html.Node{Type: html.TextNode, Data: s} }
+	// <body><nav>Home</nav><div class="cookie-banner">Accept</div><script>x()</script>
+	// <main><p>Install the agent</p></main><article>Related</article></body>
+	body := el("body")
+	nav, banner, script := el("nav"), el("div", html.Attribute{Key: "class", Val: "banner cookie-banner"}), el("script")
+	main, p, related := el("main"), el("p"), el("article")
+	for parent, children := range map[*html.Node][]*html.Node{
+		nav: {text("Home")}, banner: {text("Accept")}, script: {text("x()")},
+		p: {text("Install the agent")}, main: {p}, related: {text("Related")},
+	} {
+		for _, child := range children {
+			parent.AppendChild(child)
+		}
+	}
+	for _, child := range []*html.Node{nav, banner, script, main, related} {
+		body.AppendChild(child)
+	}
+	doc := &html.Node{Type: html.DocumentNode}
+	doc.AppendChild(body)
+
+	opt, err := WithBoilerplateRemoved(DefaultBoilerplateSelectors, true)
+	require.NoError(t, err)
+	var o html2mdOptions
+	opt(&o)
+	o.boilerplate.clean(nil, doc)
+	assert.Equal(t, main, body.FirstChild)
+	assert.Equal(t, main, body.LastChild)
+
+	_, err = WithBoilerplateRemoved([]string{"div > nav"}, false)
+	assert.Error(t, err)
+}
+
+func TestParseTimestamp(t *testing.T) {
+	for _, s := range []string{"2024-05-01T08:30:00Z", "2024-05-01T16:30:00.123+08:00", "2024-05-01T08:30:00", "2024-05-01 08:30:00"} {
+		ts, err := parseTimestamp(s)
+		require.NoError(t, err, s)
+		assert.Equal(t, time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC), ts.UTC().Truncate(time.Second), s)
+	}
+	_, err := parseTimestamp("yesterday")
+	assert.Error(t, err)
+}
+
+func TestTableGolden(t *testing.T) {
+	files, err := filepath.Glob("testdata/tables/*.html")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+	conv := NewHTML2MDConverter()
+	for _, file := range files {
+		input, err := os.ReadFile(file)
+		require.NoError(t, err)
+		want, err := os.ReadFile(strings.TrimSuffix(file, ".html") + ".md")
+		require.NoError(t, err)
+		got, err := conv.ConvertString(string(input))
+		require.NoError(t, err, file)
+		assert.Equal(t, strings.TrimSpace(string(want)), strings.TrimSpace(got), file)
+	}
+}
+
+func TestTableGrid(t *testing.T) {
+	el := func(tag string, attrs ...html.Attribute) *html.Node {
+		return &html.Node{Type: html.ElementNode, Data: tag, Attr: attrs}
+	}
+	cell := func(text string, attrs ...html.Attribute) *html.Node {
+		td := el("td", attrs...)
+		td.AppendChild(&html.Node{Type: html.TextNode, Data: text})
+		return td
+	}
+	row := func(cells ...*html.Node) *html.Node {
+		tr := el("tr")
+		for _, c := range cells {
+			tr.AppendChild(c)
+		}
+		return tr
+	}
+	// a 2x2 cell in the corner, a short last row
+	rows := []*html.Node{
+		row(cell("a", html.Attribute{Key: "colspan", Val: "2"}, html.Attribute{Key: "rowspan", Val: "2"}), cell("b")),
+		row(cell("c")),
+		row(cell("d")),
+	}
+	render := func(n *html.Node) string { return n.FirstChild.Data }
+	assert.Equal(t, [][]string{{"a", "a", "b"}, {"a", "a", "c"}, {"d"}}, tableGrid(rows, render))
+	assert.False(t, tableHasBlockCell(rows))
+
+	list := el("ul")
+	list.AppendChild(el("li"))
+	rows[2].FirstChild.AppendChild(list)
+	assert.True(t, tableHasBlockCell(rows))
+}
+
+func TestRenderCodeBlock(t *testing.T) {
+	el := func(tag string, attrs ...html.Attribute) *html.Node {
+		return &html.Node{Type: html.ElementNode, Data: tag, Attr: attrs}
+	}
+	text := func(s string) *html.Node { return &html.Node{Type: html.TextNode, Data: s} }
+	// <pre><code class="hljs language-go"><span class="kw">func</span> main() {<br>	fmt.Println("```")\n}\n</code></pre>
+	pre, code, kw := el("pre"), el("code", html.Attribute{Key: "class", Val: "hljs language-go"}), el("span", html.Attribute{Key: "class", Val: "kw"})
+	kw.AppendChild(text("func"))
+	for _, child := range []*html.Node{kw, text(" main() {"), el("br"), text("\tfmt.Println(\"```\")\n}\n")} {
+		code.AppendChild(child)
+	}
+	pre.AppendChild(code)
+	var sb strings.Builder
+	renderCodeBlock(nil, &sb, pre)
+	assert.Equal(t, "\n\n````go\nfunc main() {\n\tfmt.Println(\"```\")\n}\n````\n\n", sb.String())
+
+	code.Attr = nil
+	pre.Attr = []html.Attribute{{Key: "data-language", Val: "python"}}
+	assert.Equal(t, "python", codeLanguage(code, pre))
+	pre.Attr = []html.Attribute{{Key: "data-language", Val: "go\n```"}}
+	assert.Equal(t, "", codeLanguage(code, pre))
+}
+
+func TestRenderMath(t *testing.T) {
+	el := func(tag string, attrs ...html.Attribute) *html.Node {
+		return &html.Node{Type: html.ElementNode, Data: tag, Attr: attrs}
+	}
+	nest := func(nodes ...*html.Node) *html.Node {
+		for i := len(nodes) - 1; i > 0; i-- {
+			nodes[i-1].AppendChild(nodes[i])
+		}
+		return nodes[0]
+	}
+	render := func(n *html.Node) string {
+		var sb strings.Builder
+		assert.Equal(t, converter.RenderSuccess, renderMath(nil, &sb, n))
+		return sb.String()
+	}
+	// KaTeX: <span class="katex-display"><span class="katex"><span class="katex-mathml"><math display="block">
+	// <semantics><annotation encoding="application/x-tex">E = mc^2</annotation>
+	display := nest(el("span", html.Attribute{Key: "class", Val: "katex-display"}), el("span", html.Attribute{Key: "class", Val: "katex"}),
+		el("span", html.Attribute{Key: "class", Val: "katex-mathml"}), el("math", html.Attribute{Key: "display", Val: "block"}),
+		el("semantics"), el("annotation", html.Attribute{Key: "encoding", Val: texEncoding}), &html.Node{Type: html.TextNode, Data: " E = mc^2 "})
+	assert.Equal(t, "\n\n$$\nE = mc^2\n$$\n\n", render(display))
+	inline := display.FirstChild
+	inline.Parent, display.FirstChild = nil, nil
+	inline.FirstChild.FirstChild.Attr = nil
+	assert.Equal(t, "$E = mc^2$", render(inline))
+
+	assert.Equal(t, `$\sqrt{2}$`, render(el("span", html.Attribute{Key: "data-type", Val: "inline-math"}, html.Attribute{Key: "data-latex", Val: `\sqrt{2}`})))
+	assert.Equal(t, "x squared", render(el("span", html.Attribute{Key: "class", Val: "katex"}, html.Attribute{Key: "aria-label", Val: "x squared"})))
+	assert.Equal(t, converter.RenderTryNext, renderMath(nil, &strings.Builder{}, el("span", html.Attribute{Key: "class", Val: "note"})))
+}
+
+func TestNormalizeGroupIDs(t *testing.T) {
+	ids, err := normalizeGroupIDs([]int{3, 1, 3, 0, 1})
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 1, 0}, ids)
+	ids, err = normalizeGroupIDs(nil)
+	require.NoError(t, err)
+	assert.Nil(t, ids)
+	ids, err = normalizeGroupIDs([]int{})
+	require.NoError(t, err)
+	assert.Equal(t, []int{}, ids)
+	_, err = normalizeGroupIDs([]int{2, -1})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestRenderDiagram(t *testing.T) {
+	render := func(n *html.Node) string {
+		var sb strings.Builder
+		require.Equal(t, converter.RenderSuccess, renderDiagram(nil, &sb, n))
+		return sb.String()
+	}
+	mermaid := &html.Node{Type: html.ElementNode, Data: "div", Attr: []html.Attribute{{Key: "class", Val: "mermaid"}}}
+	mermaid.AppendChild(&html.Node{Type: html.TextNode, Data: "\ngraph TD\n  A --> B\n"})
+	assert.Equal(t, "\n\n```mermaid\ngraph TD\n  A --> B\n```\n\n", render(mermaid))
+
+	flow := &html.Node{Type: html.ElementNode, Data: "div", Attr: []html.Attribute{{Key: "data-type", Val: "flow"}, {Key: "data-code", Val: `graph LR\nA--&gt;B`}}}
+	assert.Equal(t, "\n\n```mermaid\ngraph LR\nA-->B\n```\n\n", render(flow))
+
+	plantuml := &html.Node{Type: html.ElementNode, Data: "pre", Attr: []html.Attribute{{Key: "class", Val: "language-plantuml"}}}
+	plantuml.AppendChild(&html.Node{Type: html.TextNode, Data: "@startuml\nAlice -> Bob\n@enduml"})
+	assert.Equal(t, "\n\n```plantuml\n@startuml\nAlice -> Bob\n@enduml\n```\n\n", render(plantuml))
+
+	rendered := &html.Node{Type: html.ElementNode, Data: "div", Attr: []html.Attribute{{Key: "class", Val: "mermaid"}}}
+	rendered.AppendChild(&html.Node{Type: html.ElementNode, Data: "svg"})
+	assert.Equal(t, converter.RenderTryNext, renderDiagram(nil, &strings.Builder{}, rendered))
+}
+
+func TestFilterDateRange(t *testing.T) {
+	docs := map[string]Document{
+		"old":     {MetaData: DocumentMetadata{Custom: map[string]any{"published_at": "2024-01-10T00:00:00Z"}}},
+		"new":     {MetaData: DocumentMetadata{Custom: map[string]any{"published_at": "2024-05-01 08:30:00"}}},
+		"undated": {},
+	}
+	chunks := []*domain.NodeContentChunk{{DocID: "old"}, {DocID: "new"}, {DocID: "undated"}, {DocID: "unknown"}}
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	kept := filterDateRange(chunks, docs, DefaultDateField, from, time.Time{})
+	require.Len(t, kept, 1)
+	assert.Equal(t, "new", kept[0].DocID)
+	kept = filterDateRange(chunks, docs, DefaultDateField, time.Time{}, from)
+	require.Len(t, kept, 1)
+	assert.Equal(t, "old", kept[0].DocID)
+
+	assert.ErrorIs(t, validateDateRange(&QueryRecordsRequest{DateFrom: from, DateTo: from.AddDate(0, 0, -1)}), ErrInvalidRequest)
+}
+
+func TestDocumentPatchRequest(t *testing.T) {
+	// a tags only patch leaves group IDs, title and custom metadata to raglite
+	tags := []string{}
+	req, err := documentPatchRequest("ds", "doc", DocumentPatch{Tags: &tags})
+	require.NoError(t, err)
+	assert.Equal(t, []string{}, req.Tags)
+	assert.Empty(t, req.Metadata)
+
+	groupIDs, title := []int{2, 2, 1}, "Install"
+	req, err = documentPatchRequest("ds", "doc", DocumentPatch{GroupIDs: &groupIDs, Title: &title, Metadata: map[string]any{"owner": "ops"}})
+	require.NoError(t, err)
+	assert.Nil(t, req.Tags)
+	assert.Equal(t, map[string]interface{}{"group_ids": []int{2, 1}, "title": "Install", "owner": "ops"}, req.Metadata)
+
+	// nil group IDs are sent as an explicit clear, an open document has no group restriction
+	var open []int
+	patch := DocumentPatch{GroupIDs: &open}
+	assert.False(t, patch.empty())
+	req, err = documentPatchRequest("ds", "doc", patch)
+	require.NoError(t, err)
+	require.Contains(t, req.Metadata, "group_ids")
+	assert.Nil(t, req.Metadata["group_ids"])
+
+	_, err = documentPatchRequest("ds", "doc", DocumentPatch{Metadata: map[string]any{"group_ids": []int{1}}})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+	assert.True(t, DocumentPatch{Metadata: map[string]any{}}.empty())
+}
+
+func TestToModel(t *testing.T) {
+	model := toModel(raglite.AIModel{
+		ID:        "m1",
+		Name:      "chat",
+		ModelType: string(domain.ModelTypeChat),
+		Provider:  "OpenAI",
+		ModelName: "gpt-4o",
+		Config: raglite.AIModelConfig{
+			APIKey:          "key",
+			APIBase:         "https://api.openai.com/v1",
+			MaxTokens:       raglite.Ptr(4096),
+			ExtraParameters: map[string]interface{}{"context_window": 128000},
+		},
+		Status: modelStatusActive,
+	})
+	assert.Equal(t, "gpt-4o", model.Model)
+	assert.Equal(t, domain.ModelProvider("OpenAI"), model.Provider)
+	assert.Equal(t, "https://api.openai.com/v1", model.BaseURL)
+	assert.Equal(t, 4096, model.Parameters.MaxTokens)
+	assert.Equal(t, 128000, model.Parameters.ContextWindow)
+	assert.True(t, model.IsActive)
+
+	assert.False(t, toModel(raglite.AIModel{Name: "chat", Status: "inactive"}).IsActive)
+	assert.Equal(t, "chat", toModel(raglite.AIModel{Name: "chat"}).Model)
+}
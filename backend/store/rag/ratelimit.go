@@ -0,0 +1,146 @@
+package rag
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitRetries is how often a request answered with 429 is sent again
+	rateLimitRetries = 2
+	// defaultRetryAfter is the pause after a 429 without a usable Retry-After header
+	defaultRetryAfter = time.Second
+	// maxRetryAfter caps the pause, a long Retry-After must not stall every request
+	maxRetryAfter = 30 * time.Second
+)
+
+// rateLimiter is a token bucket shared by all requests of a raglite client.
+// Tokens may go negative, each waiter then sleeps until its own token is refilled.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu          sync.Mutex
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+}
+
+// newRateLimiter returns nil (no limiting) when rps is not positive, burst 0 means max(rps, 1).
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	if rps <= 0 {
+		return nil
+	}
+	b := float64(burst)
+	if burst <= 0 {
+		b = max(rps, 1)
+	}
+	return &rateLimiter{rate: rps, burst: b, tokens: b, last: time.Now()}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	return max(delay, l.pausedUntil.Sub(now))
+}
+
+func (l *rateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens = min(l.burst, l.tokens+1)
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	}
+}
+
+// pause holds back every request for d, raglite asked for it with Retry-After.
+func (l *rateLimiter) pause(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP date.
+func retryAfter(header string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(date.Sub(now), 0)
+	}
+	return defaultRetryAfter
+}
+
+// rateLimitedTransport paces requests through a rateLimiter and retries 429 responses
+// after their Retry-After, unless the request body cannot be sent again or the wait
+// would outlast the request context.
+type rateLimitedTransport struct {
+	next    http.RoundTripper
+	limiter *rateLimiter
+}
+
+func newRateLimitedTransport(next http.RoundTripper, limiter *rateLimiter) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &rateLimitedTransport{next: next, limiter: limiter}
+}
+
+func (t *rateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.wait(ctx); err != nil {
+			return nil, err
+		}
+		resp, err := t.next.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests {
+			return resp, err
+		}
+		delay := min(retryAfter(resp.Header.Get("Retry-After"), time.Now()), maxRetryAfter)
+		t.limiter.pause(delay)
+		if attempt == rateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return resp, nil
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		retry := req.Clone(ctx)
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retry.Body = body
+		}
+		req = retry
+	}
+}
@@ -0,0 +1,28 @@
+package rag
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+func validateRecencyBoost(halfLifeDays float64) error {
+	if math.IsNaN(halfLifeDays) || math.IsInf(halfLifeDays, 0) || halfLifeDays < 0 {
+		return fmt.Errorf("%w: recency half-life must be a non-negative number of days, got %v", ErrInvalidRequest, halfLifeDays)
+	}
+	return nil
+}
+
+// recencyFactor halves a score for every halfLifeDays of document age. Documents without
+// a parsable updated_at, or dated in the future, keep their score.
+func recencyFactor(updatedAt string, now time.Time, halfLifeDays float64) float64 {
+	t, err := time.Parse(time.RFC3339, updatedAt)
+	if err != nil {
+		return 1
+	}
+	ageDays := now.Sub(t).Hours() / 24
+	if ageDays <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, ageDays/halfLifeDays)
+}
@@ -0,0 +1,81 @@
+package rag
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+// rerankOverfetch is how many candidates per requested chunk are retrieved for reranking.
+const rerankOverfetch = 3
+
+type rerankRequest struct {
+	Model     string   `json:"model"`
+	Query     string   `json:"query"`
+	Documents []string `json:"documents"`
+	TopN      int      `json:"top_n"`
+}
+
+type rerankResponse struct {
+	Results []struct {
+		Index          int     `json:"index"`
+		RelevanceScore float64 `json:"relevance_score"`
+	} `json:"results"`
+}
+
+// rerankChunks sends chunks to the /rerank endpoint of model and returns the top n
+// with scores replaced by the relevance scores, callers sort them.
+func rerankChunks(ctx context.Context, model *domain.Model, query string, chunks []*domain.NodeContentChunk, n int) ([]*domain.NodeContentChunk, error) {
+	if len(chunks) == 0 {
+		return chunks, nil
+	}
+	documents := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		documents[i] = chunk.Content
+	}
+	body, err := json.Marshal(rerankRequest{
+		Model:     model.Model,
+		Query:     query,
+		Documents: documents,
+		TopN:      min(n, len(chunks)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal rerank request failed: %w", err)
+	}
+	url := strings.TrimRight(model.BaseURL, "/") + "/rerank"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create rerank request failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if model.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+model.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rerank %s failed: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rerank %s failed: status %d", url, resp.StatusCode)
+	}
+	var res rerankResponse
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, fmt.Errorf("decode rerank response failed: %w", err)
+	}
+	reranked := make([]*domain.NodeContentChunk, 0, len(res.Results))
+	for _, r := range res.Results {
+		if r.Index < 0 || r.Index >= len(chunks) {
+			return nil, fmt.Errorf("rerank result index %d out of range", r.Index)
+		}
+		chunk := *chunks[r.Index]
+		chunk.Score = r.RelevanceScore
+		reranked = append(reranked, &chunk)
+	}
+	return reranked, nil
+}
@@ -0,0 +1,102 @@
+package rag
+
+import (
+	"context"
+	"slices"
+	"sort"
+)
+
+const resyncProgressInterval = 100
+
+// PermissionResolver returns the group IDs and visibility a document should be indexed with.
+// Nil group IDs mean unrestricted, an empty slice means nobody.
+type PermissionResolver func(docID string) ([]int, string, error)
+
+// ResyncReport summarizes a ResyncPermissions run, it is also the checkpoint to resume from.
+type ResyncReport struct {
+	Total     int               `json:"total"`
+	Changed   int               `json:"changed"`
+	Unchanged int               `json:"unchanged"`
+	Failed    map[string]string `json:"failed"`
+	// Cursor is the last processed doc ID, documents are processed in ID order
+	Cursor string `json:"cursor"`
+	Done   bool   `json:"done"`
+}
+
+type ResyncOptions struct {
+	// Resume continues an unfinished run after its cursor, keeping its counts
+	Resume *ResyncReport
+	// Progress is called periodically and when the run ends
+	Progress func(report *ResyncReport)
+}
+
+// ResyncPermissions walks every document of a dataset and patches the ones whose
+// group IDs or visibility differ from what the resolver says.
+// Attachments are resolved through their parent document.
+func ResyncPermissions(ctx context.Context, service RAGService, datasetID string, resolver PermissionResolver, opts ResyncOptions) (*ResyncReport, error) {
+	docs, err := service.ListDocuments(ctx, datasetID, nil)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].ID < docs[j].ID })
+
+	report := &ResyncReport{Total: len(docs), Failed: make(map[string]string)}
+	if resume := opts.Resume; resume != nil && !resume.Done {
+		report.Changed = resume.Changed
+		report.Unchanged = resume.Unchanged
+		for docID, msg := range resume.Failed {
+			report.Failed[docID] = msg
+		}
+		report.Cursor = resume.Cursor
+	}
+	progress := func() {
+		if opts.Progress != nil {
+			opts.Progress(report)
+		}
+	}
+
+	processed := 0
+	for _, doc := range docs {
+		if doc.ID <= report.Cursor {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			progress()
+			return report, err
+		}
+		resolveID := doc.ID
+		if doc.MetaData.ParentDocID != "" {
+			resolveID = doc.MetaData.ParentDocID
+		}
+		groupIDs, visibility, err := resolver(resolveID)
+		if err == nil {
+			if samePermissions(doc.MetaData, groupIDs, visibility) {
+				report.Unchanged++
+			} else if err = service.UpdateDocumentPermissions(ctx, datasetID, doc.ID, groupIDs, visibility); err == nil {
+				report.Changed++
+			}
+		}
+		if err != nil {
+			report.Failed[doc.ID] = err.Error()
+		} else {
+			delete(report.Failed, doc.ID)
+		}
+		report.Cursor = doc.ID
+		if processed++; processed%resyncProgressInterval == 0 {
+			progress()
+		}
+	}
+	report.Done = true
+	progress()
+	return report, nil
+}
+
+func samePermissions(meta DocumentMetadata, groupIDs []int, visibility string) bool {
+	if meta.Visibility != visibility || (meta.GroupIDs == nil) != (groupIDs == nil) {
+		return false
+	}
+	a, b := slices.Clone(meta.GroupIDs), slices.Clone(groupIDs)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
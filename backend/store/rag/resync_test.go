@@ -0,0 +1,80 @@
+package rag
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type docsRAG struct {
+	*DisabledRAG
+	docs    []Document
+	updated []string
+}
+
+func (s *docsRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error) {
+	return s.docs, nil
+}
+
+func (s *docsRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error {
+	s.updated = append(s.updated, docID)
+	return nil
+}
+
+func TestResyncPermissions(t *testing.T) {
+	service := &docsRAG{docs: []Document{
+		{ID: "c", MetaData: DocumentMetadata{GroupIDs: []int{2, 1}, Visibility: "partial"}},
+		{ID: "a", MetaData: DocumentMetadata{Visibility: "open"}},
+		{ID: "b", MetaData: DocumentMetadata{GroupIDs: []int{}, Visibility: "closed"}},
+		{ID: "d", MetaData: DocumentMetadata{ParentDocID: "c"}},
+		{ID: "e"},
+	}}
+	resolver := func(docID string) ([]int, string, error) {
+		switch docID {
+		case "a":
+			return nil, "open", nil
+		case "b":
+			return nil, "open", nil
+		case "c":
+			return []int{1, 2}, "partial", nil
+		}
+		return nil, "", errors.New("unknown document")
+	}
+	var calls int
+	report, err := ResyncPermissions(context.Background(), service, "ds", resolver, ResyncOptions{
+		Progress: func(*ResyncReport) { calls++ },
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 5, report.Total)
+	assert.Equal(t, 2, report.Changed)
+	assert.Equal(t, 2, report.Unchanged)
+	assert.Len(t, report.Failed, 1)
+	assert.Contains(t, report.Failed, "e")
+	assert.Equal(t, []string{"b", "d"}, service.updated)
+	assert.True(t, report.Done)
+	assert.Equal(t, "e", report.Cursor)
+	assert.Equal(t, 1, calls)
+}
+
+func TestResyncPermissions_Resume(t *testing.T) {
+	service := &docsRAG{docs: []Document{{ID: "a"}, {ID: "b"}, {ID: "c"}}}
+	resolver := func(docID string) ([]int, string, error) {
+		return []int{1}, "partial", nil
+	}
+	report, err := ResyncPermissions(context.Background(), service, "ds", resolver, ResyncOptions{
+		Resume: &ResyncReport{Changed: 1, Cursor: "a", Failed: map[string]string{}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, service.updated)
+	assert.Equal(t, 3, report.Changed)
+
+	// a finished report starts over
+	service.updated = nil
+	report, err = ResyncPermissions(context.Background(), service, "ds", resolver, ResyncOptions{Resume: report})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, service.updated)
+	assert.Equal(t, 3, report.Changed)
+}
@@ -0,0 +1,381 @@
+package rag
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+const datasetProviderSep = ":"
+
+var _ RAGService = (*RouterRAG)(nil)
+
+// RouterRAG routes each call to a named provider instance by dataset ID.
+// Datasets created in a named instance get IDs of the form "<name>:<id>",
+// so routing needs no extra state. Unprefixed IDs belong to the default provider.
+// Model changes are applied to every instance, each keeps its own model IDs. Models are read
+// from the default provider, whose IDs are the ones callers store.
+type RouterRAG struct {
+	defaultService RAGService
+	instances      map[string]RAGService
+	logger         *log.Logger
+}
+
+func NewRouterRAG(defaultService RAGService, instances map[string]RAGService, logger *log.Logger) *RouterRAG {
+	return &RouterRAG{
+		defaultService: defaultService,
+		instances:      instances,
+		logger:         logger.WithModule("store.vector.router"),
+	}
+}
+
+// route returns the provider owning datasetID and the ID known to that provider.
+func (s *RouterRAG) route(datasetID string) (RAGService, string) {
+	if name, id, ok := strings.Cut(datasetID, datasetProviderSep); ok {
+		if service, ok := s.instances[name]; ok {
+			return service, id
+		}
+	}
+	return s.defaultService, datasetID
+}
+
+// external converts a provider-local dataset ID back to the routed form.
+func (s *RouterRAG) external(datasetID, localID string) string {
+	if name, _, ok := strings.Cut(datasetID, datasetProviderSep); ok {
+		if _, ok := s.instances[name]; ok {
+			return name + datasetProviderSep + localID
+		}
+	}
+	return localID
+}
+
+// DatasetProvider returns the instance a dataset ID created by RouterRAG belongs to, empty for
+// the default provider.
+func DatasetProvider(datasetID string) string {
+	name, _, _ := strings.Cut(datasetID, datasetProviderSep)
+	if name == datasetID {
+		return ""
+	}
+	return name
+}
+
+// CreateKnowledgeBase creates a dataset in the named instance, an empty provider means the default one.
+func (s *RouterRAG) CreateKnowledgeBase(ctx context.Context, name string, provider string) (string, error) {
+	if provider == "" {
+		return s.defaultService.CreateKnowledgeBase(ctx, name, "")
+	}
+	service, ok := s.instances[provider]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown rag provider instance: %s", ErrInvalidRequest, provider)
+	}
+	datasetID, err := service.CreateKnowledgeBase(ctx, name, "")
+	if err != nil {
+		return "", err
+	}
+	return provider + datasetProviderSep + datasetID, nil
+}
+
+// Capabilities are those of every instance, a caller may reach any of them.
+func (s *RouterRAG) Capabilities() Capabilities {
+	capabilities := s.defaultService.Capabilities()
+	for _, service := range s.instances {
+		capabilities = capabilities.intersect(service.Capabilities())
+	}
+	return capabilities
+}
+
+// QueryRecords requires all datasets of a multi-dataset query to live in the same instance.
+func (s *RouterRAG) QueryRecords(ctx context.Context, req *QueryRecordsRequest) (*QueryResult, error) {
+	ids := req.datasetIDs()
+	service, _ := s.route(ids[0])
+	routed := *req
+	routed.DatasetID = ""
+	routed.DatasetIDs = make([]string, len(ids))
+	localIDs := make(map[string]string, len(ids))
+	for i, id := range ids {
+		idService, localID := s.route(id)
+		if idService != service {
+			return nil, fmt.Errorf("%w: datasets of one query must belong to the same rag provider instance", ErrInvalidRequest)
+		}
+		routed.DatasetIDs[i] = localID
+		localIDs[localID] = id
+	}
+	res, err := service.QueryRecords(ctx, &routed)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range res.Chunks {
+		if id, ok := localIDs[chunk.DatasetID]; ok {
+			chunk.DatasetID = id
+		}
+	}
+	return res, nil
+}
+
+func (s *RouterRAG) QueryRecordsMulti(ctx context.Context, datasetIDs []string, req *QueryRecordsRequest) (*QueryResult, error) {
+	multiReq, err := multiQueryRequest(datasetIDs, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryRecords(ctx, multiReq)
+}
+
+func (s *RouterRAG) QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error) {
+	return streamQueryResult(ctx, func(ctx context.Context) (*QueryResult, error) {
+		return s.QueryRecords(ctx, req)
+	})
+}
+
+func (s *RouterRAG) UpsertRecords(ctx context.Context, req *UpsertRecordsRequest) (*UpsertResult, error) {
+	service, datasetID := s.route(req.DatasetID)
+	routed := *req
+	routed.DatasetID = datasetID
+	return service.UpsertRecords(ctx, &routed)
+}
+
+// BatchUpsertRecords upserts one by one through UpsertRecords, the documents can belong to different instances.
+func (s *RouterRAG) BatchUpsertRecords(ctx context.Context, reqs []*UpsertRecordsRequest) ([]UpsertResult, error) {
+	return batchUpsert(ctx, reqs, defaultBatchUpsertWorkers, s.UpsertRecords)
+}
+
+func (s *RouterRAG) UpsertFile(ctx context.Context, req *UpsertFileRequest) (string, error) {
+	service, datasetID := s.route(req.DatasetID)
+	routed := *req
+	routed.DatasetID = datasetID
+	return service.UpsertFile(ctx, &routed)
+}
+
+func (s *RouterRAG) DeleteRecords(ctx context.Context, datasetID string, docIDs []string) error {
+	service, id := s.route(datasetID)
+	return service.DeleteRecords(ctx, id, docIDs)
+}
+
+func (s *RouterRAG) DeleteAllDocuments(ctx context.Context, datasetID string) error {
+	service, id := s.route(datasetID)
+	return service.DeleteAllDocuments(ctx, id)
+}
+
+func (s *RouterRAG) DeleteKnowledgeBase(ctx context.Context, datasetID string) error {
+	service, id := s.route(datasetID)
+	return service.DeleteKnowledgeBase(ctx, id)
+}
+
+func (s *RouterRAG) SetKnowledgeBaseOptions(ctx context.Context, datasetID string, opts KnowledgeBaseOptions) error {
+	service, id := s.route(datasetID)
+	return service.SetKnowledgeBaseOptions(ctx, id, opts)
+}
+
+func (s *RouterRAG) UpdateDocumentGroupIDs(ctx context.Context, datasetID string, docID string, groupIds []int) error {
+	service, id := s.route(datasetID)
+	return service.UpdateDocumentGroupIDs(ctx, id, docID, groupIds)
+}
+
+func (s *RouterRAG) UpdateDocumentPermissions(ctx context.Context, datasetID string, docID string, groupIds []int, visibility string) error {
+	service, id := s.route(datasetID)
+	return service.UpdateDocumentPermissions(ctx, id, docID, groupIds, visibility)
+}
+
+func (s *RouterRAG) UpdateDocumentTags(ctx context.Context, datasetID string, docID string, tags []string) error {
+	service, id := s.route(datasetID)
+	return service.UpdateDocumentTags(ctx, id, docID, tags)
+}
+
+func (s *RouterRAG) UpdateDocumentMetadata(ctx context.Context, datasetID string, docID string, groupIds []int, tags []string) error {
+	service, id := s.route(datasetID)
+	return service.UpdateDocumentMetadata(ctx, id, docID, groupIds, tags)
+}
+
+func (s *RouterRAG) UpdateDocument(ctx context.Context, datasetID string, docID string, patch DocumentPatch) error {
+	service, id := s.route(datasetID)
+	return service.UpdateDocument(ctx, id, docID, patch)
+}
+
+func (s *RouterRAG) ArchiveDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	service, id := s.route(datasetID)
+	return service.ArchiveDocuments(ctx, id, docIDs)
+}
+
+func (s *RouterRAG) RestoreDocuments(ctx context.Context, datasetID string, docIDs []string) error {
+	service, id := s.route(datasetID)
+	return service.RestoreDocuments(ctx, id, docIDs)
+}
+
+func (s *RouterRAG) ListDocuments(ctx context.Context, datasetID string, documentIDs []string) ([]Document, error) {
+	service, id := s.route(datasetID)
+	documents, err := service.ListDocuments(ctx, id, documentIDs)
+	if err != nil {
+		return nil, err
+	}
+	for i := range documents {
+		documents[i].DatasetID = s.external(datasetID, documents[i].DatasetID)
+	}
+	return documents, nil
+}
+
+// ListKnowledgeBases lists the datasets of the default provider and of every instance,
+// with the IDs of instances in the routed form.
+func (s *RouterRAG) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	kbs, err := s.defaultService.ListKnowledgeBases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := slices.Sorted(maps.Keys(s.instances))
+	for _, name := range names {
+		instanceKBs, err := s.instances[name].ListKnowledgeBases(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list knowledge bases of %s: %w", name, err)
+		}
+		for _, kb := range instanceKBs {
+			kb.ID = name + datasetProviderSep + kb.ID
+			kbs = append(kbs, kb)
+		}
+	}
+	return kbs, nil
+}
+
+func (s *RouterRAG) CountDocuments(ctx context.Context, datasetID string) (int, error) {
+	service, id := s.route(datasetID)
+	return service.CountDocuments(ctx, id)
+}
+
+func (s *RouterRAG) CountDocumentsByGroup(ctx context.Context, datasetID string) (map[int]int, error) {
+	service, id := s.route(datasetID)
+	return service.CountDocumentsByGroup(ctx, id)
+}
+
+func (s *RouterRAG) BrowseRecords(ctx context.Context, req *BrowseRecordsRequest) ([]BrowseEntry, error) {
+	service, id := s.route(req.DatasetID)
+	routed := *req
+	routed.DatasetID = id
+	return service.BrowseRecords(ctx, &routed)
+}
+
+func (s *RouterRAG) DatasetExists(ctx context.Context, datasetID string) (bool, error) {
+	service, id := s.route(datasetID)
+	return service.DatasetExists(ctx, id)
+}
+
+func (s *RouterRAG) GetDocument(ctx context.Context, datasetID string, docID string) (*Document, error) {
+	service, id := s.route(datasetID)
+	document, err := service.GetDocument(ctx, id, docID)
+	if err != nil {
+		return nil, err
+	}
+	document.DatasetID = s.external(datasetID, document.DatasetID)
+	return document, nil
+}
+
+func (s *RouterRAG) GetDocumentContent(ctx context.Context, datasetID string, docID string) (string, error) {
+	service, id := s.route(datasetID)
+	return service.GetDocumentContent(ctx, id, docID)
+}
+
+func (s *RouterRAG) GetDocumentChunks(ctx context.Context, datasetID string, docID string) ([]*domain.NodeContentChunk, error) {
+	service, id := s.route(datasetID)
+	chunks, err := service.GetDocumentChunks(ctx, id, docID)
+	if err != nil {
+		return nil, err
+	}
+	for _, chunk := range chunks {
+		chunk.DatasetID = datasetID
+	}
+	return chunks, nil
+}
+
+func (s *RouterRAG) GetKeywords(ctx context.Context, datasetID string, text string) ([]string, error) {
+	service, id := s.route(datasetID)
+	return service.GetKeywords(ctx, id, text)
+}
+
+// Ping checks the default provider and every named instance.
+func (s *RouterRAG) Ping(ctx context.Context) error {
+	if err := s.defaultService.Ping(ctx); err != nil {
+		return err
+	}
+	for name, service := range s.instances {
+		if err := service.Ping(ctx); err != nil {
+			return fmt.Errorf("ping rag provider instance %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *RouterRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
+	return s.defaultService.GetModelList(ctx)
+}
+
+func (s *RouterRAG) GetModelsByType(ctx context.Context) (map[domain.ModelType][]*domain.Model, error) {
+	return s.defaultService.GetModelsByType(ctx)
+}
+
+func (s *RouterRAG) GetModel(ctx context.Context, id string) (*domain.Model, error) {
+	return s.defaultService.GetModel(ctx, id)
+}
+
+// AddModel returns the model ID of the default provider, the instances get the model by name.
+func (s *RouterRAG) AddModel(ctx context.Context, model *domain.Model) (string, error) {
+	id, err := s.defaultService.AddModel(ctx, model)
+	if err != nil {
+		return "", err
+	}
+	for name, service := range s.instances {
+		if err := service.UpsertModel(ctx, model); err != nil {
+			return "", fmt.Errorf("add model to rag provider instance %s failed: %w", name, err)
+		}
+	}
+	return id, nil
+}
+
+// UpdateModel updates by the model ID of the default provider, the instances by model name.
+func (s *RouterRAG) UpdateModel(ctx context.Context, model *domain.Model) error {
+	if err := s.defaultService.UpdateModel(ctx, model); err != nil {
+		return err
+	}
+	for name, service := range s.instances {
+		if err := service.UpsertModel(ctx, model); err != nil {
+			return fmt.Errorf("update model of rag provider instance %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// DeleteModel deletes by the model ID of the default provider, in the instances the model of the
+// same name and type is looked up. Instances without it are skipped.
+func (s *RouterRAG) DeleteModel(ctx context.Context, model *domain.Model) error {
+	if err := s.defaultService.DeleteModel(ctx, model); err != nil {
+		return err
+	}
+	for name, service := range s.instances {
+		models, err := service.GetModelList(ctx)
+		if err != nil {
+			return fmt.Errorf("list models of rag provider instance %s failed: %w", name, err)
+		}
+		i := slices.IndexFunc(models, func(m *domain.Model) bool {
+			return m.Model == model.Model && m.Type == model.Type
+		})
+		if i < 0 {
+			continue
+		}
+		if err := service.DeleteModel(ctx, models[i]); err != nil {
+			return fmt.Errorf("delete model of rag provider instance %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *RouterRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
+	if err := s.defaultService.UpsertModel(ctx, model); err != nil {
+		return err
+	}
+	for name, service := range s.instances {
+		if err := service.UpsertModel(ctx, model); err != nil {
+			return fmt.Errorf("upsert model to rag provider instance %s failed: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package rag
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/chaitin/panda-wiki/config"
+	"github.com/chaitin/panda-wiki/domain"
+	"github.com/chaitin/panda-wiki/log"
+)
+
+type kbListRAG struct {
+	*DisabledRAG
+	kbs []KnowledgeBase
+}
+
+func (s *kbListRAG) ListKnowledgeBases(ctx context.Context) ([]KnowledgeBase, error) {
+	return s.kbs, nil
+}
+
+func TestRouterListKnowledgeBases(t *testing.T) {
+	service := NewRouterRAG(&kbListRAG{kbs: []KnowledgeBase{{ID: "a", Name: "docs", DocumentCount: 2}}}, map[string]RAGService{
+		"eu": &kbListRAG{kbs: []KnowledgeBase{{ID: "b", Name: "orphan"}}},
+	}, log.NewLogger(&config.Config{}))
+	kbs, err := service.ListKnowledgeBases(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []KnowledgeBase{{ID: "a", Name: "docs", DocumentCount: 2}, {ID: "eu:b", Name: "orphan"}}, kbs)
+}
+
+// instanceModelRAG keeps models in memory, IDs are the model name behind a per provider prefix.
+type instanceModelRAG struct {
+	*DisabledRAG
+	prefix string
+	models []*domain.Model
+}
+
+func (s *instanceModelRAG) AddModel(ctx context.Context, model *domain.Model) (string, error) {
+	added := *model
+	added.ID = s.prefix + model.Model
+	s.models = append(s.models, &added)
+	return added.ID, nil
+}
+
+func (s *instanceModelRAG) UpsertModel(ctx context.Context, model *domain.Model) error {
+	_, err := s.AddModel(ctx, model)
+	return err
+}
+
+func (s *instanceModelRAG) GetModelList(ctx context.Context) ([]*domain.Model, error) {
+	return s.models, nil
+}
+
+func (s *instanceModelRAG) DeleteModel(ctx context.Context, model *domain.Model) error {
+	s.models = slices.DeleteFunc(s.models, func(m *domain.Model) bool { return m.ID == model.ID })
+	return nil
+}
+
+func TestRouterModels(t *testing.T) {
+	defaultService, eu := &instanceModelRAG{prefix: "d-"}, &instanceModelRAG{prefix: "eu-"}
+	service := NewRouterRAG(defaultService, map[string]RAGService{"eu": eu}, log.NewLogger(&config.Config{}))
+	model := &domain.Model{Model: "bge-m3", Type: domain.ModelTypeEmbedding}
+	id, err := service.AddModel(context.Background(), model)
+	require.NoError(t, err)
+	assert.Equal(t, "d-bge-m3", id)
+	require.Len(t, eu.models, 1)
+
+	// the instance deletes its own copy, found by name and type
+	require.NoError(t, service.DeleteModel(context.Background(), &domain.Model{ID: id, Model: "bge-m3", Type: domain.ModelTypeEmbedding}))
+	assert.Empty(t, defaultService.models)
+	assert.Empty(t, eu.models)
+}
+
+func TestRouterCreateKnowledgeBase(t *testing.T) {
+	service := NewRouterRAG(&DisabledRAG{}, map[string]RAGService{"eu": &DisabledRAG{}}, log.NewLogger(&config.Config{}))
+	id, err := service.CreateKnowledgeBase(context.Background(), "docs", "eu")
+	require.NoError(t, err)
+	assert.Equal(t, "eu", DatasetProvider(id))
+	id, err = service.CreateKnowledgeBase(context.Background(), "docs", "")
+	require.NoError(t, err)
+	assert.Empty(t, DatasetProvider(id))
+	_, err = service.CreateKnowledgeBase(context.Background(), "docs", "us")
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestRouterQueryRecords(t *testing.T) {
+	tests := []struct {
+		name        string
+		datasetIDs  []string
+		wantErr     error
+		wantDefault []string
+		wantEU      []string
+		wantChunks  []string
+	}{
+		{name: "default provider", datasetIDs: []string{"a"}, wantDefault: []string{"query_records"}, wantChunks: []string{"a"}},
+		{name: "named instance", datasetIDs: []string{"eu:b"}, wantEU: []string{"query_records"}, wantChunks: []string{"eu:b"}},
+		{name: "several datasets of one instance", datasetIDs: []string{"eu:b", "eu:c"}, wantEU: []string{"query_records"}, wantChunks: []string{"eu:b", "eu:c"}},
+		{name: "unknown instance prefix stays on the default provider", datasetIDs: []string{"us:d"}, wantDefault: []string{"query_records"}, wantChunks: []string{"us:d"}},
+		{name: "datasets across instances are rejected", datasetIDs: []string{"a", "eu:b"}, wantErr: ErrInvalidRequest},
+		{name: "datasets across instances in any order are rejected", datasetIDs: []string{"eu:b", "a"}, wantErr: ErrInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultService, eu := &recordingRAG{}, &recordingRAG{}
+			service := NewRouterRAG(defaultService, map[string]RAGService{"eu": eu}, log.NewLogger(&config.Config{}))
+			res, err := service.QueryRecords(context.Background(), &QueryRecordsRequest{DatasetIDs: tt.datasetIDs, Query: "q"})
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				var datasetIDs []string
+				for _, chunk := range res.Chunks {
+					datasetIDs = append(datasetIDs, chunk.DatasetID)
+				}
+				assert.Equal(t, tt.wantChunks, datasetIDs, "chunks carry the routed dataset IDs")
+			}
+			assert.Equal(t, tt.wantDefault, defaultService.recorded())
+			assert.Equal(t, tt.wantEU, eu.recorded())
+		})
+	}
+}
+
+func TestRouterModelReadsUseDefaultProvider(t *testing.T) {
+	defaultService, eu := &recordingRAG{}, &recordingRAG{}
+	service := NewRouterRAG(defaultService, map[string]RAGService{"eu": eu}, log.NewLogger(&config.Config{}))
+	models, err := service.GetModelList(context.Background())
+	require.NoError(t, err)
+	require.Len(t, models, 1)
+	model, err := service.GetModel(context.Background(), models[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, "m1", model.ID)
+	assert.Equal(t, []string{"get_model_list", "get_model"}, defaultService.recorded())
+	assert.Empty(t, eu.recorded())
+}
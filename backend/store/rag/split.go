@@ -0,0 +1,140 @@
+package rag
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// PartDocID derives the doc ID of part i of a split document, part 0 keeps the base doc ID.
+func PartDocID(baseDocID string, i int) string {
+	if i == 0 {
+		return baseDocID
+	}
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("%s#part-%d", baseDocID, i))).String()
+}
+
+// splitMarkdown cuts markdown into parts of at most maxSize bytes. Parts end at block
+// boundaries: headings and blank lines outside fenced code. A single block larger than
+// maxSize is cut by lines, and the fence is repeated around every piece of a code block.
+func splitMarkdown(markdown string, maxSize int) []string {
+	if len(markdown) <= maxSize {
+		return []string{markdown}
+	}
+	var parts []string
+	var current strings.Builder
+	flush := func() {
+		if strings.TrimSpace(current.String()) != "" {
+			parts = append(parts, current.String())
+		}
+		current.Reset()
+	}
+	for _, block := range markdownBlocks(markdown) {
+		if current.Len()+len(block) > maxSize {
+			flush()
+		}
+		if len(block) <= maxSize {
+			current.WriteString(block)
+			continue
+		}
+		parts = append(parts, splitBlock(block, maxSize)...)
+	}
+	flush()
+	return parts
+}
+
+// markdownBlocks splits markdown before headings and after blank lines, keeping fenced code blocks whole.
+func markdownBlocks(markdown string) []string {
+	var blocks []string
+	var current strings.Builder
+	fence := ""
+	for _, line := range strings.SplitAfter(markdown, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if fence == "" {
+			if strings.HasPrefix(trimmed, "#") && current.Len() > 0 {
+				blocks = append(blocks, current.String())
+				current.Reset()
+			}
+			if f := fenceMarker(trimmed); f != "" {
+				fence = f
+			}
+			current.WriteString(line)
+			if trimmed == "" && fence == "" {
+				blocks = append(blocks, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteString(line)
+		if strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			fence = ""
+		}
+	}
+	if current.Len() > 0 {
+		blocks = append(blocks, current.String())
+	}
+	return blocks
+}
+
+// fenceMarker returns the fence opening a code block on line, e.g. "```" or "~~~~".
+func fenceMarker(line string) string {
+	for _, c := range []string{"`", "~"} {
+		n := len(line) - len(strings.TrimLeft(line, c))
+		if n >= 3 {
+			return strings.Repeat(c, n)
+		}
+	}
+	return ""
+}
+
+// splitBlock cuts an oversized block by lines, lines longer than maxSize are cut at rune boundaries.
+// A fenced code block gets its fence repeated around every piece.
+func splitBlock(block string, maxSize int) []string {
+	lines := strings.SplitAfter(block, "\n")
+	open, close := "", ""
+	if f := fenceMarker(strings.TrimSpace(lines[0])); f != "" && len(lines) > 1 {
+		open, lines = lines[0], lines[1:]
+		if last := strings.TrimSpace(lines[len(lines)-1]); strings.HasPrefix(last, f) {
+			close, lines = lines[len(lines)-1], lines[:len(lines)-1]
+		} else {
+			close = f + "\n"
+		}
+		if !strings.HasSuffix(close, "\n") {
+			close += "\n"
+		}
+	}
+	// one more byte for the newline ending a cut line
+	budget := max(maxSize-len(open)-len(close)-1, 1)
+	var pieces []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			pieces = append(pieces, open+current.String()+close)
+			current.Reset()
+		}
+	}
+	for _, line := range lines {
+		if current.Len()+len(line) > budget {
+			flush()
+		}
+		for len(line) > budget {
+			cut := budget
+			for cut > 0 && !isRuneStart(line[cut]) {
+				cut--
+			}
+			if cut == 0 {
+				cut = budget
+			}
+			pieces = append(pieces, open+line[:cut]+"\n"+close)
+			line = line[cut:]
+		}
+		current.WriteString(line)
+	}
+	flush()
+	return pieces
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
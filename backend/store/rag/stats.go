@@ -0,0 +1,59 @@
+package rag
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+// RetrievalStats describes the retrieve step of a query, to debug slow or empty answers.
+type RetrievalStats struct {
+	Provider string
+	// Duration is the time spent retrieving, rerank and the per chunk stages excluded
+	Duration time.Duration
+	// Candidates counts the chunks the provider returned, before any filtering
+	Candidates          int
+	FilteredByThreshold int
+	// FilteredByMetadata counts chunks dropped for the metadata of their document: archived,
+	// excluded or missing tags and out of the date range. Groups are filtered by the provider.
+	FilteredByMetadata int
+}
+
+// retrievalCounter collects the counts of RetrievalStats from concurrent retrieves.
+type retrievalCounter struct {
+	candidates     atomic.Int64
+	belowThreshold atomic.Int64
+	byMetadata     atomic.Int64
+}
+
+func (c *retrievalCounter) stats(provider string, d time.Duration) *RetrievalStats {
+	return &RetrievalStats{
+		Provider:            provider,
+		Duration:            d,
+		Candidates:          int(c.candidates.Load()),
+		FilteredByThreshold: int(c.belowThreshold.Load()),
+		FilteredByMetadata:  int(c.byMetadata.Load()),
+	}
+}
+
+// filterThreshold drops the chunks scoring below threshold and counts what it saw.
+func (c *retrievalCounter) filterThreshold(chunks []*domain.NodeContentChunk, threshold float64) []*domain.NodeContentChunk {
+	c.candidates.Add(int64(len(chunks)))
+	if threshold <= 0 {
+		return chunks
+	}
+	kept := chunks[:0]
+	for _, chunk := range chunks {
+		if chunk.Score >= threshold {
+			kept = append(kept, chunk)
+		}
+	}
+	c.belowThreshold.Add(int64(len(chunks) - len(kept)))
+	return kept
+}
+
+// countMetadataFiltered records the chunks the document metadata filters dropped.
+func (c *retrievalCounter) countMetadataFiltered(before, after int) {
+	c.byMetadata.Add(int64(before - after))
+}
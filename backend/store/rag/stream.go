@@ -0,0 +1,77 @@
+package rag
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+// QueryRecordsStream sends the chunks of a query as soon as each is ready. Ranking needs all
+// candidates, so the first chunk waits for retrieval and rerank, but neighbor expansion and
+// highlighting run per chunk while the caller consumes the ones before.
+// The error channel gets at most one error, both channels are closed when the query ends.
+// Callers must drain the chunks or cancel ctx.
+func (s *CTRAG) QueryRecordsStream(ctx context.Context, req *QueryRecordsRequest) (<-chan *domain.NodeContentChunk, <-chan error) {
+	chunks := make(chan *domain.NodeContentChunk)
+	errs := make(chan error, 1)
+	ctx, span := s.startSpan(ctx, "QueryRecordsStream", datasetAttr(strings.Join(req.datasetIDs(), ",")), attribute.Int("rag.query_length", utf8.RuneCountInString(req.Query)))
+	go func() {
+		var err error
+		sent := 0
+		defer func() {
+			span.SetAttributes(resultCountAttr(sent))
+			endSpan(span, err)
+		}()
+		defer close(errs)
+		defer close(chunks)
+		res, err := s.queryPage(ctx, req)
+		if err != nil {
+			errs <- err
+			return
+		}
+		finish := s.chunkFinisher(req, res)
+		for _, chunk := range res.Chunks {
+			if chunk = finish(ctx, chunk); chunk == nil {
+				continue
+			}
+			select {
+			case chunks <- chunk:
+				sent++
+			case <-ctx.Done():
+				err = ctx.Err()
+				errs <- err
+				return
+			}
+		}
+	}()
+	return chunks, errs
+}
+
+// streamQueryResult streams the chunks of a query run by query, for providers without
+// incremental results.
+func streamQueryResult(ctx context.Context, query func(ctx context.Context) (*QueryResult, error)) (<-chan *domain.NodeContentChunk, <-chan error) {
+	chunks := make(chan *domain.NodeContentChunk)
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		defer close(chunks)
+		res, err := query(ctx)
+		if err != nil {
+			errs <- err
+			return
+		}
+		for _, chunk := range res.Chunks {
+			select {
+			case chunks <- chunk:
+			case <-ctx.Done():
+				errs <- ctx.Err()
+				return
+			}
+		}
+	}()
+	return chunks, errs
+}
@@ -0,0 +1,225 @@
+package rag
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/JohannesKaufmann/dom"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"golang.org/x/net/html"
+)
+
+// maxTableSpan caps colspan and rowspan, larger values are typos or layout tricks
+const maxTableSpan = 100
+
+// tableBlockTags are cell contents a one-line markdown cell cannot hold.
+var tableBlockTags = []string{"ul", "ol", "dl", "table", "pre", "blockquote", "hr", "h1", "h2", "h3", "h4", "h5", "h6"}
+
+// renderTable writes a table as a GitHub flavored markdown table. Spanned cells repeat their
+// content in every row and column they cover, and the first row is the header. Tables with
+// block content in a cell, such as lists or nested tables, are written as html instead.
+func renderTable(ctx converter.Context, w converter.Writer, n *html.Node) converter.RenderStatus {
+	rows := tableRows(n)
+	if len(rows) == 0 {
+		return converter.RenderTryNext
+	}
+	w.WriteString("\n\n")
+	if tableHasBlockCell(rows) {
+		writeCompactHTML(w, n)
+		w.WriteString("\n\n")
+		return converter.RenderSuccess
+	}
+	// the caption becomes a paragraph above the table
+	if captions := elementChildren(n, "caption"); len(captions) > 0 {
+		w.WriteString(renderCell(ctx, captions[0]))
+		w.WriteString("\n\n")
+	}
+	writeMarkdownTable(w, tableGrid(rows, func(cell *html.Node) string { return renderCell(ctx, cell) }))
+	w.WriteString("\n\n")
+	return converter.RenderSuccess
+}
+
+// tableRows returns the rows of a table, the thead rows first and the tfoot rows last.
+// Rows of nested tables are not included.
+func tableRows(table *html.Node) []*html.Node {
+	var head, body, foot []*html.Node
+	for child := table.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		switch child.Data {
+		case "tr":
+			body = append(body, child)
+		case "thead":
+			head = append(head, elementChildren(child, "tr")...)
+		case "tbody":
+			body = append(body, elementChildren(child, "tr")...)
+		case "tfoot":
+			foot = append(foot, elementChildren(child, "tr")...)
+		}
+	}
+	return append(append(head, body...), foot...)
+}
+
+func rowCells(row *html.Node) []*html.Node {
+	return elementChildren(row, "td", "th")
+}
+
+func elementChildren(n *html.Node, tags ...string) []*html.Node {
+	var children []*html.Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != html.ElementNode {
+			continue
+		}
+		for _, tag := range tags {
+			if child.Data == tag {
+				children = append(children, child)
+				break
+			}
+		}
+	}
+	return children
+}
+
+func tableHasBlockCell(rows []*html.Node) bool {
+	for _, row := range rows {
+		for _, cell := range rowCells(row) {
+			if dom.FindFirstNode(cell, func(c *html.Node) bool { return isTableBlock(c) }) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isTableBlock(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	for _, tag := range tableBlockTags {
+		if n.Data == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// tableSpan reads a colspan or rowspan attribute, missing and invalid values are 1.
+func tableSpan(cell *html.Node, key string) int {
+	v, _ := dom.GetAttribute(cell, key)
+	span, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || span < 1 {
+		return 1
+	}
+	return min(span, maxTableSpan)
+}
+
+// tableGrid lays the cells of rows out in a grid, a spanned cell fills every slot it covers.
+func tableGrid(rows []*html.Node, render func(cell *html.Node) string) [][]string {
+	grid := make([][]string, len(rows))
+	filled := make([][]bool, len(rows))
+	set := func(r, c int, text string) {
+		for len(grid[r]) <= c {
+			grid[r] = append(grid[r], "")
+			filled[r] = append(filled[r], false)
+		}
+		grid[r][c], filled[r][c] = text, true
+	}
+	for r, row := range rows {
+		c := 0
+		for _, cell := range rowCells(row) {
+			for c < len(filled[r]) && filled[r][c] {
+				c++
+			}
+			text := render(cell)
+			colspan, rowspan := tableSpan(cell, "colspan"), tableSpan(cell, "rowspan")
+			for dr := 0; dr < rowspan && r+dr < len(rows); dr++ {
+				for dc := 0; dc < colspan; dc++ {
+					set(r+dr, c+dc, text)
+				}
+			}
+			c += colspan
+		}
+	}
+	return grid
+}
+
+// renderCell renders the content of a cell on one line, line breaks become <br>.
+func renderCell(ctx converter.Context, cell *html.Node) string {
+	var sb strings.Builder
+	ctx.RenderChildNodes(ctx, &sb, cell)
+	var lines []string
+	for _, line := range strings.Split(sb.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.ReplaceAll(strings.Join(lines, "<br>"), "|", `\|`)
+}
+
+func writeMarkdownTable(w converter.Writer, grid [][]string) {
+	width := 0
+	for _, row := range grid {
+		width = max(width, len(row))
+	}
+	writeRow := func(cells []string) {
+		w.WriteString("|")
+		for i := 0; i < width; i++ {
+			cell := ""
+			if i < len(cells) {
+				cell = cells[i]
+			}
+			w.WriteString(" " + cell + " |")
+		}
+		w.WriteString("\n")
+	}
+	writeRow(grid[0])
+	w.WriteString(strings.Repeat("| --- ", width) + "|\n")
+	for _, row := range grid[1:] {
+		writeRow(row)
+	}
+}
+
+// compactHTMLAttrs are the attributes writeCompactHTML keeps.
+var compactHTMLAttrs = []string{"colspan", "rowspan", "href", "src", "alt"}
+
+// voidTags have no closing tag.
+var voidTags = []string{"br", "hr", "img"}
+
+// writeCompactHTML writes n as html without the attributes that only matter for display,
+// whitespace runs in text collapse to one space.
+func writeCompactHTML(w converter.Writer, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		text := strings.Join(strings.Fields(n.Data), " ")
+		if text == "" {
+			return
+		}
+		if strings.TrimLeft(n.Data[:1], " \t\r\n") == "" && n.PrevSibling != nil {
+			text = " " + text
+		}
+		if strings.TrimRight(n.Data[len(n.Data)-1:], " \t\r\n") == "" && n.NextSibling != nil {
+			text += " "
+		}
+		w.WriteString(html.EscapeString(text))
+	case html.ElementNode:
+		w.WriteString("<" + n.Data)
+		for _, attr := range n.Attr {
+			for _, key := range compactHTMLAttrs {
+				if attr.Key == key {
+					w.WriteString(" " + key + `="` + html.EscapeString(attr.Val) + `"`)
+				}
+			}
+		}
+		w.WriteString(">")
+		for _, tag := range voidTags {
+			if n.Data == tag {
+				return
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			writeCompactHTML(w, child)
+		}
+		w.WriteString("</" + n.Data + ">")
+	}
+}
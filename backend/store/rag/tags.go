@@ -0,0 +1,44 @@
+package rag
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/chaitin/panda-wiki/domain"
+)
+
+// TagMatchMode is how the tags of QueryRecordsRequest.Tags combine.
+type TagMatchMode string
+
+const (
+	// TagMatchAny matches documents carrying at least one of the tags, it is the default
+	// and what raglite's tag filter does
+	TagMatchAny TagMatchMode = "any"
+	// TagMatchAll matches documents carrying every tag
+	TagMatchAll TagMatchMode = "all"
+)
+
+func (m TagMatchMode) resolve() (TagMatchMode, error) {
+	switch m {
+	case "":
+		return TagMatchAny, nil
+	case TagMatchAny, TagMatchAll:
+		return m, nil
+	default:
+		return "", fmt.Errorf("%w: unsupported tag match mode: %s", ErrInvalidRequest, m)
+	}
+}
+
+// requireTags keeps chunks of documents carrying all of tags.
+// Documents that cannot be found are dropped.
+func requireTags(chunks []*domain.NodeContentChunk, docs map[string]Document, tags []string) []*domain.NodeContentChunk {
+	kept := make([]*domain.NodeContentChunk, 0, len(chunks))
+	for _, chunk := range chunks {
+		doc, ok := docs[chunk.DocID]
+		if !ok || slices.ContainsFunc(tags, func(tag string) bool { return !slices.Contains(doc.Tags, tag) }) {
+			continue
+		}
+		kept = append(kept, chunk)
+	}
+	return kept
+}
@@ -0,0 +1,48 @@
+package rag
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of the spans of this package.
+const tracerName = "github.com/chaitin/panda-wiki/store/rag"
+
+// WithTracer starts a span for every CTRAG method, without it spans are not recorded.
+func WithTracer(t trace.Tracer) CTRAGOption {
+	return func(s *CTRAG) {
+		if t != nil {
+			s.tracer = t
+		}
+	}
+}
+
+func noopTracer() trace.Tracer {
+	return noop.NewTracerProvider().Tracer(tracerName)
+}
+
+// startSpan starts the span of the CTRAG method op.
+func (s *CTRAG) startSpan(ctx context.Context, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "rag."+op, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, marking it failed when err is set.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func datasetAttr(datasetID string) attribute.KeyValue {
+	return attribute.String("rag.dataset_id", datasetID)
+}
+
+func resultCountAttr(n int) attribute.KeyValue {
+	return attribute.Int("rag.result_count", n)
+}
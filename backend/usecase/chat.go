@@ -311,6 +311,11 @@ func (u *ChatUsecase) ChatRagOnly(ctx context.Context, req *domain.ChatRagOnlyRe
 	go func() {
 		defer close(eventCh)
 
+		if u.llmUsecase.RAGDisabled() {
+			eventCh <- domain.SSEEvent{Type: "error", Content: "AI 搜索未启用"}
+			return
+		}
+
 		// extra1. if user set question block words then check it
 		blockWords, err := u.blockWordRepo.GetBlockWords(ctx, req.KBID)
 		if err != nil {
@@ -433,7 +438,39 @@ func (u *ChatUsecase) replaceWithSimpleString(content string, filter *utils.DFA)
 	return r1
 }
 
+func (u *ChatUsecase) BuildContextBundle(ctx context.Context, req *domain.ChatContextReq) (*domain.ContextBundle, error) {
+	groupIds, err := u.AuthRepo.GetAuthGroupIdsWithParentsByAuthId(ctx, req.AuthUserID)
+	if err != nil {
+		return nil, err
+	}
+	kb, err := u.kbRepo.GetKnowledgeBaseByID(ctx, req.KBID)
+	if err != nil {
+		return nil, err
+	}
+	return u.llmUsecase.BuildContextBundle(ctx, kb, GetRankNodesRequest{
+		DatasetID:           kb.DatasetID,
+		Question:            req.Message,
+		GroupIDs:            groupIds,
+		SimilarityThreshold: 0.2,
+	}, req.MaxTokens)
+}
+
+func (u *ChatUsecase) CheckAnswerability(ctx context.Context, req *domain.AnswerabilityReq) (*domain.Answerability, error) {
+	groupIds, err := u.AuthRepo.GetAuthGroupIdsWithParentsByAuthId(ctx, req.AuthUserID)
+	if err != nil {
+		return nil, err
+	}
+	kb, err := u.kbRepo.GetKnowledgeBaseByID(ctx, req.KBID)
+	if err != nil {
+		return nil, err
+	}
+	return u.llmUsecase.CheckAnswerability(ctx, kb, req.Message, groupIds)
+}
+
 func (u *ChatUsecase) Search(ctx context.Context, req *domain.ChatSearchReq) (*domain.ChatSearchResp, error) {
+	if u.llmUsecase.RAGDisabled() {
+		return &domain.ChatSearchResp{NodeResult: []domain.NodeContentChunkSSE{}, RAGDisabled: true}, nil
+	}
 	groupIds, err := u.AuthRepo.GetAuthGroupIdsWithParentsByAuthId(ctx, req.AuthUserID)
 	if err != nil {
 		return nil, err
@@ -3,6 +3,7 @@ package usecase
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -27,25 +28,37 @@ type KnowledgeBaseUsecase struct {
 	kbCache  *cache.KBRepo
 	logger   *log.Logger
 	config   *config.Config
+
+	// resyncing holds the KBs with a permission re-sync running, true when another run was
+	// requested meanwhile
+	resyncMu  sync.Mutex
+	resyncing map[string]bool
 }
 
 func NewKnowledgeBaseUsecase(repo *pg.KnowledgeBaseRepository, nodeRepo *pg.NodeRepository, ragRepo *mq.RAGRepository, userRepo *pg.UserRepository, rag rag.RAGService, kbCache *cache.KBRepo, logger *log.Logger, config *config.Config) (*KnowledgeBaseUsecase, error) {
 	u := &KnowledgeBaseUsecase{
-		repo:     repo,
-		nodeRepo: nodeRepo,
-		ragRepo:  ragRepo,
-		userRepo: userRepo,
-		rag:      rag,
-		logger:   logger.WithModule("usecase.knowledge_base"),
-		config:   config,
-		kbCache:  kbCache,
+		repo:      repo,
+		nodeRepo:  nodeRepo,
+		ragRepo:   ragRepo,
+		userRepo:  userRepo,
+		rag:       rag,
+		logger:    logger.WithModule("usecase.knowledge_base"),
+		config:    config,
+		kbCache:   kbCache,
+		resyncing: make(map[string]bool),
 	}
 	return u, nil
 }
 
 func (u *KnowledgeBaseUsecase) CreateKnowledgeBase(ctx context.Context, req *domain.CreateKnowledgeBaseReq) (string, error) {
+	// raglite datasets take no chunking options, the chunking is stored with the kb and applied on upload
+	if req.Chunking != nil {
+		if err := rag.ValidateChunking(req.Chunking.Size, req.Chunking.Overlap, rag.ChunkStrategy(req.Chunking.Strategy), u.config.RAG.Chunking); err != nil {
+			return "", err
+		}
+	}
 	// create kb in vector store
-	datasetID, err := u.rag.CreateKnowledgeBase(ctx)
+	datasetID, err := u.rag.CreateKnowledgeBase(ctx, req.Name, req.RAGProvider)
 	if err != nil {
 		return "", err
 	}
@@ -54,6 +67,7 @@ func (u *KnowledgeBaseUsecase) CreateKnowledgeBase(ctx context.Context, req *dom
 		ID:        kbID,
 		Name:      req.Name,
 		DatasetID: datasetID,
+		Chunking:  req.Chunking,
 		AccessSettings: domain.AccessSettings{
 			Ports:      req.Ports,
 			SSLPorts:   req.SSLPorts,
@@ -86,11 +100,24 @@ func (u *KnowledgeBaseUsecase) GetKnowledgeBaseListByUserId(ctx context.Context)
 }
 
 func (u *KnowledgeBaseUsecase) UpdateKnowledgeBase(ctx context.Context, req *domain.UpdateKnowledgeBaseReq) error {
+	var oldKB *domain.KnowledgeBase
+	if req.AccessSettings != nil {
+		kb, err := u.repo.GetKnowledgeBaseByID(ctx, req.ID)
+		if err != nil {
+			return err
+		}
+		oldKB = kb
+	}
 	isChange, err := u.repo.UpdateKnowledgeBase(ctx, req)
 	if err != nil {
 		return err
 	}
 
+	// switching between public and group-restricted rewrites the permissions of every indexed document
+	if oldKB != nil && oldKB.AccessSettings.EnterpriseAuth.Enabled != req.AccessSettings.EnterpriseAuth.Enabled {
+		u.StartPermissionResync(ctx, req.ID, false)
+	}
+
 	if isChange {
 		if err := u.kbCache.ClearSession(ctx); err != nil {
 			return err
@@ -104,6 +131,97 @@ func (u *KnowledgeBaseUsecase) UpdateKnowledgeBase(ctx context.Context, req *dom
 	return nil
 }
 
+// StartPermissionResync runs ResyncPermissions in background, outliving the request of ctx but
+// keeping its values such as the request ID. A KB re-syncs once at a time, a start while it runs
+// is queued as one more full run, the running one may have resolved outdated settings.
+func (u *KnowledgeBaseUsecase) StartPermissionResync(ctx context.Context, kbID string, resume bool) {
+	u.resyncMu.Lock()
+	if _, running := u.resyncing[kbID]; running {
+		u.resyncing[kbID] = true
+		u.resyncMu.Unlock()
+		return
+	}
+	u.resyncing[kbID] = false
+	u.resyncMu.Unlock()
+
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		for {
+			if _, err := u.ResyncPermissions(ctx, kbID, resume); err != nil {
+				u.logger.WithContext(ctx).Error("resync permissions failed", log.String("kb_id", kbID), log.Error(err))
+			}
+			u.resyncMu.Lock()
+			again := u.resyncing[kbID]
+			if again {
+				u.resyncing[kbID] = false
+			} else {
+				delete(u.resyncing, kbID)
+			}
+			u.resyncMu.Unlock()
+			if !again {
+				return
+			}
+			resume = false
+		}
+	}()
+}
+
+// ResyncPermissions rewrites the group IDs and visibility of every indexed document of a KB.
+// With resume an unfinished run recorded in the cache continues from its cursor.
+func (u *KnowledgeBaseUsecase) ResyncPermissions(ctx context.Context, kbID string, resume bool) (*rag.ResyncReport, error) {
+	kb, err := u.repo.GetKnowledgeBaseByID(ctx, kbID)
+	if err != nil {
+		return nil, err
+	}
+	opts := rag.ResyncOptions{
+		Progress: func(report *rag.ResyncReport) {
+			if err := u.kbCache.SetPermissionResync(ctx, kbID, report); err != nil {
+				u.logger.Error("save permission resync progress failed", log.String("kb_id", kbID), log.Error(err))
+			}
+			u.logger.Info("resync permissions progress", log.String("kb_id", kbID), log.Any("report", report))
+		},
+	}
+	if resume {
+		if opts.Resume, err = u.kbCache.GetPermissionResync(ctx, kbID); err != nil {
+			return nil, err
+		}
+	}
+	ctx = rag.WithPriority(ctx, rag.PriorityBackground)
+	return rag.ResyncPermissions(ctx, u.rag, kb.DatasetID, u.permissionResolver(ctx, kb), opts)
+}
+
+// GetPermissionResync returns the progress of the last permission re-sync of a KB
+func (u *KnowledgeBaseUsecase) GetPermissionResync(ctx context.Context, kbID string) (*rag.ResyncReport, error) {
+	return u.kbCache.GetPermissionResync(ctx, kbID)
+}
+
+// permissionResolver mirrors the group IDs written on upsert, every document is public
+// while the KB has no enterprise auth.
+func (u *KnowledgeBaseUsecase) permissionResolver(ctx context.Context, kb *domain.KnowledgeBase) rag.PermissionResolver {
+	return func(docID string) ([]int, string, error) {
+		if !kb.AccessSettings.EnterpriseAuth.Enabled {
+			return nil, string(consts.NodeAccessPermOpen), nil
+		}
+		releases, err := u.nodeRepo.GetNodeReleasesWithPathsByDocIDs(ctx, []string{docID})
+		if err != nil {
+			return nil, "", err
+		}
+		release, ok := releases[docID]
+		if !ok {
+			return nil, "", fmt.Errorf("node release of doc %s not found", docID)
+		}
+		node, err := u.nodeRepo.GetNodeByID(ctx, release.NodeID)
+		if err != nil {
+			return nil, "", err
+		}
+		groupIds, err := u.nodeRepo.GetNodeAuthGroupIdsByNodeId(ctx, release.NodeID, consts.NodePermNameAnswerable)
+		if err != nil {
+			return nil, "", err
+		}
+		return groupIds, string(node.Permissions.Answerable), nil
+	}
+}
+
 func (u *KnowledgeBaseUsecase) GetKnowledgeBase(ctx context.Context, kbID string) (*domain.KnowledgeBase, error) {
 	kb, err := u.kbCache.GetKB(ctx, kbID)
 	if err != nil {
@@ -132,6 +250,11 @@ func (u *KnowledgeBaseUsecase) GetKnowledgeBasePerm(ctx context.Context, kbID st
 	return perm, nil
 }
 
+// GetRAGCapabilities reports what the configured RAG provider supports
+func (u *KnowledgeBaseUsecase) GetRAGCapabilities() rag.Capabilities {
+	return u.rag.Capabilities()
+}
+
 func (u *KnowledgeBaseUsecase) DeleteKnowledgeBase(ctx context.Context, kbID string) error {
 	if err := u.repo.DeleteKnowledgeBase(ctx, kbID); err != nil {
 		return err
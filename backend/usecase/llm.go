@@ -32,6 +32,7 @@ type LLMUsecase struct {
 	nodeRepo         *pg.NodeRepository
 	modelRepo        *pg.ModelRepository
 	promptRepo       *pg.PromptRepo
+	authRepo         *pg.AuthRepo
 	config           *config.Config
 	logger           *log.Logger
 	modelkit         *modelkit.ModelKit
@@ -42,7 +43,7 @@ const (
 	summaryMaxChunks       = 4     // max chunks to process for summary
 )
 
-func NewLLMUsecase(config *config.Config, rag rag.RAGService, conversationRepo *pg.ConversationRepository, kbRepo *pg.KnowledgeBaseRepository, nodeRepo *pg.NodeRepository, modelRepo *pg.ModelRepository, promptRepo *pg.PromptRepo, logger *log.Logger) *LLMUsecase {
+func NewLLMUsecase(config *config.Config, rag rag.RAGService, conversationRepo *pg.ConversationRepository, kbRepo *pg.KnowledgeBaseRepository, nodeRepo *pg.NodeRepository, modelRepo *pg.ModelRepository, promptRepo *pg.PromptRepo, authRepo *pg.AuthRepo, logger *log.Logger) *LLMUsecase {
 	tiktoken.SetBpeLoader(&utils.Localloader{})
 	modelkit := modelkit.NewModelKit(logger.Logger)
 	return &LLMUsecase{
@@ -53,6 +54,7 @@ func NewLLMUsecase(config *config.Config, rag rag.RAGService, conversationRepo *
 		nodeRepo:         nodeRepo,
 		modelRepo:        modelRepo,
 		promptRepo:       promptRepo,
+		authRepo:         authRepo,
 		logger:           logger.WithModule("usecase.llm"),
 		modelkit:         modelkit,
 	}
@@ -110,18 +112,19 @@ func (u *LLMUsecase) BuildConversationMessageWithRAG(
 				u.logger.Error("get kb failed", log.Error(err))
 				return nil, nil, errors.New("get kb failed")
 			}
-			rewrittenQuery, rankedNodes, err = u.GetRankNodes(ctx, GetRankNodesRequest{
+			bundle, err := u.BuildContextBundle(ctx, kb, GetRankNodesRequest{
 				DatasetID:           kb.DatasetID,
 				Question:            question,
 				GroupIDs:            groupIDs,
 				SimilarityThreshold: 0.2,
 				HistoryMessages:     historyMessages[:len(historyMessages)-1],
-			})
+			}, 0)
 			if err != nil {
 				u.logger.Error("get rank nodes failed", log.Error(err))
 				return nil, nil, errors.New("get rank nodes failed")
 			}
-			documents := domain.FormatNodeChunks(rankedNodes, kb.AccessSettings.BaseURL)
+			rewrittenQuery, rankedNodes = bundle.RewrittenQuery, bundle.RankedNodes
+			documents := bundle.Prompt
 			u.logger.Debug("documents", log.String("documents", documents))
 
 			formattedMessages, err := template.Format(ctx, map[string]any{
@@ -320,8 +323,66 @@ func (u *LLMUsecase) SplitByTokenLimit(text string, maxTokens int) ([]string, er
 	return result, nil
 }
 
+// BuildContextBundle runs retrieval and packs the ranked chunks within maxTokens (0 means no limit).
+// The chat prompt and the context export are both built from it so they never diverge.
+func (u *LLMUsecase) BuildContextBundle(ctx context.Context, kb *domain.KnowledgeBase, req GetRankNodesRequest, maxTokens int) (*domain.ContextBundle, error) {
+	rewrittenQuery, rankedNodes, err := u.GetRankNodes(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	encoding, err := tiktoken.GetEncoding("cl100k_base")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get encoding: %w", err)
+	}
+	baseURL := kb.AccessSettings.BaseURL
+	bundle := &domain.ContextBundle{
+		Query:          req.Question,
+		RewrittenQuery: rewrittenQuery,
+		Documents:      make([]domain.ContextBundleDocument, 0, len(rankedNodes)),
+		MaxTokens:      maxTokens,
+		RankedNodes:    make([]*domain.RankedNodeChunks, 0, len(rankedNodes)),
+	}
+packing:
+	for _, node := range rankedNodes {
+		doc := domain.ContextBundleDocument{
+			NodeID:    node.NodeID,
+			Title:     node.NodeName,
+			URL:       node.GetURL(baseURL),
+			PathNames: node.NodePathNames,
+		}
+		packedNode := *node
+		packedNode.Chunks = make([]*domain.NodeContentChunk, 0, len(node.Chunks))
+		for _, chunk := range node.Chunks {
+			tokens := len(encoding.Encode(chunk.Content, nil, nil))
+			if maxTokens > 0 && bundle.TotalTokens+tokens > maxTokens {
+				bundle.Truncated = true
+				if len(packedNode.Chunks) > 0 {
+					bundle.Documents = append(bundle.Documents, doc)
+					bundle.RankedNodes = append(bundle.RankedNodes, &packedNode)
+				}
+				break packing
+			}
+			bundle.TotalTokens += tokens
+			doc.Chunks = append(doc.Chunks, domain.ContextBundleChunk{
+				ID:       chunk.ID,
+				Content:  chunk.Content,
+				Tokens:   tokens,
+				Metadata: chunk.Metadata,
+			})
+			packedNode.Chunks = append(packedNode.Chunks, chunk)
+		}
+		bundle.Documents = append(bundle.Documents, doc)
+		bundle.RankedNodes = append(bundle.RankedNodes, &packedNode)
+	}
+	bundle.Prompt = domain.FormatNodeChunks(bundle.RankedNodes, baseURL)
+	bundle.Markdown = bundle.RenderMarkdown()
+	return bundle, nil
+}
+
 type GetRankNodesRequest struct {
-	DatasetID           string
+	DatasetID string
+	// DatasetIDs adds more datasets to query concurrently with DatasetID
+	DatasetIDs          []string
 	Question            string
 	GroupIDs            []int
 	SimilarityThreshold float64
@@ -329,11 +390,17 @@ type GetRankNodesRequest struct {
 	MaxChunksPerDoc     int
 }
 
+// RAGDisabled reports whether the wiki runs without AI search
+func (u *LLMUsecase) RAGDisabled() bool {
+	return rag.IsDisabled(u.rag)
+}
+
 func (u *LLMUsecase) GetRankNodes(ctx context.Context, req GetRankNodesRequest) (string, []*domain.RankedNodeChunks, error) {
 	var rankedNodes []*domain.RankedNodeChunks
 	// get related documents from raglite
-	rewrittenQuery, records, err := u.rag.QueryRecords(ctx, &rag.QueryRecordsRequest{
+	res, err := u.rag.QueryRecords(ctx, &rag.QueryRecordsRequest{
 		DatasetID:           req.DatasetID,
+		DatasetIDs:          req.DatasetIDs,
 		Query:               req.Question,
 		GroupIDs:            req.GroupIDs,
 		SimilarityThreshold: req.SimilarityThreshold,
@@ -343,7 +410,8 @@ func (u *LLMUsecase) GetRankNodes(ctx context.Context, req GetRankNodesRequest)
 	if err != nil {
 		return "", nil, fmt.Errorf("get records from raglite failed: %w", err)
 	}
-	u.logger.Info("get related documents from raglite", log.Any("record_count", len(records)))
+	records := res.Chunks
+	u.logger.Info("get related documents from raglite", log.Any("record_count", len(records)), log.String("query", res.OriginalQuery), log.String("rewritten_query", res.RewrittenQuery), log.Any("sub_queries", res.SubQueries), log.Any("retrieval_stats", res.Stats))
 	rankedNodesMap := make(map[string]*domain.RankedNodeChunks)
 	// get raw node by doc_id
 	if len(records) > 0 {
@@ -375,7 +443,101 @@ func (u *LLMUsecase) GetRankNodes(ctx context.Context, req GetRankNodesRequest)
 			}
 		}
 	}
-	return rewrittenQuery, rankedNodes, nil
+	return res.RewrittenQuery, rankedNodes, nil
+}
+
+const (
+	answerabilitySimilarityThreshold = 0.2
+	answerabilityFitMessages         = 500
+)
+
+// CheckAnswerability estimates from retrieval alone whether the KB can answer the question,
+// candidates are resolved to their published nodes.
+func (u *LLMUsecase) CheckAnswerability(ctx context.Context, kb *domain.KnowledgeBase, question string, groupIDs []int) (*domain.Answerability, error) {
+	calibration := domain.DefaultAnswerabilityCalibration
+	if kb.AnswerabilityCalibration != nil {
+		calibration = *kb.AnswerabilityCalibration
+	}
+	res, err := rag.CheckAnswerability(ctx, u.rag, &rag.QueryRecordsRequest{
+		DatasetID:           kb.DatasetID,
+		Query:               question,
+		GroupIDs:            groupIDs,
+		SimilarityThreshold: answerabilitySimilarityThreshold,
+	}, calibration)
+	if err != nil {
+		return nil, fmt.Errorf("check answerability failed: %w", err)
+	}
+	if len(res.Candidates) == 0 {
+		return res, nil
+	}
+	docIDNode, err := u.nodeRepo.GetNodeReleasesWithPathsByDocIDs(ctx, lo.Map(res.Candidates, func(item *domain.AnswerabilityCandidate, _ int) string {
+		return item.DocID
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("get nodes by doc ids failed: %w", err)
+	}
+	candidates := make([]*domain.AnswerabilityCandidate, 0, len(res.Candidates))
+	for _, candidate := range res.Candidates {
+		docNode, ok := docIDNode[candidate.DocID]
+		if !ok {
+			continue
+		}
+		candidate.NodeID = docNode.NodeID
+		candidate.Name = docNode.Name
+		candidates = append(candidates, candidate)
+	}
+	res.Candidates = candidates
+	return res, nil
+}
+
+// FitAnswerabilityCalibration re-runs retrieval for the latest questions with feedback
+// and fits the calibration of the KB, liked answers are the positive samples.
+func (u *LLMUsecase) FitAnswerabilityCalibration(ctx context.Context, kbID string) (*domain.AnswerabilityCalibration, error) {
+	kb, err := u.kbRepo.GetKnowledgeBaseByID(ctx, kbID)
+	if err != nil {
+		return nil, err
+	}
+	_, messages, err := u.conversationRepo.GetMessageFeedBackList(ctx, &domain.MessageListReq{
+		KBID:  kbID,
+		Pager: domain.Pager{Page: 1, PageSize: answerabilityFitMessages},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get feedback messages failed: %w", err)
+	}
+	// replay each question with the groups of its asker, as CheckAnswerability does at chat time
+	groupIDs := make(map[uint][]int)
+	feedback := make([]rag.AnswerabilityFeedback, 0, len(messages))
+	for _, message := range messages {
+		if message.Question == "" {
+			continue
+		}
+		authUserID := message.ConversationInfo.UserInfo.AuthUserID
+		if _, ok := groupIDs[authUserID]; !ok {
+			ids, err := u.authRepo.GetAuthGroupIdsWithParentsByAuthId(ctx, authUserID)
+			if err != nil {
+				return nil, fmt.Errorf("get auth groups failed: %w", err)
+			}
+			groupIDs[authUserID] = ids
+		}
+		feedback = append(feedback, rag.AnswerabilityFeedback{
+			Question: message.Question,
+			GroupIDs: groupIDs[authUserID],
+			Answered: message.Info.Score == domain.Like,
+		})
+	}
+	samples, err := rag.AnswerabilitySamples(rag.WithPriority(ctx, rag.PriorityBackground), u.rag, kb.DatasetID, answerabilitySimilarityThreshold, feedback)
+	if err != nil {
+		return nil, fmt.Errorf("retrieve feedback question failed: %w", err)
+	}
+	calibration, err := rag.FitAnswerabilityCalibration(samples)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.kbRepo.UpdateAnswerabilityCalibration(ctx, kbID, &calibration); err != nil {
+		return nil, fmt.Errorf("update answerability calibration failed: %w", err)
+	}
+	u.logger.Info("fit answerability calibration", log.String("kb_id", kbID), log.Any("calibration", calibration))
+	return &calibration, nil
 }
 
 // formatMessageWithImages converts image paths to markdown format and appends to message
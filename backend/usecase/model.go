@@ -76,7 +76,8 @@ func (u *ModelUsecase) TriggerUpsertRecords(ctx context.Context) error {
 		return fmt.Errorf("get knowledge base list failed: %w", err)
 	}
 	for _, kb := range kbList {
-		newDatasetID, err := u.ragStore.CreateKnowledgeBase(ctx)
+		// keep the dataset in the provider instance it was created in
+		newDatasetID, err := u.ragStore.CreateKnowledgeBase(ctx, kb.Name, rag.DatasetProvider(kb.DatasetID))
 		if err != nil {
 			return fmt.Errorf("create new dataset failed: %w", err)
 		}